@@ -1,100 +1,432 @@
 package configs
 
 import (
-	"os"
-	"strconv"
+	"errors"
+	"runtime"
 )
 
 type Config struct {
-	NATS     NATSConfig
-	GRPC     GRPCConfig
-	Worker   WorkerConfig
-	FFmpeg   FFmpegConfig
-	Paths    PathsConfig
-	Retry    RetryConfig
-	LogLevel string
+	NATS      NATSConfig      `yaml:"nats" toml:"nats"`
+	GRPC      GRPCConfig      `yaml:"grpc" toml:"grpc"`
+	Worker    WorkerConfig    `yaml:"worker" toml:"worker"`
+	FFmpeg    FFmpegConfig    `yaml:"ffmpeg" toml:"ffmpeg"`
+	Profiles  ProfilesConfig  `yaml:"profiles" toml:"profiles"`
+	Paths     PathsConfig     `yaml:"paths" toml:"paths"`
+	Retry     RetryConfig     `yaml:"retry" toml:"retry"`
+	Priority  PriorityConfig  `yaml:"priority" toml:"priority"`
+	Source    SourceConfig    `yaml:"source" toml:"source"`
+	Retention RetentionConfig `yaml:"retention" toml:"retention"`
+	HTTP      HTTPConfig      `yaml:"http" toml:"http"`
+	Tracing   TracingConfig   `yaml:"tracing" toml:"tracing"`
+	LogLevel  string          `yaml:"log_level" toml:"log_level"`
+	LogFormat string          `yaml:"log_format" toml:"log_format"` // text or json
+}
+
+// HTTPConfig configures the operational HTTP server (metrics, health, admin)
+type HTTPConfig struct {
+	Addr          string `yaml:"addr" toml:"addr"`
+	MinFreeDiskMB int    `yaml:"min_free_disk_mb" toml:"min_free_disk_mb"`     // Readiness fails when an output volume has less free space
+	AdminToken    string `yaml:"admin_token" toml:"admin_token" secret:"true"` // Bearer token required by the admin API; empty disables the API
+}
+
+// TracingConfig configures OpenTelemetry trace export
+type TracingConfig struct {
+	Endpoint    string `yaml:"endpoint" toml:"endpoint" secret:"url"` // OTLP gRPC collector, host:port or URL; empty disables export
+	Insecure    bool   `yaml:"insecure" toml:"insecure"`              // Connect to the collector without TLS
+	ServiceName string `yaml:"service_name" toml:"service_name"`
 }
 
 type NATSConfig struct {
-	URL      string
-	Stream   string
-	Subject  string
-	Consumer string
-	Durable  string
+	URL          string `yaml:"url" toml:"url" secret:"url"`
+	Stream       string `yaml:"stream" toml:"stream"`
+	Subject      string `yaml:"subject" toml:"subject"`
+	Consumer     string `yaml:"consumer" toml:"consumer"`
+	Durable      string `yaml:"durable" toml:"durable"`
+	ChunkSubject string `yaml:"chunk_subject" toml:"chunk_subject"` // Request subject for split-encode chunk sub-jobs
+
+	// Authentication; at most one method may be set
+	CredsFile string `yaml:"creds_file" toml:"creds_file"` // JWT and NKey seed from a .creds file
+	NKeyFile  string `yaml:"nkey_file" toml:"nkey_file"`   // NKey seed file
+	Token     string `yaml:"token" toml:"token" secret:"true"`
+	User      string `yaml:"user" toml:"user"`
+	Password  string `yaml:"password" toml:"password" secret:"true"`
+
+	TLS           TLSConfig `yaml:"tls" toml:"tls"`
+	ReconnectWait int       `yaml:"reconnect_wait" toml:"reconnect_wait"` // Seconds between reconnect attempts
+	MaxReconnects int       `yaml:"max_reconnects" toml:"max_reconnects"` // Attempts before giving up, -1 retries forever
+
+	// JetStream provisioning; with BindOnly the stream and consumer must
+	// already exist and are never changed by the worker
+	BindOnly       bool   `yaml:"bind_only" toml:"bind_only"`
+	StreamReplicas int    `yaml:"stream_replicas" toml:"stream_replicas"`
+	StreamStorage  string `yaml:"stream_storage" toml:"stream_storage"`     // file or memory
+	StreamMaxAge   int    `yaml:"stream_max_age" toml:"stream_max_age"`     // Seconds, 0 keeps messages until consumed
+	StreamMaxBytes int    `yaml:"stream_max_bytes" toml:"stream_max_bytes"` // -1 for unlimited
+	AckWait        int    `yaml:"ack_wait" toml:"ack_wait"`                 // Seconds before an unacknowledged job is redelivered
+	MaxAckPending  int    `yaml:"max_ack_pending" toml:"max_ack_pending"`   // Jobs in flight across all workers
+	Backoff        []int  `yaml:"backoff" toml:"backoff"`                   // Seconds to wait before each redelivery; the last value repeats
+
+	// Job lifecycle events, published to <EventsSubject>.started, .progress,
+	// .completed and .failed; an empty EventsSubject disables them
+	EventsSubject          string `yaml:"events_subject" toml:"events_subject"`
+	EventsStream           string `yaml:"events_stream" toml:"events_stream"`
+	EventsProgressInterval int    `yaml:"events_progress_interval" toml:"events_progress_interval"` // Seconds between progress events of a job
+
+	// Messages that fail schema validation are republished here with the
+	// rejection reason; an empty DeadLetterSubject terminates them instead
+	DeadLetterSubject string `yaml:"dead_letter_subject" toml:"dead_letter_subject"`
+	DeadLetterStream  string `yaml:"dead_letter_stream" toml:"dead_letter_stream"`
+
+	// Priority lanes, each consumed through its own durable (<Durable>-high,
+	// <Durable>-low); Subject is the normal lane and an empty subject
+	// disables a lane
+	HighPrioritySubject string `yaml:"high_priority_subject" toml:"high_priority_subject"`
+	LowPrioritySubject  string `yaml:"low_priority_subject" toml:"low_priority_subject"`
+
+	// Re-encode requests for existing videos, consumed through <Durable>-reencode
+	// with the low lane's weight; empty disables them
+	ReencodeSubject string `yaml:"reencode_subject" toml:"reencode_subject"`
 }
 
 type GRPCConfig struct {
-	VideoManagementURL string
+	VideoManagementURL string    `yaml:"video_management_url" toml:"video_management_url"`
+	TLS                TLSConfig `yaml:"tls" toml:"tls"`
+	AuthToken          string    `yaml:"auth_token" toml:"auth_token" secret:"true"` // Sent as "authorization: Bearer <token>" on every call
+	APIKey             string    `yaml:"api_key" toml:"api_key" secret:"true"`
+	APIKeyHeader       string    `yaml:"api_key_header" toml:"api_key_header"`       // Metadata key carrying APIKey
+	CallTimeout        int       `yaml:"call_timeout" toml:"call_timeout"`           // Seconds; deadline for calls made without one
+	KeepaliveTime      int       `yaml:"keepalive_time" toml:"keepalive_time"`       // Seconds between pings on an active connection, 0 disables
+	KeepaliveTimeout   int       `yaml:"keepalive_timeout" toml:"keepalive_timeout"` // Seconds to wait for a ping ack before closing the connection
 }
 
 type WorkerConfig struct {
-	ID                string
-	MaxConcurrentJobs int
+	ID                string `yaml:"id" toml:"id"`
+	MaxConcurrentJobs int    `yaml:"max_concurrent_jobs" toml:"max_concurrent_jobs"`
+
+	// Jobs are admitted while their estimated cost fits these budgets
+	CPUThreads      int `yaml:"cpu_threads" toml:"cpu_threads"`             // Threads shared by ffmpeg processes, 0 uses every CPU
+	ScratchBudgetMB int `yaml:"scratch_budget_mb" toml:"scratch_budget_mb"` // Space jobs may use under paths.temp_path, 0 is unlimited
+}
+
+// Threads returns the CPU thread budget
+func (w WorkerConfig) Threads() int {
+	if w.CPUThreads > 0 {
+		return w.CPUThreads
+	}
+	return runtime.NumCPU()
 }
 
 type FFmpegConfig struct {
-	HLSTime int
-	Preset  string
-	CRF     int
+	HLSTime      int    `yaml:"hls_time" toml:"hls_time"`
+	Preset       string `yaml:"preset" toml:"preset"`
+	CRF          int    `yaml:"crf" toml:"crf"`
+	VideoCodec   string `yaml:"video_codec" toml:"video_codec"` // h264 or hevc for the SDR ladder
+	AudioBitrate string `yaml:"audio_bitrate" toml:"audio_bitrate"`
+	Deinterlacer string `yaml:"deinterlacer" toml:"deinterlacer"`     // yadif or bwdif
+	Tonemap      string `yaml:"tonemap" toml:"tonemap"`               // tonemap filter algorithm for HDR to SDR, e.g. hable
+	HDRRendition bool   `yaml:"hdr_rendition" toml:"hdr_rendition"`   // Also keep an HDR HEVC rendition for HDR sources
+	LogTailLines int    `yaml:"log_tail_lines" toml:"log_tail_lines"` // ffmpeg stderr lines attached to failure reports
+
+	Ladder                 []int `yaml:"ladder" toml:"ladder"`                                         // Rung sizes (short side in pixels); empty keeps the source size
+	MaxFrameRate           int   `yaml:"max_frame_rate" toml:"max_frame_rate"`                         // Upper frame rate cap for every rung
+	HighFrameRateMinHeight int   `yaml:"high_frame_rate_min_height" toml:"high_frame_rate_min_height"` // Rungs below this size are capped at 30fps
+
+	Split SplitEncodeConfig `yaml:"split" toml:"split"`
+}
+
+// SplitEncodeConfig controls chunked parallel encoding of long sources
+type SplitEncodeConfig struct {
+	Enabled       bool `yaml:"enabled" toml:"enabled"`
+	MinDuration   int  `yaml:"min_duration" toml:"min_duration"`     // Seconds; shorter sources are encoded in one pass
+	ChunkDuration int  `yaml:"chunk_duration" toml:"chunk_duration"` // Target chunk length in seconds, cut on the next keyframe
+	Parallelism   int  `yaml:"parallelism" toml:"parallelism"`       // Chunks encoded at once per rendition
+	Remote        bool `yaml:"remote" toml:"remote"`                 // Dispatch chunks to other workers over NATS
+	ChunkTimeout  int  `yaml:"chunk_timeout" toml:"chunk_timeout"`   // Seconds to wait for a remote chunk before failing
 }
 
 type PathsConfig struct {
-	InputVideoPath      string
-	OutputHLSPath       string
-	OutputThumbnailPath string
+	InputVideoPath      string `yaml:"input_video_path" toml:"input_video_path"`
+	OutputHLSPath       string `yaml:"output_hls_path" toml:"output_hls_path"`
+	OutputThumbnailPath string `yaml:"output_thumbnail_path" toml:"output_thumbnail_path"`
+	TempPath            string `yaml:"temp_path" toml:"temp_path"`               // Scratch space for split-encode chunks
+	TempMaxAge          int    `yaml:"temp_max_age" toml:"temp_max_age"`         // Seconds before an untouched temp directory of no running job is removed
+	TempGCInterval      int    `yaml:"temp_gc_interval" toml:"temp_gc_interval"` // Seconds between temp directory sweeps, 0 disables them
 }
 
 type RetryConfig struct {
-	MaxRetries          int
-	RetryBackoffSeconds int
+	MaxRetries          int `yaml:"max_retries" toml:"max_retries"`
+	RetryBackoffSeconds int `yaml:"retry_backoff_seconds" toml:"retry_backoff_seconds"`
+}
+
+// PriorityConfig shares job slots between the priority lanes and picks the
+// lane of jobs that do not name a priority
+type PriorityConfig struct {
+	Weights          []int `yaml:"weights" toml:"weights"`                       // Share of free slots for the high, normal and low lanes while all have jobs waiting
+	ShortMaxDuration int   `yaml:"short_max_duration" toml:"short_max_duration"` // Seconds; shorter sources go to the high lane, 0 disables
+	LongMinDuration  int   `yaml:"long_min_duration" toml:"long_min_duration"`   // Seconds; longer sources go to the low lane, 0 disables
+}
+
+// SourceConfig decides what happens to an uploaded original once its video is
+// encoded and reported
+type SourceConfig struct {
+	Action          string `yaml:"action" toml:"action"`                   // keep, delete, archive or mezzanine
+	ArchivePath     string `yaml:"archive_path" toml:"archive_path"`       // Where archive and mezzanine put originals, one directory per video
+	MezzanineCodec  string `yaml:"mezzanine_codec" toml:"mezzanine_codec"` // h264 or hevc
+	MezzaninePreset string `yaml:"mezzanine_preset" toml:"mezzanine_preset"`
+	MezzanineCRF    int    `yaml:"mezzanine_crf" toml:"mezzanine_crf"`
+}
+
+// Source actions
+const (
+	SourceKeep      = "keep"
+	SourceDelete    = "delete"
+	SourceArchive   = "archive"
+	SourceMezzanine = "mezzanine"
+)
+
+// RetentionConfig decides how many output versions of a video are kept
+type RetentionConfig struct {
+	KeepVersions int `yaml:"keep_versions" toml:"keep_versions"` // Newest versions kept per video, 0 keeps all
+	MinAge       int `yaml:"min_age" toml:"min_age"`             // Seconds a version is kept at least, whatever KeepVersions says
 }
 
-func LoadConfig() *Config {
+// LoadConfig builds the configuration from defaults, the optional YAML or TOML
+// file at path and environment variables, in increasing order of precedence.
+// Unparseable and invalid values are all reported together.
+func LoadConfig(path string) (*Config, error) {
+	config := defaultConfig()
+
+	if path != "" {
+		if err := config.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	env := &envLoader{}
+	config.applyEnv(env)
+
+	// Profiles of the config file go over the built-in ones, which follow the final FFmpeg settings
+	configured := config.Profiles.Definitions
+	config.Profiles.Definitions = builtinProfiles(config.FFmpeg)
+	config.mergeProfiles(configured)
+
+	if err := errors.Join(errors.Join(env.errs...), config.Validate()); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func defaultConfig() *Config {
 	return &Config{
 		NATS: NATSConfig{
-			URL:      getEnv("NATS_URL", "nats://localhost:4222"),
-			Stream:   getEnv("NATS_STREAM", "VIDEO_UPLOADS"),
-			Subject:  getEnv("NATS_SUBJECT", "video.upload.created"),
-			Consumer: getEnv("NATS_CONSUMER", "video-worker-group"),
-			Durable:  getEnv("NATS_DURABLE", "video-worker"),
+			URL:          "nats://localhost:4222",
+			Stream:       "VIDEO_UPLOADS",
+			Subject:      "video.upload.created",
+			Consumer:     "video-worker-group",
+			Durable:      "video-worker",
+			ChunkSubject: "video.chunk.encode",
+
+			ReconnectWait: 2,
+			MaxReconnects: -1,
+
+			StreamReplicas: 1,
+			StreamStorage:  "file",
+			StreamMaxAge:   86400,
+			StreamMaxBytes: -1,
+			AckWait:        600,
+			MaxAckPending:  1000,
+
+			EventsSubject:          "video.encode",
+			EventsStream:           "VIDEO_EVENTS",
+			EventsProgressInterval: 10,
+
+			DeadLetterSubject: "video.dead_letter",
+			DeadLetterStream:  "VIDEO_DEAD_LETTER",
+
+			ReencodeSubject: "video.reencode.requested",
 		},
 		GRPC: GRPCConfig{
-			VideoManagementURL: getEnv("VIDEO_MANAGEMENT_GRPC_URL", "localhost:50051"),
+			VideoManagementURL: "localhost:50051",
+			APIKeyHeader:       "x-api-key",
+			CallTimeout:        30,
+			KeepaliveTime:      300,
+			KeepaliveTimeout:   20,
 		},
 		Worker: WorkerConfig{
-			ID:                getEnv("WORKER_ID", "worker-1"),
-			MaxConcurrentJobs: getEnvAsInt("MAX_CONCURRENT_JOBS", 3),
+			ID:                "worker-1",
+			MaxConcurrentJobs: 3,
 		},
 		FFmpeg: FFmpegConfig{
-			HLSTime: getEnvAsInt("FFMPEG_HLS_TIME", 10),
-			Preset:  getEnv("FFMPEG_PRESET", "medium"),
-			CRF:     getEnvAsInt("FFMPEG_CRF", 23),
+			HLSTime:      10,
+			Preset:       "medium",
+			CRF:          23,
+			VideoCodec:   "h264",
+			AudioBitrate: "128k",
+			Deinterlacer: "bwdif",
+			Tonemap:      "hable",
+			LogTailLines: 20,
+
+			MaxFrameRate:           60,
+			HighFrameRateMinHeight: 720,
+
+			Split: SplitEncodeConfig{
+				MinDuration:   600,
+				ChunkDuration: 120,
+				Parallelism:   4,
+				ChunkTimeout:  1800,
+			},
+		},
+		Profiles: ProfilesConfig{
+			Default: "standard",
 		},
 		Paths: PathsConfig{
-			InputVideoPath:      getEnv("INPUT_VIDEO_PATH", "./uploads/videos"),
-			OutputHLSPath:       getEnv("OUTPUT_HLS_PATH", "./outputs/hls"),
-			OutputThumbnailPath: getEnv("OUTPUT_THUMBNAIL_PATH", "./outputs/thumbnails"),
+			InputVideoPath:      "./uploads/videos",
+			OutputHLSPath:       "./outputs/hls",
+			OutputThumbnailPath: "./outputs/thumbnails",
+			TempPath:            "./outputs/tmp",
+			TempMaxAge:          86400,
+			TempGCInterval:      3600,
 		},
 		Retry: RetryConfig{
-			MaxRetries:          getEnvAsInt("MAX_RETRIES", 3),
-			RetryBackoffSeconds: getEnvAsInt("RETRY_BACKOFF_SECONDS", 60),
+			MaxRetries:          3,
+			RetryBackoffSeconds: 60,
+		},
+		Priority: PriorityConfig{
+			Weights: []int{4, 2, 1},
+		},
+		Source: SourceConfig{
+			Action:          SourceKeep,
+			ArchivePath:     "./archive",
+			MezzanineCodec:  "h264",
+			MezzaninePreset: "slow",
+			MezzanineCRF:    16,
+		},
+		Retention: RetentionConfig{
+			KeepVersions: 3,
+			MinAge:       604800,
+		},
+		HTTP: HTTPConfig{
+			Addr:          ":9090",
+			MinFreeDiskMB: 1024,
+		},
+		Tracing: TracingConfig{
+			ServiceName: "tungwong-video-worker",
 		},
-		LogLevel: getEnv("LOG_LEVEL", "info"),
+		LogLevel:  "info",
+		LogFormat: "text",
 	}
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
-}
+// applyEnv overrides settings with the environment variables that are set
+func (c *Config) applyEnv(env *envLoader) {
+	env.str(&c.NATS.URL, "NATS_URL")
+	env.str(&c.NATS.Stream, "NATS_STREAM")
+	env.str(&c.NATS.Subject, "NATS_SUBJECT")
+	env.str(&c.NATS.Consumer, "NATS_CONSUMER")
+	env.str(&c.NATS.Durable, "NATS_DURABLE")
+	env.str(&c.NATS.ChunkSubject, "NATS_CHUNK_SUBJECT")
+	env.str(&c.NATS.CredsFile, "NATS_CREDS_FILE")
+	env.str(&c.NATS.NKeyFile, "NATS_NKEY_FILE")
+	env.str(&c.NATS.Token, "NATS_TOKEN")
+	env.str(&c.NATS.User, "NATS_USER")
+	env.str(&c.NATS.Password, "NATS_PASSWORD")
+	env.bool(&c.NATS.TLS.Enabled, "NATS_TLS_ENABLED")
+	env.str(&c.NATS.TLS.CAFile, "NATS_TLS_CA_FILE")
+	env.str(&c.NATS.TLS.CertFile, "NATS_TLS_CERT_FILE")
+	env.str(&c.NATS.TLS.KeyFile, "NATS_TLS_KEY_FILE")
+	env.str(&c.NATS.TLS.ServerName, "NATS_TLS_SERVER_NAME")
+	env.int(&c.NATS.ReconnectWait, "NATS_RECONNECT_WAIT")
+	env.int(&c.NATS.MaxReconnects, "NATS_MAX_RECONNECTS")
+	env.bool(&c.NATS.BindOnly, "NATS_BIND_ONLY")
+	env.int(&c.NATS.StreamReplicas, "NATS_STREAM_REPLICAS")
+	env.str(&c.NATS.StreamStorage, "NATS_STREAM_STORAGE")
+	env.int(&c.NATS.StreamMaxAge, "NATS_STREAM_MAX_AGE")
+	env.int(&c.NATS.StreamMaxBytes, "NATS_STREAM_MAX_BYTES")
+	env.int(&c.NATS.AckWait, "NATS_ACK_WAIT")
+	env.int(&c.NATS.MaxAckPending, "NATS_MAX_ACK_PENDING")
+	env.intSlice(&c.NATS.Backoff, "NATS_BACKOFF")
+	env.str(&c.NATS.EventsSubject, "NATS_EVENTS_SUBJECT")
+	env.str(&c.NATS.EventsStream, "NATS_EVENTS_STREAM")
+	env.int(&c.NATS.EventsProgressInterval, "NATS_EVENTS_PROGRESS_INTERVAL")
+	env.str(&c.NATS.DeadLetterSubject, "NATS_DEAD_LETTER_SUBJECT")
+	env.str(&c.NATS.DeadLetterStream, "NATS_DEAD_LETTER_STREAM")
+	env.str(&c.NATS.HighPrioritySubject, "NATS_HIGH_PRIORITY_SUBJECT")
+	env.str(&c.NATS.LowPrioritySubject, "NATS_LOW_PRIORITY_SUBJECT")
+	env.str(&c.NATS.ReencodeSubject, "NATS_REENCODE_SUBJECT")
 
-func getEnvAsInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intValue, err := strconv.Atoi(value); err == nil {
-			return intValue
-		}
-	}
-	return defaultValue
+	env.str(&c.GRPC.VideoManagementURL, "VIDEO_MANAGEMENT_GRPC_URL")
+	env.bool(&c.GRPC.TLS.Enabled, "GRPC_TLS_ENABLED")
+	env.str(&c.GRPC.TLS.CAFile, "GRPC_TLS_CA_FILE")
+	env.str(&c.GRPC.TLS.CertFile, "GRPC_TLS_CERT_FILE")
+	env.str(&c.GRPC.TLS.KeyFile, "GRPC_TLS_KEY_FILE")
+	env.str(&c.GRPC.TLS.ServerName, "GRPC_TLS_SERVER_NAME")
+	env.str(&c.GRPC.AuthToken, "GRPC_AUTH_TOKEN")
+	env.str(&c.GRPC.APIKey, "GRPC_API_KEY")
+	env.str(&c.GRPC.APIKeyHeader, "GRPC_API_KEY_HEADER")
+	env.int(&c.GRPC.CallTimeout, "GRPC_CALL_TIMEOUT")
+	env.int(&c.GRPC.KeepaliveTime, "GRPC_KEEPALIVE_TIME")
+	env.int(&c.GRPC.KeepaliveTimeout, "GRPC_KEEPALIVE_TIMEOUT")
+
+	env.str(&c.Worker.ID, "WORKER_ID")
+	env.int(&c.Worker.MaxConcurrentJobs, "MAX_CONCURRENT_JOBS")
+	env.int(&c.Worker.CPUThreads, "WORKER_CPU_THREADS")
+	env.int(&c.Worker.ScratchBudgetMB, "WORKER_SCRATCH_BUDGET_MB")
+
+	env.int(&c.FFmpeg.HLSTime, "FFMPEG_HLS_TIME")
+	env.str(&c.FFmpeg.Preset, "FFMPEG_PRESET")
+	env.int(&c.FFmpeg.CRF, "FFMPEG_CRF")
+	env.str(&c.FFmpeg.VideoCodec, "FFMPEG_VIDEO_CODEC")
+	env.str(&c.FFmpeg.AudioBitrate, "FFMPEG_AUDIO_BITRATE")
+	env.str(&c.FFmpeg.Deinterlacer, "FFMPEG_DEINTERLACER")
+	env.str(&c.FFmpeg.Tonemap, "FFMPEG_TONEMAP")
+	env.bool(&c.FFmpeg.HDRRendition, "FFMPEG_HDR_RENDITION")
+	env.int(&c.FFmpeg.LogTailLines, "FFMPEG_LOG_TAIL_LINES")
+	env.intSlice(&c.FFmpeg.Ladder, "FFMPEG_LADDER")
+	env.int(&c.FFmpeg.MaxFrameRate, "FFMPEG_MAX_FRAME_RATE")
+	env.int(&c.FFmpeg.HighFrameRateMinHeight, "FFMPEG_HIGH_FRAME_RATE_MIN_HEIGHT")
+
+	env.bool(&c.FFmpeg.Split.Enabled, "FFMPEG_SPLIT_ENABLED")
+	env.int(&c.FFmpeg.Split.MinDuration, "FFMPEG_SPLIT_MIN_DURATION")
+	env.int(&c.FFmpeg.Split.ChunkDuration, "FFMPEG_SPLIT_CHUNK_DURATION")
+	env.int(&c.FFmpeg.Split.Parallelism, "FFMPEG_SPLIT_PARALLELISM")
+	env.bool(&c.FFmpeg.Split.Remote, "FFMPEG_SPLIT_REMOTE")
+	env.int(&c.FFmpeg.Split.ChunkTimeout, "FFMPEG_SPLIT_CHUNK_TIMEOUT")
+
+	env.str(&c.Profiles.Default, "ENCODING_PROFILE_DEFAULT")
+	env.str(&c.Profiles.Preview, "ENCODING_PROFILE_PREVIEW")
+	env.str(&c.Profiles.File, "ENCODING_PROFILES_FILE")
+
+	env.str(&c.Paths.InputVideoPath, "INPUT_VIDEO_PATH")
+	env.str(&c.Paths.OutputHLSPath, "OUTPUT_HLS_PATH")
+	env.str(&c.Paths.OutputThumbnailPath, "OUTPUT_THUMBNAIL_PATH")
+	env.str(&c.Paths.TempPath, "TEMP_PATH")
+	env.int(&c.Paths.TempMaxAge, "TEMP_MAX_AGE")
+	env.int(&c.Paths.TempGCInterval, "TEMP_GC_INTERVAL")
+
+	env.int(&c.Retry.MaxRetries, "MAX_RETRIES")
+	env.int(&c.Retry.RetryBackoffSeconds, "RETRY_BACKOFF_SECONDS")
+
+	env.intSlice(&c.Priority.Weights, "PRIORITY_WEIGHTS")
+	env.int(&c.Priority.ShortMaxDuration, "PRIORITY_SHORT_MAX_DURATION")
+	env.int(&c.Priority.LongMinDuration, "PRIORITY_LONG_MIN_DURATION")
+
+	env.str(&c.Source.Action, "SOURCE_ACTION")
+	env.str(&c.Source.ArchivePath, "SOURCE_ARCHIVE_PATH")
+	env.str(&c.Source.MezzanineCodec, "SOURCE_MEZZANINE_CODEC")
+	env.str(&c.Source.MezzaninePreset, "SOURCE_MEZZANINE_PRESET")
+	env.int(&c.Source.MezzanineCRF, "SOURCE_MEZZANINE_CRF")
+
+	env.int(&c.Retention.KeepVersions, "RETENTION_KEEP_VERSIONS")
+	env.int(&c.Retention.MinAge, "RETENTION_MIN_AGE")
+
+	env.str(&c.HTTP.Addr, "HTTP_ADDR")
+	env.int(&c.HTTP.MinFreeDiskMB, "HEALTH_MIN_FREE_DISK_MB")
+	env.str(&c.HTTP.AdminToken, "ADMIN_TOKEN")
+
+	env.str(&c.Tracing.Endpoint, "OTEL_EXPORTER_OTLP_ENDPOINT")
+	env.bool(&c.Tracing.Insecure, "OTEL_EXPORTER_OTLP_INSECURE")
+	env.str(&c.Tracing.ServiceName, "OTEL_SERVICE_NAME")
+
+	env.str(&c.LogLevel, "LOG_LEVEL")
+	env.str(&c.LogFormat, "LOG_FORMAT")
 }
@@ -0,0 +1,226 @@
+package configs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string // Written to a file of that name's extension
+		contents string
+		env      map[string]string
+		want     func(c *Config) (got, want any)
+	}{
+		{
+			name: "default",
+			want: func(c *Config) (any, any) { return c.Worker.MaxConcurrentJobs, 3 },
+		},
+		{
+			name:     "yaml over default",
+			file:     "config.yaml",
+			contents: "worker:\n  max_concurrent_jobs: 5\n",
+			want:     func(c *Config) (any, any) { return c.Worker.MaxConcurrentJobs, 5 },
+		},
+		{
+			name:     "toml over default",
+			file:     "config.toml",
+			contents: "[worker]\nmax_concurrent_jobs = 5\n",
+			want:     func(c *Config) (any, any) { return c.Worker.MaxConcurrentJobs, 5 },
+		},
+		{
+			name:     "env over file",
+			file:     "config.yaml",
+			contents: "worker:\n  max_concurrent_jobs: 5\n",
+			env:      map[string]string{"MAX_CONCURRENT_JOBS": "7"},
+			want:     func(c *Config) (any, any) { return c.Worker.MaxConcurrentJobs, 7 },
+		},
+		{
+			name:     "file kept where env is unset",
+			file:     "config.yaml",
+			contents: "worker:\n  max_concurrent_jobs: 5\nffmpeg:\n  preset: slow\n",
+			env:      map[string]string{"MAX_CONCURRENT_JOBS": "7"},
+			want:     func(c *Config) (any, any) { return c.FFmpeg.Preset, "slow" },
+		},
+		{
+			name:     "default kept where file and env are unset",
+			file:     "config.yaml",
+			contents: "ffmpeg:\n  preset: slow\n",
+			want:     func(c *Config) (any, any) { return c.FFmpeg.CRF, 23 },
+		},
+		{
+			name:     "empty env keeps file",
+			file:     "config.yaml",
+			contents: "ffmpeg:\n  preset: slow\n",
+			env:      map[string]string{"FFMPEG_PRESET": ""},
+			want:     func(c *Config) (any, any) { return c.FFmpeg.Preset, "slow" },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			var path string
+			if tt.file != "" {
+				path = writeFile(t, tt.file, tt.contents)
+			}
+
+			config, err := LoadConfig(path)
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			if got, want := tt.want(config); got != want {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		contents string
+		env      map[string]string
+		wantErrs []string
+	}{
+		{
+			name:     "not an integer",
+			env:      map[string]string{"MAX_CONCURRENT_JOBS": "abc"},
+			wantErrs: []string{`MAX_CONCURRENT_JOBS: "abc" is not an integer`},
+		},
+		{
+			name:     "not a boolean",
+			env:      map[string]string{"FFMPEG_SPLIT_ENABLED": "sometimes"},
+			wantErrs: []string{`FFMPEG_SPLIT_ENABLED: "sometimes" is not a boolean`},
+		},
+		{
+			name:     "not a list",
+			env:      map[string]string{"FFMPEG_LADDER": "1080,hd"},
+			wantErrs: []string{`FFMPEG_LADDER: "1080,hd" is not a comma-separated list of integers`},
+		},
+		{
+			name:     "parse and validation errors together",
+			env:      map[string]string{"MAX_CONCURRENT_JOBS": "abc", "FFMPEG_PRESET": "warp"},
+			wantErrs: []string{"MAX_CONCURRENT_JOBS", "ffmpeg.preset"},
+		},
+		{
+			name:     "unknown yaml key",
+			file:     "config.yaml",
+			contents: "worker:\n  max_jobs: 5\n",
+			wantErrs: []string{"max_jobs"},
+		},
+		{
+			name:     "unknown toml key",
+			file:     "config.toml",
+			contents: "[worker]\nmax_jobs = 5\n",
+			wantErrs: []string{"worker.max_jobs"},
+		},
+		{
+			name:     "unknown extension",
+			file:     "config.json",
+			contents: "{}",
+			wantErrs: []string{"must end in .yaml, .yml or .toml"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			var path string
+			if tt.file != "" {
+				path = writeFile(t, tt.file, tt.contents)
+			}
+
+			_, err := LoadConfig(path)
+			checkErrs(t, err, tt.wantErrs)
+		})
+	}
+}
+
+func TestLoadProfilesFromConfigFile(t *testing.T) {
+	tests := []struct {
+		name         string
+		file         string
+		contents     string
+		profilesFile string // JSON profiles file, if any
+		wantCRF      map[string]int
+	}{
+		{
+			name:     "yaml",
+			file:     "config.yaml",
+			contents: "profiles:\n  default: mobile\n  definitions:\n    mobile:\n      ladder: [720, 360]\n      crf: 26\n    premium:\n      crf: 21\n",
+			wantCRF:  map[string]int{"mobile": 26, "premium": 21, "archive": 18},
+		},
+		{
+			name:     "toml",
+			file:     "config.toml",
+			contents: "[profiles]\ndefault = \"mobile\"\n\n[profiles.definitions.mobile]\nladder = [720, 360]\ncrf = 26\n\n[profiles.definitions.premium]\ncrf = 21\n",
+			wantCRF:  map[string]int{"mobile": 26, "premium": 21, "archive": 18},
+		},
+		{
+			name:         "profiles file over config file",
+			file:         "config.yaml",
+			contents:     "profiles:\n  default: mobile\n  definitions:\n    mobile:\n      ladder: [720, 360]\n      crf: 26\n",
+			profilesFile: `{"mobile": {"ladder": [480], "crf": 30}, "tiny": {"ladder": [240], "crf": 32}}`,
+			wantCRF:      map[string]int{"mobile": 30, "tiny": 32, "premium": 20},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.profilesFile != "" {
+				t.Setenv("ENCODING_PROFILES_FILE", writeFile(t, "profiles.json", tt.profilesFile))
+			}
+			config, err := LoadConfig(writeFile(t, tt.file, tt.contents))
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			if err := config.LoadProfiles(); err != nil {
+				t.Fatalf("LoadProfiles: %v", err)
+			}
+
+			for name, want := range tt.wantCRF {
+				profile, ok := config.Profile(name)
+				if !ok {
+					t.Fatalf("profile %s is not defined", name)
+				}
+				if profile.Name != name || profile.CRF != want {
+					t.Fatalf("profile %s = %+v, want name %s and crf %d", name, profile, name, want)
+				}
+			}
+			if profile, _ := config.Profile(""); profile.Name != "mobile" {
+				t.Fatalf("default profile = %s, want mobile", profile.Name)
+			}
+			if _, ok := config.Profile("standard"); !ok {
+				t.Fatal("built-in standard profile was dropped")
+			}
+		})
+	}
+}
+
+func writeFile(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// checkErrs fails t unless err contains every one of want
+func checkErrs(t *testing.T, err error, want []string) {
+	t.Helper()
+	if err == nil {
+		t.Fatalf("expected errors containing %q, got nil", want)
+	}
+	for _, w := range want {
+		if !strings.Contains(err.Error(), w) {
+			t.Errorf("expected error containing %q, got %v", w, err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package configs
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// envLoader overrides settings from environment variables. Unset or empty
+// variables keep the current value; unparseable ones are collected as errors
+// instead of silently falling back.
+type envLoader struct {
+	errs []error
+}
+
+func (l *envLoader) str(target *string, key string) {
+	if value := os.Getenv(key); value != "" {
+		*target = value
+	}
+}
+
+func (l *envLoader) int(target *int, key string) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+	intValue, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s: %q is not an integer", key, value))
+		return
+	}
+	*target = intValue
+}
+
+func (l *envLoader) bool(target *bool, key string) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+	boolValue, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s: %q is not a boolean", key, value))
+		return
+	}
+	*target = boolValue
+}
+
+func (l *envLoader) intSlice(target *[]int, key string) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+
+	var result []int
+	for _, part := range strings.Split(value, ",") {
+		intValue, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			l.errs = append(l.errs, fmt.Errorf("%s: %q is not a comma-separated list of integers", key, value))
+			return
+		}
+		result = append(result, intValue)
+	}
+	*target = result
+}
@@ -0,0 +1,108 @@
+package configs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "REDACTED"
+
+// loadFile decodes a YAML or TOML config file over the current settings.
+// The format follows the file extension; unknown keys are rejected so typos
+// do not go unnoticed.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	case ".toml":
+		meta, err := toml.Decode(string(data), c)
+		if err != nil {
+			return fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, len(undecoded))
+			for i, key := range undecoded {
+				keys[i] = key.String()
+			}
+			return fmt.Errorf("unknown keys in config file %s: %s", path, strings.Join(keys, ", "))
+		}
+	default:
+		return fmt.Errorf("config file %s must end in .yaml, .yml or .toml", path)
+	}
+	return nil
+}
+
+// Print writes the effective configuration as YAML, including the encoding
+// profiles, with secrets and URL credentials redacted
+func (c *Config) Print(w io.Writer) error {
+	clean := *c
+	clean.Profiles.Definitions = nil // Printed as encoding_profiles, with the built-in ones
+	redact(reflect.ValueOf(&clean).Elem())
+
+	out := struct {
+		Config           `yaml:",inline"`
+		EncodingProfiles map[string]EncodingProfile `yaml:"encoding_profiles"`
+	}{clean, c.Profiles.Definitions}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	defer encoder.Close()
+	return encoder.Encode(out)
+}
+
+// redact blanks string fields tagged secret:"true" and strips the password
+// from fields tagged secret:"url"
+func redact(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.Struct:
+			redact(field)
+		case reflect.String:
+			if field.String() == "" {
+				continue
+			}
+			switch v.Type().Field(i).Tag.Get("secret") {
+			case "true":
+				field.SetString(redacted)
+			case "url":
+				field.SetString(RedactURLs(field.String()))
+			}
+		}
+	}
+}
+
+// RedactURLs hides credentials in a comma-separated list of URLs
+func RedactURLs(value string) string {
+	parts := strings.Split(value, ",")
+	for i, part := range parts {
+		if u, err := url.Parse(strings.TrimSpace(part)); err == nil && u.User != nil {
+			if _, hasPassword := u.User.Password(); hasPassword {
+				u.User = url.UserPassword(u.User.Username(), redacted)
+			} else {
+				u.User = url.User(redacted) // A lone user part is usually a token
+			}
+			parts[i] = u.String()
+		}
+	}
+	return strings.Join(parts, ",")
+}
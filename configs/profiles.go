@@ -0,0 +1,152 @@
+package configs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+type ProfilesConfig struct {
+	Default     string                     `yaml:"default" toml:"default"`
+	Preview     string                     `yaml:"preview" toml:"preview"`                   // Profile for the fast preview pass, empty to publish only the full ladder
+	File        string                     `yaml:"file" toml:"file"`                         // Optional JSON file adding or replacing profiles
+	Definitions map[string]EncodingProfile `yaml:"definitions,omitempty" toml:"definitions"` // Added to or replacing the built-in profiles
+}
+
+// EncodingProfile bundles the ladder, codecs, segment settings and extras used
+// to encode a video. Zero-valued encoding fields fall back to FFmpegConfig.
+type EncodingProfile struct {
+	Name           string `json:"name" yaml:"name" toml:"name"`
+	Ladder         []int  `json:"ladder" yaml:"ladder" toml:"ladder"` // Omitted uses FFmpegConfig.Ladder; empty keeps the source size
+	VideoCodec     string `json:"video_codec" yaml:"video_codec" toml:"video_codec"`
+	Preset         string `json:"preset" yaml:"preset" toml:"preset"`
+	CRF            int    `json:"crf" yaml:"crf" toml:"crf"`
+	AudioBitrate   string `json:"audio_bitrate" yaml:"audio_bitrate" toml:"audio_bitrate"`
+	HLSTime        int    `json:"hls_time" yaml:"hls_time" toml:"hls_time"`
+	MaxFrameRate   int    `json:"max_frame_rate" yaml:"max_frame_rate" toml:"max_frame_rate"`
+	HDRRendition   bool   `json:"hdr_rendition" yaml:"hdr_rendition" toml:"hdr_rendition"`
+	Thumbnail      bool   `json:"thumbnail" yaml:"thumbnail" toml:"thumbnail"`
+	Sprites        bool   `json:"sprites" yaml:"sprites" toml:"sprites"`
+	SpriteInterval int    `json:"sprite_interval" yaml:"sprite_interval" toml:"sprite_interval"` // Seconds between sprite tiles
+}
+
+// builtinProfiles returns the profiles available without a profiles file. The
+// standard profile mirrors the FFMPEG_* settings so existing deployments keep
+// their output unchanged.
+func builtinProfiles(ffmpeg FFmpegConfig) map[string]EncodingProfile {
+	return map[string]EncodingProfile{
+		"standard": {
+			Name:         "standard",
+			Ladder:       ffmpeg.Ladder,
+			HDRRendition: ffmpeg.HDRRendition,
+			Thumbnail:    true,
+		},
+		"premium": {
+			Name:           "premium",
+			Ladder:         []int{2160, 1440, 1080, 720, 480, 360},
+			Preset:         "slow",
+			CRF:            20,
+			AudioBitrate:   "192k",
+			HDRRendition:   true,
+			Thumbnail:      true,
+			Sprites:        true,
+			SpriteInterval: 5,
+		},
+		"fast-preview": {
+			Name:      "fast-preview",
+			Ladder:    []int{360},
+			Preset:    "ultrafast",
+			CRF:       28,
+			HLSTime:   4,
+			Thumbnail: true,
+		},
+		"archive": {
+			Name:         "archive",
+			Ladder:       []int{},
+			Preset:       "slow",
+			CRF:          18,
+			AudioBitrate: "256k",
+			MaxFrameRate: 120,
+		},
+	}
+}
+
+// mergeProfiles adds or replaces profiles, named by their keys
+func (c *Config) mergeProfiles(profiles map[string]EncodingProfile) {
+	for name, profile := range profiles {
+		profile.Name = name
+		c.Profiles.Definitions[name] = profile
+	}
+}
+
+// LoadProfiles merges profiles from Profiles.File over the built-in ones and
+// those of the config file, and checks that every profile is valid and the
+// default profile exists
+func (c *Config) LoadProfiles() error {
+	if c.Profiles.File != "" {
+		data, err := os.ReadFile(c.Profiles.File)
+		if err != nil {
+			return fmt.Errorf("failed to read profiles file: %w", err)
+		}
+
+		var profiles map[string]EncodingProfile
+		if err := json.Unmarshal(data, &profiles); err != nil {
+			return fmt.Errorf("failed to parse profiles file: %w", err)
+		}
+
+		c.mergeProfiles(profiles)
+	}
+
+	var errs []error
+	for name, profile := range c.Profiles.Definitions {
+		errs = append(errs, validateProfile(name, profile, c.FFmpeg))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	if _, ok := c.Profiles.Definitions[c.Profiles.Default]; !ok {
+		return fmt.Errorf("default encoding profile %q is not defined", c.Profiles.Default)
+	}
+	if _, ok := c.Profiles.Definitions[c.Profiles.Preview]; c.Profiles.Preview != "" && !ok {
+		return fmt.Errorf("preview encoding profile %q is not defined", c.Profiles.Preview)
+	}
+	return nil
+}
+
+// Profile returns the named profile, or the default profile and false when the
+// name is empty or unknown
+func (c *Config) Profile(name string) (EncodingProfile, bool) {
+	if profile, ok := c.Profiles.Definitions[name]; ok {
+		return profile, true
+	}
+	return c.Profiles.Definitions[c.Profiles.Default], false
+}
+
+// WithProfile overlays a profile on the global FFmpeg settings
+func (f FFmpegConfig) WithProfile(profile EncodingProfile) FFmpegConfig {
+	if profile.Ladder != nil {
+		f.Ladder = profile.Ladder
+	}
+	f.HDRRendition = profile.HDRRendition
+	if profile.VideoCodec != "" {
+		f.VideoCodec = profile.VideoCodec
+	}
+	if profile.Preset != "" {
+		f.Preset = profile.Preset
+	}
+	if profile.CRF > 0 {
+		f.CRF = profile.CRF
+	}
+	if profile.AudioBitrate != "" {
+		f.AudioBitrate = profile.AudioBitrate
+	}
+	if profile.HLSTime > 0 {
+		f.HLSTime = profile.HLSTime
+	}
+	if profile.MaxFrameRate > 0 {
+		f.MaxFrameRate = profile.MaxFrameRate
+	}
+	return f
+}
@@ -0,0 +1,146 @@
+package configs
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// Store holds the live configuration. Reload swaps in a new snapshot, so
+// readers should call Current once per job and keep using that snapshot.
+type Store struct {
+	path     string
+	current  atomic.Pointer[Config]
+	mu       sync.Mutex // Serializes reloads and subscriber changes
+	onReload []func(old, next *Config)
+}
+
+// ReloadResult describes the outcome of a successful reload
+type ReloadResult struct {
+	Config  *Config
+	Changed []string // Settings applied to new jobs
+	Ignored []string // Settings that changed but need a restart, kept at their old values
+}
+
+// NewStore returns a store serving config, reloading from the file at path
+func NewStore(config *Config, path string) *Store {
+	s := &Store{path: path}
+	s.current.Store(config)
+	return s
+}
+
+// Current returns the configuration in effect
+func (s *Store) Current() *Config {
+	return s.current.Load()
+}
+
+// Path returns the config file, empty when only the environment is used
+func (s *Store) Path() string {
+	return s.path
+}
+
+// OnReload registers fn to run after every reload that changed settings
+func (s *Store) OnReload(fn func(old, next *Config)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onReload = append(s.onReload, fn)
+}
+
+// Reload reads the config file, environment and profiles file again and
+// applies the settings that are safe to change at runtime. An invalid
+// configuration is rejected as a whole and the current one stays in effect.
+func (s *Store) Reload() (*ReloadResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	loaded, err := LoadConfig(s.path)
+	if err != nil {
+		return nil, err
+	}
+	if err := loaded.LoadProfiles(); err != nil {
+		return nil, err
+	}
+
+	old := s.Current()
+	next, changed, ignored := old.mergeReloadable(loaded)
+	result := &ReloadResult{Config: old, Changed: changed, Ignored: ignored}
+	if len(changed) == 0 {
+		return result, nil
+	}
+
+	s.current.Store(next)
+	result.Config = next
+	for _, fn := range s.onReload {
+		fn(old, next)
+	}
+	return result, nil
+}
+
+// mergeReloadable returns a copy of c with the runtime-safe settings taken
+// from next, along with the names of the settings that changed and of those
+// that changed but need a restart
+func (c *Config) mergeReloadable(next *Config) (merged *Config, changed, ignored []string) {
+	merged = new(Config)
+	*merged = *c
+
+	reloadable := []struct {
+		name string
+		old  any
+		new  any
+		set  func()
+	}{
+		{"worker.max_concurrent_jobs", c.Worker.MaxConcurrentJobs, next.Worker.MaxConcurrentJobs, func() {
+			merged.Worker.MaxConcurrentJobs = next.Worker.MaxConcurrentJobs
+		}},
+		{"worker.cpu_threads", c.Worker.CPUThreads, next.Worker.CPUThreads, func() {
+			merged.Worker.CPUThreads = next.Worker.CPUThreads
+		}},
+		{"worker.scratch_budget_mb", c.Worker.ScratchBudgetMB, next.Worker.ScratchBudgetMB, func() {
+			merged.Worker.ScratchBudgetMB = next.Worker.ScratchBudgetMB
+		}},
+		{"ffmpeg", withoutSplit(c.FFmpeg), withoutSplit(next.FFmpeg), func() {
+			split := merged.FFmpeg.Split
+			merged.FFmpeg = next.FFmpeg
+			merged.FFmpeg.Split = split
+		}},
+		{"profiles", c.Profiles, next.Profiles, func() { merged.Profiles = next.Profiles }},
+		{"retry", c.Retry, next.Retry, func() { merged.Retry = next.Retry }},
+		{"priority", c.Priority, next.Priority, func() { merged.Priority = next.Priority }},
+		{"source", c.Source, next.Source, func() { merged.Source = next.Source }},
+		{"retention", c.Retention, next.Retention, func() { merged.Retention = next.Retention }},
+		{"log_level", c.LogLevel, next.LogLevel, func() { merged.LogLevel = next.LogLevel }},
+	}
+	for _, setting := range reloadable {
+		if !reflect.DeepEqual(setting.old, setting.new) {
+			setting.set()
+			changed = append(changed, setting.name)
+		}
+	}
+
+	restartOnly := []struct {
+		name     string
+		old, new any
+	}{
+		{"nats", c.NATS, next.NATS},
+		{"grpc", c.GRPC, next.GRPC},
+		{"worker.id", c.Worker.ID, next.Worker.ID},
+		{"ffmpeg.split", c.FFmpeg.Split, next.FFmpeg.Split},
+		{"paths", c.Paths, next.Paths},
+		{"http", c.HTTP, next.HTTP},
+		{"tracing", c.Tracing, next.Tracing},
+		{"log_format", c.LogFormat, next.LogFormat},
+	}
+	for _, setting := range restartOnly {
+		if !reflect.DeepEqual(setting.old, setting.new) {
+			ignored = append(ignored, setting.name)
+		}
+	}
+	return merged, changed, ignored
+}
+
+// withoutSplit blanks the split settings, which cannot change at runtime
+// because remote chunk serving is set up at startup
+func withoutSplit(f FFmpegConfig) FFmpegConfig {
+	f.Split = SplitEncodeConfig{}
+	return f
+}
@@ -0,0 +1,77 @@
+package configs
+
+import (
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestStoreReload(t *testing.T) {
+	path := writeFile(t, "config.yaml", "worker:\n  max_concurrent_jobs: 2\nffmpeg:\n  preset: fast\n")
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if err := config.LoadProfiles(); err != nil {
+		t.Fatalf("LoadProfiles: %v", err)
+	}
+	store := NewStore(config, path)
+
+	var reloads [][2]*Config
+	store.OnReload(func(old, next *Config) { reloads = append(reloads, [2]*Config{old, next}) })
+
+	reload := func(contents string) (*ReloadResult, error) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return store.Reload()
+	}
+
+	// Unchanged file: nothing applied, nobody notified
+	result, err := reload("worker:\n  max_concurrent_jobs: 2\nffmpeg:\n  preset: fast\n")
+	if err != nil || len(result.Changed) != 0 || len(result.Ignored) != 0 || len(reloads) != 0 {
+		t.Fatalf("unchanged reload = %+v, %v, %d notifications", result, err, len(reloads))
+	}
+
+	// Runtime-safe settings apply, restart-only ones keep their old values
+	result, err = reload("worker:\n  max_concurrent_jobs: 5\nffmpeg:\n  preset: slow\n  split:\n    enabled: true\n" +
+		"nats:\n  url: nats://other:4222\nretry:\n  max_retries: 7\n")
+	if err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	for _, want := range []string{"worker.max_concurrent_jobs", "ffmpeg", "retry"} {
+		if !slices.Contains(result.Changed, want) {
+			t.Errorf("Changed = %v, missing %s", result.Changed, want)
+		}
+	}
+	for _, want := range []string{"nats", "ffmpeg.split"} {
+		if !slices.Contains(result.Ignored, want) {
+			t.Errorf("Ignored = %v, missing %s", result.Ignored, want)
+		}
+	}
+	current := store.Current()
+	if current.Worker.MaxConcurrentJobs != 5 || current.FFmpeg.Preset != "slow" || current.Retry.MaxRetries != 7 {
+		t.Fatalf("reloadable settings not applied: %+v", current.Worker)
+	}
+	if current.NATS.URL != config.NATS.URL || current.FFmpeg.Split.Enabled {
+		t.Fatalf("restart-only settings changed: nats.url %s, split %v", current.NATS.URL, current.FFmpeg.Split.Enabled)
+	}
+	if config.Worker.MaxConcurrentJobs != 2 {
+		t.Fatal("reload modified the snapshot jobs already hold")
+	}
+	if len(reloads) != 1 || reloads[0][0] != config || reloads[0][1] != current {
+		t.Fatalf("%d notifications, want one with the old and new snapshots", len(reloads))
+	}
+	if profile, _ := current.Profile("standard"); profile.Name != "standard" {
+		t.Fatal("profiles lost on reload")
+	}
+
+	// An invalid file is rejected as a whole
+	if _, err := reload("worker:\n  max_concurrent_jobs: 9\nffmpeg:\n  preset: warp\n"); err == nil {
+		t.Fatal("invalid config accepted")
+	}
+	if store.Current() != current || len(reloads) != 1 {
+		t.Fatal("rejected reload replaced the configuration")
+	}
+}
@@ -0,0 +1,61 @@
+package configs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// TLSConfig secures a client connection. A client certificate and key enable
+// mutual TLS.
+type TLSConfig struct {
+	Enabled    bool   `yaml:"enabled" toml:"enabled"`
+	CAFile     string `yaml:"ca_file" toml:"ca_file"` // PEM bundle to verify the server; empty uses the system roots
+	CertFile   string `yaml:"cert_file" toml:"cert_file"`
+	KeyFile    string `yaml:"key_file" toml:"key_file"`
+	ServerName string `yaml:"server_name" toml:"server_name"` // Overrides the name checked against the server certificate
+}
+
+// ClientConfig loads the certificates and returns the client TLS settings
+func (t TLSConfig) ClientConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: t.ServerName,
+	}
+
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("failed to parse CA file %s: no PEM certificates found", t.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+func (t TLSConfig) validate(prefix string) []error {
+	if !t.Enabled {
+		if t.CAFile != "" || t.CertFile != "" || t.KeyFile != "" || t.ServerName != "" {
+			return []error{fmt.Errorf("%s: certificate settings are set but %s.enabled is false", prefix, prefix)}
+		}
+		return nil
+	}
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return []error{fmt.Errorf("%s: cert_file and key_file must be set together", prefix)}
+	}
+	return nil
+}
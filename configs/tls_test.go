@@ -0,0 +1,119 @@
+package configs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed certificate and its key as PEM files
+func writeCertificate(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "video-worker"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestTLSClientConfig(t *testing.T) {
+	certFile, keyFile := writeCertificate(t)
+	notPEM := writeFile(t, "ca.pem", "not a certificate")
+
+	tests := []struct {
+		name      string
+		config    TLSConfig
+		wantCA    bool
+		wantCerts int
+		wantErr   string
+	}{
+		{name: "system roots", config: TLSConfig{Enabled: true, ServerName: "api.internal"}},
+		{name: "custom CA", config: TLSConfig{Enabled: true, CAFile: certFile}, wantCA: true},
+		{name: "mutual TLS", config: TLSConfig{Enabled: true, CAFile: certFile, CertFile: certFile, KeyFile: keyFile}, wantCA: true, wantCerts: 1},
+		{name: "missing CA", config: TLSConfig{Enabled: true, CAFile: filepath.Join(t.TempDir(), "missing.pem")}, wantErr: "failed to read CA file"},
+		{name: "CA without certificates", config: TLSConfig{Enabled: true, CAFile: notPEM}, wantErr: "no PEM certificates found"},
+		{name: "key mismatch", config: TLSConfig{Enabled: true, CertFile: certFile, KeyFile: certFile}, wantErr: "failed to load client certificate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := tt.config.ClientConfig()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ClientConfig: %v", err)
+			}
+			if config.MinVersion != tls.VersionTLS12 || config.ServerName != tt.config.ServerName {
+				t.Fatalf("MinVersion %x, ServerName %q", config.MinVersion, config.ServerName)
+			}
+			if (config.RootCAs != nil) != tt.wantCA || len(config.Certificates) != tt.wantCerts {
+				t.Fatalf("RootCAs set %v, %d certificates; want %v, %d", config.RootCAs != nil, len(config.Certificates), tt.wantCA, tt.wantCerts)
+			}
+		})
+	}
+}
+
+func TestTLSValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  TLSConfig
+		wantErr string
+	}{
+		{name: "disabled"},
+		{name: "enabled", config: TLSConfig{Enabled: true}},
+		{name: "settings while disabled", config: TLSConfig{CAFile: "ca.pem"}, wantErr: "grpc.tls: certificate settings are set but grpc.tls.enabled is false"},
+		{name: "cert without key", config: TLSConfig{Enabled: true, CertFile: "cert.pem"}, wantErr: "grpc.tls: cert_file and key_file must be set together"},
+		{name: "key without cert", config: TLSConfig{Enabled: true, KeyFile: "key.pem"}, wantErr: "cert_file and key_file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := tt.config.validate("grpc.tls")
+			if tt.wantErr == "" {
+				if len(errs) != 0 {
+					t.Fatalf("unexpected errors: %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || !strings.Contains(errs[0].Error(), tt.wantErr) {
+				t.Fatalf("errors = %v, want one containing %q", errs, tt.wantErr)
+			}
+		})
+	}
+}
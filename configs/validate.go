@@ -0,0 +1,238 @@
+package configs
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	validPresets       = []string{"ultrafast", "superfast", "veryfast", "faster", "fast", "medium", "slow", "slower", "veryslow", "placebo"}
+	validVideoCodecs   = []string{"h264", "hevc"}
+	validDeinterlaces  = []string{"yadif", "bwdif"}
+	validTonemaps      = []string{"none", "clip", "linear", "gamma", "reinhard", "hable", "mobius"}
+	validLogFormats    = []string{"text", "json"}
+	validNATSSchemes   = []string{"nats", "tls", "ws", "wss"}
+	validSourceActions = []string{SourceKeep, SourceDelete, SourceArchive, SourceMezzanine}
+)
+
+// Validate checks the settings and reports every problem at once
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	for _, raw := range strings.Split(c.NATS.URL, ",") {
+		u, err := url.Parse(strings.TrimSpace(raw))
+		check(err == nil && slices.Contains(validNATSSchemes, u.Scheme) && u.Host != "",
+			"nats.url: %q is not a nats://, tls://, ws:// or wss:// URL", RedactURLs(raw))
+	}
+	check(c.NATS.Stream != "", "nats.stream: must not be empty")
+	check(c.NATS.Subject != "", "nats.subject: must not be empty")
+	check(c.NATS.Durable != "", "nats.durable: must not be empty")
+	authMethods := 0
+	for _, set := range []bool{c.NATS.CredsFile != "", c.NATS.NKeyFile != "", c.NATS.Token != "", c.NATS.User != ""} {
+		if set {
+			authMethods++
+		}
+	}
+	check(authMethods <= 1, "nats: only one of creds_file, nkey_file, token and user may be set")
+	check(c.NATS.Password == "" || c.NATS.User != "", "nats.password: requires nats.user")
+	errs = append(errs, c.NATS.TLS.validate("nats.tls")...)
+	check(c.NATS.ReconnectWait > 0, "nats.reconnect_wait: must be positive, got %d", c.NATS.ReconnectWait)
+	check(c.NATS.MaxReconnects >= -1, "nats.max_reconnects: must be -1 (forever) or more, got %d", c.NATS.MaxReconnects)
+	check(c.NATS.StreamReplicas >= 1 && c.NATS.StreamReplicas <= 5, "nats.stream_replicas: must be between 1 and 5, got %d", c.NATS.StreamReplicas)
+	check(c.NATS.StreamStorage == "file" || c.NATS.StreamStorage == "memory", "nats.stream_storage: must be file or memory, got %q", c.NATS.StreamStorage)
+	check(c.NATS.StreamMaxAge >= 0, "nats.stream_max_age: must not be negative, got %d", c.NATS.StreamMaxAge)
+	check(c.NATS.StreamMaxBytes == -1 || c.NATS.StreamMaxBytes > 0, "nats.stream_max_bytes: must be -1 (unlimited) or positive, got %d", c.NATS.StreamMaxBytes)
+	check(c.NATS.AckWait > 0, "nats.ack_wait: must be positive, got %d", c.NATS.AckWait)
+	check(c.NATS.MaxAckPending >= 1, "nats.max_ack_pending: must be at least 1, got %d", c.NATS.MaxAckPending)
+	for _, delay := range c.NATS.Backoff {
+		if delay <= 0 {
+			check(false, "nats.backoff: delays must be positive, got %d", delay)
+			break
+		}
+	}
+	if c.NATS.EventsSubject != "" {
+		check(c.NATS.EventsStream != "", "nats.events_stream: must not be empty when nats.events_subject is set")
+		check(c.NATS.EventsProgressInterval >= 1, "nats.events_progress_interval: must be at least 1, got %d", c.NATS.EventsProgressInterval)
+		check(!strings.HasPrefix(c.NATS.Subject, c.NATS.EventsSubject+"."),
+			"nats.events_subject: %q must not cover the job subject %q", c.NATS.EventsSubject, c.NATS.Subject)
+	}
+	if c.NATS.DeadLetterSubject != "" {
+		check(c.NATS.DeadLetterStream != "", "nats.dead_letter_stream: must not be empty when nats.dead_letter_subject is set")
+		check(!strings.ContainsAny(c.NATS.DeadLetterSubject, "*>"),
+			"nats.dead_letter_subject: %q must not contain wildcards", c.NATS.DeadLetterSubject)
+		check(c.NATS.DeadLetterSubject != c.NATS.Subject,
+			"nats.dead_letter_subject: must differ from the job subject %q", c.NATS.Subject)
+		check(c.NATS.EventsSubject == "" || !strings.HasPrefix(c.NATS.DeadLetterSubject, c.NATS.EventsSubject+"."),
+			"nats.dead_letter_subject: %q must not be covered by the events subject %q", c.NATS.DeadLetterSubject, c.NATS.EventsSubject)
+	}
+	for _, lane := range []struct{ name, subject string }{
+		{"nats.high_priority_subject", c.NATS.HighPrioritySubject},
+		{"nats.low_priority_subject", c.NATS.LowPrioritySubject},
+		{"nats.reencode_subject", c.NATS.ReencodeSubject},
+	} {
+		if lane.subject == "" {
+			continue
+		}
+		check(lane.subject != c.NATS.Subject, "%s: must differ from the job subject %q", lane.name, c.NATS.Subject)
+		check(!strings.ContainsAny(lane.subject, "*>"), "%s: %q must not contain wildcards", lane.name, lane.subject)
+		check(lane.subject != c.NATS.DeadLetterSubject, "%s: must differ from the dead-letter subject", lane.name)
+		check(c.NATS.EventsSubject == "" || !strings.HasPrefix(lane.subject, c.NATS.EventsSubject+"."),
+			"%s: %q must not be covered by the events subject %q", lane.name, lane.subject, c.NATS.EventsSubject)
+	}
+	check(c.NATS.HighPrioritySubject == "" || c.NATS.HighPrioritySubject != c.NATS.LowPrioritySubject,
+		"nats.low_priority_subject: must differ from nats.high_priority_subject")
+	check(c.NATS.ReencodeSubject == "" || (c.NATS.ReencodeSubject != c.NATS.HighPrioritySubject && c.NATS.ReencodeSubject != c.NATS.LowPrioritySubject),
+		"nats.reencode_subject: must differ from the priority lane subjects")
+	check(len(c.NATS.Backoff) == 0 || len(c.NATS.Backoff) < c.Retry.MaxRetries+1,
+		"nats.backoff: JetStream needs fewer delays (%d) than deliveries (retry.max_retries+1 = %d)", len(c.NATS.Backoff), c.Retry.MaxRetries+1)
+	check(validGRPCTarget(c.GRPC.VideoManagementURL),
+		"grpc.video_management_url: %q is not host:port or a resolver URL such as dns:///host:port", c.GRPC.VideoManagementURL)
+	errs = append(errs, c.GRPC.TLS.validate("grpc.tls")...)
+	check(c.GRPC.APIKey == "" || c.GRPC.APIKeyHeader != "", "grpc.api_key_header: must not be empty when grpc.api_key is set")
+	check(c.GRPC.CallTimeout > 0, "grpc.call_timeout: must be positive, got %d", c.GRPC.CallTimeout)
+	check(c.GRPC.KeepaliveTime >= 0, "grpc.keepalive_time: must not be negative, got %d", c.GRPC.KeepaliveTime)
+	check(c.GRPC.KeepaliveTimeout > 0, "grpc.keepalive_timeout: must be positive, got %d", c.GRPC.KeepaliveTimeout)
+
+	check(c.Worker.ID != "", "worker.id: must not be empty")
+	check(c.Worker.MaxConcurrentJobs >= 1, "worker.max_concurrent_jobs: must be at least 1, got %d", c.Worker.MaxConcurrentJobs)
+	check(c.Worker.CPUThreads >= 0, "worker.cpu_threads: must not be negative, got %d", c.Worker.CPUThreads)
+	check(c.Worker.ScratchBudgetMB >= 0, "worker.scratch_budget_mb: must not be negative, got %d", c.Worker.ScratchBudgetMB)
+
+	errs = append(errs, validateEncoding("ffmpeg", c.FFmpeg.VideoCodec, c.FFmpeg.Preset, c.FFmpeg.Tonemap, c.FFmpeg.CRF, c.FFmpeg.HLSTime, c.FFmpeg.Ladder)...)
+	check(slices.Contains(validDeinterlaces, c.FFmpeg.Deinterlacer),
+		"ffmpeg.deinterlacer: must be one of %s, got %q", strings.Join(validDeinterlaces, ", "), c.FFmpeg.Deinterlacer)
+	check(c.FFmpeg.MaxFrameRate >= 0, "ffmpeg.max_frame_rate: must not be negative")
+	check(c.FFmpeg.LogTailLines >= 1, "ffmpeg.log_tail_lines: must be at least 1, got %d", c.FFmpeg.LogTailLines)
+	if split := c.FFmpeg.Split; split.Enabled {
+		check(split.MinDuration > 0, "ffmpeg.split.min_duration: must be positive, got %d", split.MinDuration)
+		check(split.ChunkDuration > 0, "ffmpeg.split.chunk_duration: must be positive, got %d", split.ChunkDuration)
+		check(split.Parallelism > 0, "ffmpeg.split.parallelism: must be positive, got %d", split.Parallelism)
+		check(split.ChunkTimeout > 0, "ffmpeg.split.chunk_timeout: must be positive, got %d", split.ChunkTimeout)
+	}
+
+	check(c.Profiles.Default != "", "profiles.default: must not be empty")
+	check(c.Paths.TempPath != "", "paths.temp_path: must not be empty")
+	check(c.Paths.TempGCInterval >= 0, "paths.temp_gc_interval: must not be negative, got %d", c.Paths.TempGCInterval)
+	if c.Paths.TempGCInterval > 0 {
+		check(c.Paths.TempMaxAge > 0, "paths.temp_max_age: must be positive, got %d", c.Paths.TempMaxAge)
+	}
+
+	check(c.Retry.MaxRetries >= 0, "retry.max_retries: must not be negative, got %d", c.Retry.MaxRetries)
+	check(c.Retry.RetryBackoffSeconds >= 0, "retry.retry_backoff_seconds: must not be negative, got %d", c.Retry.RetryBackoffSeconds)
+
+	check(len(c.Priority.Weights) == 3, "priority.weights: need one weight each for the high, normal and low lanes, got %d", len(c.Priority.Weights))
+	for _, weight := range c.Priority.Weights {
+		if weight < 1 {
+			check(false, "priority.weights: weights must be at least 1, got %d", weight)
+			break
+		}
+	}
+	check(c.Priority.ShortMaxDuration >= 0, "priority.short_max_duration: must not be negative, got %d", c.Priority.ShortMaxDuration)
+	check(c.Priority.LongMinDuration >= 0, "priority.long_min_duration: must not be negative, got %d", c.Priority.LongMinDuration)
+	check(c.Priority.ShortMaxDuration == 0 || c.Priority.LongMinDuration == 0 || c.Priority.ShortMaxDuration < c.Priority.LongMinDuration,
+		"priority.short_max_duration: must be below priority.long_min_duration (%d), got %d", c.Priority.LongMinDuration, c.Priority.ShortMaxDuration)
+
+	check(slices.Contains(validSourceActions, c.Source.Action),
+		"source.action: must be one of %s, got %q", strings.Join(validSourceActions, ", "), c.Source.Action)
+	if c.Source.Action == SourceArchive || c.Source.Action == SourceMezzanine {
+		check(c.Source.ArchivePath != "", "source.archive_path: must not be empty when source.action is %s", c.Source.Action)
+	}
+	if c.Source.Action == SourceMezzanine {
+		check(slices.Contains(validVideoCodecs, c.Source.MezzanineCodec),
+			"source.mezzanine_codec: must be one of %s, got %q", strings.Join(validVideoCodecs, ", "), c.Source.MezzanineCodec)
+		check(slices.Contains(validPresets, c.Source.MezzaninePreset),
+			"source.mezzanine_preset: must be one of %s, got %q", strings.Join(validPresets, ", "), c.Source.MezzaninePreset)
+		check(c.Source.MezzanineCRF >= 0 && c.Source.MezzanineCRF <= 51, "source.mezzanine_crf: must be between 0 and 51, got %d", c.Source.MezzanineCRF)
+	}
+
+	check(c.Retention.KeepVersions >= 0, "retention.keep_versions: must not be negative, got %d", c.Retention.KeepVersions)
+	check(c.Retention.MinAge >= 0, "retention.min_age: must not be negative, got %d", c.Retention.MinAge)
+
+	_, _, err := net.SplitHostPort(c.HTTP.Addr)
+	check(err == nil, "http.addr: %q is not host:port", c.HTTP.Addr)
+	check(c.HTTP.MinFreeDiskMB >= 0, "http.min_free_disk_mb: must not be negative")
+
+	if endpoint := c.Tracing.Endpoint; endpoint != "" {
+		ok := validHostPort(endpoint)
+		if strings.Contains(endpoint, "://") {
+			u, err := url.Parse(endpoint)
+			ok = err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+		}
+		check(ok, "tracing.endpoint: %q is not host:port or an http(s) URL", RedactURLs(endpoint))
+	}
+
+	_, err = logrus.ParseLevel(c.LogLevel)
+	check(err == nil, "log_level: %q is not a valid level", c.LogLevel)
+	check(slices.Contains(validLogFormats, c.LogFormat),
+		"log_format: must be one of %s, got %q", strings.Join(validLogFormats, ", "), c.LogFormat)
+
+	return errors.Join(errs...)
+}
+
+// validateProfile checks the settings a profile produces on top of ffmpeg
+func validateProfile(name string, profile EncodingProfile, ffmpeg FFmpegConfig) error {
+	f := ffmpeg.WithProfile(profile)
+	return errors.Join(validateEncoding("profile "+name, f.VideoCodec, f.Preset, f.Tonemap, f.CRF, f.HLSTime, f.Ladder)...)
+}
+
+// ValidateEncoding checks the settings an encode takes from f, such as those a
+// split-encode chunk brings from another worker
+func (f FFmpegConfig) ValidateEncoding() error {
+	errs := validateEncoding("ffmpeg", f.VideoCodec, f.Preset, f.Tonemap, f.CRF, f.HLSTime, f.Ladder)
+	if !slices.Contains(validDeinterlaces, f.Deinterlacer) {
+		errs = append(errs, fmt.Errorf("ffmpeg.deinterlacer: must be one of %s, got %q", strings.Join(validDeinterlaces, ", "), f.Deinterlacer))
+	}
+	return errors.Join(errs...)
+}
+
+// validateEncoding checks the settings every encode depends on. An empty
+// tonemap uses the default algorithm.
+func validateEncoding(prefix, codec, preset, tonemap string, crf, hlsTime int, ladder []int) []error {
+	var errs []error
+	if !slices.Contains(validVideoCodecs, codec) {
+		errs = append(errs, fmt.Errorf("%s.video_codec: must be one of %s, got %q", prefix, strings.Join(validVideoCodecs, ", "), codec))
+	}
+	if !slices.Contains(validPresets, preset) {
+		errs = append(errs, fmt.Errorf("%s.preset: must be one of %s, got %q", prefix, strings.Join(validPresets, ", "), preset))
+	}
+	if tonemap != "" && !slices.Contains(validTonemaps, tonemap) {
+		errs = append(errs, fmt.Errorf("%s.tonemap: must be one of %s, got %q", prefix, strings.Join(validTonemaps, ", "), tonemap))
+	}
+	if crf < 0 || crf > 51 {
+		errs = append(errs, fmt.Errorf("%s.crf: must be between 0 and 51, got %d", prefix, crf))
+	}
+	if hlsTime <= 0 {
+		errs = append(errs, fmt.Errorf("%s.hls_time: must be positive, got %d", prefix, hlsTime))
+	}
+	for _, rung := range ladder {
+		if rung <= 0 {
+			errs = append(errs, fmt.Errorf("%s.ladder: rung sizes must be positive, got %d", prefix, rung))
+			break
+		}
+	}
+	return errs
+}
+
+// validGRPCTarget accepts host:port or a resolver URL such as dns:///host:port
+func validGRPCTarget(target string) bool {
+	if i := strings.Index(target, ":///"); i > 0 {
+		return len(target) > i+4
+	}
+	return validHostPort(target)
+}
+
+func validHostPort(value string) bool {
+	host, port, err := net.SplitHostPort(value)
+	return err == nil && host != "" && port != ""
+}
@@ -0,0 +1,132 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/jobs"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/nats"
+	"github.com/sirupsen/logrus"
+)
+
+// Consumer is the part of the NATS consumer operators can control
+type Consumer interface {
+	Pause()
+	Resume()
+	Status() nats.ConsumerStatus
+}
+
+// API serves the operator endpoints for inspecting and controlling jobs
+type API struct {
+	jobs     *jobs.Registry
+	consumer Consumer
+	token    string
+	logger   *logrus.Logger
+}
+
+type cancelResponse struct {
+	ID      string `json:"id"`
+	VideoID string `json:"video_id"`
+	Requeue bool   `json:"requeue"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// NewAPI creates the admin API. Requests must carry token as a bearer token.
+func NewAPI(registry *jobs.Registry, consumer Consumer, token string, logger *logrus.Logger) *API {
+	return &API{
+		jobs:     registry,
+		consumer: consumer,
+		token:    token,
+		logger:   logger,
+	}
+}
+
+// Handler routes the /admin/ endpoints
+func (a *API) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/jobs", a.listJobs)
+	mux.HandleFunc("GET /admin/jobs/{id}", a.getJob)
+	mux.HandleFunc("POST /admin/jobs/{id}/cancel", a.cancelJob)
+	mux.HandleFunc("GET /admin/consumer", a.consumerStatus)
+	mux.HandleFunc("POST /admin/consumer/pause", a.pause)
+	mux.HandleFunc("POST /admin/consumer/resume", a.resume)
+	return a.authenticate(mux)
+}
+
+func (a *API) authenticate(next http.Handler) http.Handler {
+	expected := []byte("Bearer " + a.token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *API) listJobs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.jobs.List())
+}
+
+func (a *API) getJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := a.jobs.Get(r.PathValue("id"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "no running job with this id"})
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// cancelJob aborts a running job. With ?requeue=true the message is naked and
+// redelivered later; otherwise it is terminated and the video marked failed.
+func (a *API) cancelJob(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	requeue := false
+	if value := r.URL.Query().Get("requeue"); value != "" {
+		var err error
+		if requeue, err = strconv.ParseBool(value); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "requeue must be a boolean"})
+			return
+		}
+	}
+
+	job, ok := a.jobs.Cancel(id, requeue)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "no running job with this id"})
+		return
+	}
+
+	a.logger.WithFields(logrus.Fields{
+		"job_id":   id,
+		"video_id": job.VideoID,
+		"requeue":  requeue,
+	}).Warn("Job cancelled through admin API")
+
+	writeJSON(w, http.StatusAccepted, cancelResponse{ID: id, VideoID: job.VideoID, Requeue: requeue})
+}
+
+func (a *API) consumerStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.consumer.Status())
+}
+
+func (a *API) pause(w http.ResponseWriter, r *http.Request) {
+	a.consumer.Pause()
+	writeJSON(w, http.StatusOK, a.consumer.Status())
+}
+
+func (a *API) resume(w http.ResponseWriter, r *http.Request) {
+	a.consumer.Resume()
+	writeJSON(w, http.StatusOK, a.consumer.Status())
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,84 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/jobs"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/nats"
+	"github.com/sirupsen/logrus"
+)
+
+// fakeConsumer records pauses and resumes
+type fakeConsumer struct {
+	paused bool
+}
+
+func (c *fakeConsumer) Pause()  { c.paused = true }
+func (c *fakeConsumer) Resume() { c.paused = false }
+func (c *fakeConsumer) Status() nats.ConsumerStatus {
+	return nats.ConsumerStatus{Paused: c.paused, Running: 1, MaxJobs: 3}
+}
+
+func TestAPI(t *testing.T) {
+	registry := jobs.NewRegistry()
+	_, job := registry.Start(context.Background(), "42", "video-1", "Movie", 1)
+	consumer := &fakeConsumer{}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	handler := NewAPI(registry, consumer, "secret", logger).Handler()
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		token    string
+		wantCode int
+		wantBody string
+	}{
+		{name: "no token", method: http.MethodGet, path: "/admin/jobs", wantCode: http.StatusUnauthorized},
+		{name: "wrong token", method: http.MethodGet, path: "/admin/jobs", token: "guess", wantCode: http.StatusUnauthorized},
+		{name: "list", method: http.MethodGet, path: "/admin/jobs", token: "secret", wantCode: http.StatusOK, wantBody: `"id":"42"`},
+		{name: "get", method: http.MethodGet, path: "/admin/jobs/42", token: "secret", wantCode: http.StatusOK, wantBody: `"video_id":"video-1"`},
+		{name: "get unknown", method: http.MethodGet, path: "/admin/jobs/43", token: "secret", wantCode: http.StatusNotFound},
+		{name: "cancel bad requeue", method: http.MethodPost, path: "/admin/jobs/42/cancel?requeue=maybe", token: "secret", wantCode: http.StatusBadRequest},
+		{name: "cancel unknown", method: http.MethodPost, path: "/admin/jobs/43/cancel", token: "secret", wantCode: http.StatusNotFound},
+		{name: "cancel", method: http.MethodPost, path: "/admin/jobs/42/cancel?requeue=true", token: "secret", wantCode: http.StatusAccepted, wantBody: `{"id":"42","video_id":"video-1","requeue":true}`},
+		{name: "cancel needs post", method: http.MethodGet, path: "/admin/jobs/42/cancel", token: "secret", wantCode: http.StatusMethodNotAllowed},
+		{name: "pause", method: http.MethodPost, path: "/admin/consumer/pause", token: "secret", wantCode: http.StatusOK, wantBody: `"paused":true`},
+		{name: "status", method: http.MethodGet, path: "/admin/consumer", token: "secret", wantCode: http.StatusOK, wantBody: `{"paused":true,"running":1,"max_jobs":3}`},
+		{name: "resume", method: http.MethodPost, path: "/admin/consumer/resume", token: "secret", wantCode: http.StatusOK, wantBody: `"paused":false`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.token != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.wantCode {
+				t.Fatalf("%s %s = %d, want %d: %s", tt.method, tt.path, recorder.Code, tt.wantCode, recorder.Body)
+			}
+			if !strings.Contains(recorder.Body.String(), tt.wantBody) {
+				t.Fatalf("body = %s, want it to contain %s", recorder.Body, tt.wantBody)
+			}
+			if recorder.Code >= 400 && recorder.Code != http.StatusMethodNotAllowed {
+				var body errorResponse
+				if err := json.NewDecoder(recorder.Body).Decode(&body); err != nil || body.Error == "" {
+					t.Fatalf("error body = %+v, %v", body, err)
+				}
+			}
+		})
+	}
+
+	if cancelled, requeue := job.Cancelled(); !cancelled || !requeue {
+		t.Fatalf("job Cancelled() = %v, %v, want cancelled for requeue", cancelled, requeue)
+	}
+}
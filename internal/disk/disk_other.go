@@ -0,0 +1,18 @@
+//go:build !unix
+
+package disk
+
+import "errors"
+
+// ErrUnsupported is returned where free space cannot be queried
+var ErrUnsupported = errors.New("free disk space is not supported on this platform")
+
+// Free is not implemented on this platform
+func Free(path string) (uint64, error) {
+	return 0, ErrUnsupported
+}
+
+// Volume is not implemented on this platform
+func Volume(path string) (uint64, error) {
+	return 0, ErrUnsupported
+}
@@ -0,0 +1,28 @@
+//go:build unix
+
+package disk
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// Free returns the bytes available to unprivileged users on the volume holding path
+func Free(path string) (uint64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return 0, fmt.Errorf("failed to stat filesystem of %s: %w", path, err)
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
+
+// Volume identifies the filesystem holding path, so paths on the same volume
+// can share one free space budget
+func Volume(path string) (uint64, error) {
+	var stat unix.Stat_t
+	if err := unix.Stat(path, &stat); err != nil {
+		return 0, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	return uint64(stat.Dev), nil
+}
@@ -0,0 +1,23 @@
+package disk
+
+import (
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+// LastModified returns the newest modification time of path and everything
+// below it; the zero time when it does not exist
+func LastModified(path string) time.Time {
+	var newest time.Time
+	filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil && info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+		return nil
+	})
+	return newest
+}
@@ -0,0 +1,38 @@
+package disk
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLastModified(t *testing.T) {
+	dir := t.TempDir()
+	nested := filepath.Join(dir, "v1", "chunks")
+	if err := os.MkdirAll(nested, 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(nested, "chunk_000.mp4")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	for _, path := range []string{dir, filepath.Join(dir, "v1"), nested} {
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recent := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(file, recent, recent); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := LastModified(dir); !got.Equal(recent) {
+		t.Errorf("LastModified = %v, want the nested file's %v", got, recent)
+	}
+	if got := LastModified(filepath.Join(dir, "missing")); !got.IsZero() {
+		t.Errorf("LastModified of a missing path = %v, want the zero time", got)
+	}
+}
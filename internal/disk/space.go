@@ -0,0 +1,91 @@
+package disk
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/metrics"
+)
+
+// ErrDiskFull means a volume has no room for a job's estimated output
+var ErrDiskFull = errors.New("not enough disk space")
+
+// IsFull reports whether err means a volume ran out of space, either found by
+// a reservation or while writing
+func IsFull(err error) bool {
+	return errors.Is(err, ErrDiskFull) || errors.Is(err, syscall.ENOSPC)
+}
+
+// Space reserves the estimated output of running jobs against the free space
+// of each volume, so concurrent jobs cannot all pass the check with the same
+// free bytes. Reservations are held until the job finishes, so space a job
+// has already written counts twice; the check errs on the safe side.
+type Space struct {
+	mu       sync.Mutex
+	minFree  uint64            // Headroom kept free on every volume
+	reserved map[uint64]uint64 // Bytes reserved by running jobs, by volume
+}
+
+func NewSpace(minFreeBytes uint64) *Space {
+	return &Space{minFree: minFreeBytes, reserved: make(map[uint64]uint64)}
+}
+
+// Reserve checks that the volume of every path has room for its bytes on top
+// of the headroom and what running jobs reserved, and reserves them. Paths on
+// the same volume add up. Volumes whose free space is unknown are not checked.
+func (s *Space) Reserve(needs map[string]uint64) (release func(), err error) {
+	volumes := make(map[uint64]uint64)
+	paths := make(map[uint64]string)
+	for path, bytes := range needs {
+		if bytes == 0 {
+			continue
+		}
+		volume, err := Volume(path)
+		if err != nil {
+			continue
+		}
+		volumes[volume] += bytes
+		paths[volume] = path
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for volume, bytes := range volumes {
+		free, err := Free(paths[volume])
+		if err != nil {
+			continue
+		}
+		if available := int64(free) - int64(s.reserved[volume]) - int64(s.minFree); available < int64(bytes) {
+			return nil, fmt.Errorf("%w on the volume of %s: need %d MiB, %d MiB free with %d MiB reserved by running jobs and %d MiB kept free",
+				ErrDiskFull, paths[volume], bytes>>20, free>>20, s.reserved[volume]>>20, s.minFree>>20)
+		}
+	}
+
+	for volume, bytes := range volumes {
+		s.reserved[volume] += bytes
+	}
+	s.report()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			for volume, bytes := range volumes {
+				s.reserved[volume] -= bytes
+			}
+			s.report()
+		})
+	}, nil
+}
+
+func (s *Space) report() {
+	var total uint64
+	for _, bytes := range s.reserved {
+		total += bytes
+	}
+	metrics.DiskReservedBytes.Set(float64(total))
+}
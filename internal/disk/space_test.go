@@ -0,0 +1,103 @@
+package disk
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestReserve(t *testing.T) {
+	dir := t.TempDir()
+	free, err := Free(dir)
+	if err != nil {
+		t.Skipf("free space unknown: %v", err)
+	}
+	half := free / 2
+
+	space := NewSpace(0)
+	release, err := space.Reserve(map[string]uint64{dir: half})
+	if err != nil {
+		t.Fatalf("Reserve: %v", err)
+	}
+
+	// The first job's reservation leaves too little for a second one
+	if _, err := space.Reserve(map[string]uint64{dir: half + 1<<30}); !errors.Is(err, ErrDiskFull) {
+		t.Fatalf("second reservation error = %v, want ErrDiskFull", err)
+	}
+
+	release()
+	release() // Releasing twice must not free more than was reserved
+	if space.reserved[mustVolume(t, dir)] != 0 {
+		t.Fatalf("reserved = %d after release, want 0", space.reserved[mustVolume(t, dir)])
+	}
+	second, err := space.Reserve(map[string]uint64{dir: half})
+	if err != nil {
+		t.Fatalf("Reserve after release: %v", err)
+	}
+	second()
+}
+
+func TestReserveAddsUpPathsOnOneVolume(t *testing.T) {
+	dir := t.TempDir()
+	free, err := Free(dir)
+	if err != nil {
+		t.Skipf("free space unknown: %v", err)
+	}
+	sub := filepath.Join(dir, "hls")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	space := NewSpace(0)
+	if _, err := space.Reserve(map[string]uint64{dir: free/2 + 1<<30, sub: free/2 + 1<<30}); !errors.Is(err, ErrDiskFull) {
+		t.Fatalf("error = %v, want ErrDiskFull", err)
+	}
+}
+
+func TestReserveKeepsHeadroom(t *testing.T) {
+	dir := t.TempDir()
+	free, err := Free(dir)
+	if err != nil {
+		t.Skipf("free space unknown: %v", err)
+	}
+
+	space := NewSpace(free)
+	if _, err := space.Reserve(map[string]uint64{dir: 1 << 20}); !errors.Is(err, ErrDiskFull) {
+		t.Fatalf("error = %v, want ErrDiskFull", err)
+	}
+	// Paths needing nothing and paths that do not exist are not checked
+	release, err := space.Reserve(map[string]uint64{dir: 0, filepath.Join(dir, "missing"): 1 << 40})
+	if err != nil {
+		t.Fatalf("Reserve: %v", err)
+	}
+	release()
+}
+
+func TestIsFull(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{fmt.Errorf("reserve: %w", ErrDiskFull), true},
+		{&os.PathError{Op: "write", Path: "segment.ts", Err: syscall.ENOSPC}, true},
+		{errors.New("ffmpeg exited with status 1"), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := IsFull(tt.err); got != tt.want {
+			t.Errorf("IsFull(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func mustVolume(t *testing.T, path string) uint64 {
+	t.Helper()
+	volume, err := Volume(path)
+	if err != nil {
+		t.Fatalf("Volume: %v", err)
+	}
+	return volume
+}
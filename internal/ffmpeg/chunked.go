@@ -0,0 +1,413 @@
+package ffmpeg
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Tungwong-Project/tungwong-video-worker/configs"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/output"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/resources"
+	"github.com/Tungwong-Project/tungwong-video-worker/pkg/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// ChunkTask describes the encode of one source chunk: what to encode, never
+// paths or ffmpeg arguments. The worker running it finds the chunk in the
+// job's work directory under TEMP_PATH, which must be shared storage when
+// chunks run on other workers, and builds the ffmpeg command itself.
+type ChunkTask struct {
+	VideoID   string        `json:"video_id"`
+	Version   int           `json:"version"`
+	Chunk     int           `json:"chunk"` // Index of the chunk in the split source
+	Start     float64       `json:"start"` // Seconds into the source
+	End       float64       `json:"end"`
+	Rendition Rendition     `json:"rendition"`
+	Source    *ProbeStream  `json:"source"` // Video stream of the source, which the filters depend on
+	Settings  ChunkSettings `json:"settings"`
+
+	threads int // Threads of the dispatching job, for chunks it runs itself
+}
+
+// ChunkSettings are the encoding settings of the job a chunk belongs to
+type ChunkSettings struct {
+	Preset       string `json:"preset"`
+	CRF          int    `json:"crf"`
+	HLSTime      int    `json:"hls_time"`
+	Deinterlacer string `json:"deinterlacer"`
+	Tonemap      string `json:"tonemap,omitempty"`
+}
+
+// renditionNamePattern matches the rendition names planRenditions produces
+var renditionNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// ChunkDispatcher runs chunk encodes, locally or on other workers
+type ChunkDispatcher interface {
+	DispatchChunk(ctx context.Context, task ChunkTask) error
+}
+
+// sourceChunk is a stream-copied slice of the source starting on a keyframe
+type sourceChunk struct {
+	videoID string
+	index   int
+	start   float64
+	end     float64
+}
+
+// shouldSplit reports whether the source is long enough for split encoding
+func (e *Encoder) shouldSplit(probe *ProbeResult) bool {
+	split := e.config.Split
+	return split.Enabled &&
+		split.ChunkDuration > 0 &&
+		probe.VideoStream() != nil &&
+		probe.Duration() >= float64(split.MinDuration)
+}
+
+// workDir is the scratch directory of one job, <TEMP_PATH>/<video_id>/v<N>, so
+// an upload and a re-encode of the same video never share one
+func (e *Encoder) workDir(videoID string, version int) string {
+	return filepath.Join(e.paths.TempPath, videoID, output.VersionDir(version))
+}
+
+func (e *Encoder) removeWorkDir(videoID string, version int) {
+	if err := os.RemoveAll(e.workDir(videoID, version)); err != nil {
+		e.logger.WithError(err).WithFields(logrus.Fields{"video_id": videoID, "version": version}).Warn("Failed to remove work directory")
+	}
+	os.Remove(filepath.Join(e.paths.TempPath, videoID)) // Only once no other job of the video uses it
+}
+
+// workDirs tracks the work directories of jobs running on this worker
+type workDirs struct {
+	mu   sync.Mutex
+	dirs map[string]int
+}
+
+// use marks dir as in use until the returned func is called
+func (w *workDirs) use(dir string) (release func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.dirs[dir]++
+	return func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if w.dirs[dir]--; w.dirs[dir] <= 0 {
+			delete(w.dirs, dir)
+		}
+	}
+}
+
+// WorkDirInUse reports whether a job running on this worker works in dir
+func (e *Encoder) WorkDirInUse(dir string) bool {
+	e.workDirs.mu.Lock()
+	defer e.workDirs.mu.Unlock()
+	return e.workDirs.dirs[filepath.Clean(dir)] > 0
+}
+
+// splitSource stream-copies the video track into chunks. The segment muxer only
+// cuts on keyframes, so each chunk decodes independently; the actual cut
+// points are read back from the segment list.
+func (e *Encoder) splitSource(ctx context.Context, inputPath, videoID string) ([]sourceChunk, error) {
+	chunkDir := filepath.Join(e.workDir(videoID, e.version), "chunks")
+	if err := os.MkdirAll(chunkDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create chunk directory: %w", err)
+	}
+
+	listPath := filepath.Join(chunkDir, "chunks.csv")
+	err := e.runFFmpeg(ctx, 0, []string{
+		"-y",
+		"-i", inputPath,
+		"-map", "0:v:0",
+		"-c", "copy",
+		"-f", "segment",
+		"-segment_time", strconv.Itoa(e.config.Split.ChunkDuration),
+		"-segment_list", listPath,
+		"-segment_list_type", "csv",
+		"-reset_timestamps", "1",
+		filepath.Join(chunkDir, chunkFile+".mkv"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to split source: %w", err)
+	}
+
+	file, err := os.Open(listPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open chunk list: %w", err)
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse chunk list: %w", err)
+	}
+
+	chunks := make([]sourceChunk, 0, len(records))
+	for _, record := range records {
+		var index int
+		if len(record) < 3 {
+			continue
+		}
+		if _, err := fmt.Sscanf(record[0], chunkFile+".mkv", &index); err != nil {
+			return nil, fmt.Errorf("unexpected chunk %q in chunk list", record[0])
+		}
+		start, _ := strconv.ParseFloat(record[1], 64)
+		end, _ := strconv.ParseFloat(record[2], 64)
+		chunks = append(chunks, sourceChunk{
+			videoID: videoID,
+			index:   index,
+			start:   start,
+			end:     end,
+		})
+	}
+
+	e.logger.WithFields(logrus.Fields{
+		"video_id": videoID,
+		"chunks":   len(chunks),
+	}).Info("Source split into chunks")
+
+	return chunks, nil
+}
+
+// encodeChunks encodes every chunk of a rendition in parallel and returns the
+// concat demuxer list that joins them in order
+func (e *Encoder) encodeChunks(ctx context.Context, chunks []sourceChunk, rendition Rendition, video *ProbeStream) (string, error) {
+	videoID := chunks[0].videoID
+	chunkDir := filepath.Join(e.workDir(videoID, e.version), "chunks")
+	settings := ChunkSettings{
+		Preset:       e.config.Preset,
+		CRF:          e.config.CRF,
+		HLSTime:      e.config.HLSTime,
+		Deinterlacer: e.deinterlacer(),
+		Tonemap:      e.config.Tonemap,
+	}
+
+	tasks := make([]ChunkTask, len(chunks))
+	for i, chunk := range chunks {
+		tasks[i] = ChunkTask{
+			VideoID:   videoID,
+			Version:   e.version,
+			Chunk:     chunk.index,
+			Start:     chunk.start,
+			End:       chunk.end,
+			Rendition: rendition,
+			Source:    video,
+			Settings:  settings,
+			threads:   e.threads,
+		}
+	}
+
+	parallelism := e.config.Split.Parallelism
+	if parallelism <= 0 {
+		parallelism = 1
+	}
+
+	// The first failure cancels the chunks still running and stops queuing the rest
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		slots    = make(chan struct{}, parallelism)
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		mu.Unlock()
+		cancel()
+	}
+	for _, task := range tasks {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(task ChunkTask) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			if err := e.chunks.DispatchChunk(ctx, task); err != nil {
+				fail(fmt.Errorf("chunk %d of %s rendition failed: %w", task.Chunk, rendition.Name, err))
+			}
+		}(task)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return "", firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return "", fmt.Errorf("%s rendition cancelled: %w", rendition.Name, err)
+	}
+
+	var list strings.Builder
+	for _, task := range tasks {
+		fmt.Fprintf(&list, "file '%s'\n", chunkOutputName(task))
+	}
+
+	listPath := filepath.Join(chunkDir, fmt.Sprintf("concat_%s.txt", rendition.Name))
+	if err := os.WriteFile(listPath, []byte(list.String()), 0644); err != nil {
+		return "", fmt.Errorf("failed to write concat list: %w", err)
+	}
+
+	return listPath, nil
+}
+
+// chunkKeyFrames lists the segment boundaries falling inside a chunk, relative to
+// its start, so forced keyframes line up with the unsplit timeline
+func (e *Encoder) chunkKeyFrames(task ChunkTask) string {
+	times := []string{"0"}
+	segment := float64(e.config.HLSTime)
+
+	first := int(task.Start/segment) + 1
+	for t := float64(first) * segment; t < task.End; t += segment {
+		times = append(times, strconv.FormatFloat(t-task.Start, 'f', 3, 64))
+	}
+	return strings.Join(times, ",")
+}
+
+// chunkFile names the chunks of a split source
+const chunkFile = "chunk_%04d"
+
+// chunkOutputName is the file a chunk's encode of its rendition is written to
+func chunkOutputName(task ChunkTask) string {
+	return fmt.Sprintf(chunkFile+"_%s.mp4", task.Chunk, task.Rendition.Name)
+}
+
+// DispatchChunk runs a chunk of one of this worker's own jobs with the local
+// ffmpeg, within the threads the job was admitted with
+func (e *Encoder) DispatchChunk(ctx context.Context, task ChunkTask) error {
+	job, err := e.forChunk(ctx, task)
+	if err != nil {
+		return err
+	}
+	job.threads = task.threads
+	return job.runChunk(ctx, task)
+}
+
+// EncodeChunk runs a dispatched chunk once the chunk budget admits it
+func (e *Encoder) EncodeChunk(ctx context.Context, task ChunkTask) error {
+	job, grant, err := e.admitChunk(ctx, task)
+	if err != nil {
+		return err
+	}
+	defer grant.Release()
+	return job.runChunk(ctx, task)
+}
+
+// admitChunk waits until the chunk budget takes the cost of encoding task and
+// returns the encoder to run it with
+func (e *Encoder) admitChunk(ctx context.Context, task ChunkTask) (*Encoder, *resources.Grant, error) {
+	job, err := e.forChunk(ctx, task)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	job.budget = e.chunkBudget
+	cost, _ := job.estimateCost("", &ProbeResult{Streams: []ProbeStream{*task.Source}}, []Rendition{task.Rendition}, false)
+	grant, err := job.admit(ctx, cost, 1)
+	if err != nil {
+		return nil, nil, err
+	}
+	return job, grant, nil
+}
+
+// forChunk checks task and returns an encoder with its job's settings
+func (e *Encoder) forChunk(ctx context.Context, task ChunkTask) (*Encoder, error) {
+	settings := configs.FFmpegConfig{
+		HLSTime:      task.Settings.HLSTime,
+		Preset:       task.Settings.Preset,
+		CRF:          task.Settings.CRF,
+		VideoCodec:   task.Rendition.Codec,
+		Deinterlacer: task.Settings.Deinterlacer,
+		Tonemap:      task.Settings.Tonemap,
+	}
+	if err := errors.Join(settings.ValidateEncoding(), validateChunk(task)); err != nil {
+		return nil, fmt.Errorf("invalid chunk task: %w", err)
+	}
+
+	return &Encoder{
+		config:   &settings,
+		paths:    e.paths,
+		logger:   logger.FromContext(ctx, e.logger).WithFields(logrus.Fields{"video_id": task.VideoID, "chunk": task.Chunk}),
+		tracker:  noopTracker{},
+		version:  task.Version,
+		workDirs: e.workDirs,
+	}, nil
+}
+
+// validateChunk rejects tasks whose paths would leave the job's work
+// directory or whose values would end up unchecked in ffmpeg arguments
+func validateChunk(task ChunkTask) error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(task.VideoID != "" && task.VideoID != "." && task.VideoID != ".." && !strings.ContainsAny(task.VideoID, `/\`+"\x00"),
+		"video_id: %q is not a video ID", task.VideoID)
+	check(task.Version > 0, "version: must be positive, got %d", task.Version)
+	check(task.Chunk >= 0, "chunk: must not be negative, got %d", task.Chunk)
+	check(task.Start >= 0 && task.End > task.Start, "start, end: %v to %v is not a time range", task.Start, task.End)
+	check(renditionNamePattern.MatchString(task.Rendition.Name), "rendition.name: %q may only contain letters, digits and '_'", task.Rendition.Name)
+	check(task.Rendition.Height >= 0, "rendition.height: must not be negative, got %d", task.Rendition.Height)
+	check(task.Rendition.FrameRate >= 0 && task.Rendition.FrameRate <= 240, "rendition.frame_rate: must be between 0 and 240, got %v", task.Rendition.FrameRate)
+	check(task.Source != nil, "source: must be set")
+	if task.Rendition.HDR {
+		// The source's transfer is passed to ffmpeg as is for HDR renditions
+		check(task.Rendition.Codec == "hevc" && task.Source != nil && task.Source.HDRTransfer() != "",
+			"rendition.hdr: only HEVC renditions of PQ or HLG sources keep HDR")
+	}
+	return errors.Join(errs...)
+}
+
+// chunkPaths returns the source chunk and the encoded output of task, refusing
+// anything outside TEMP_PATH
+func (e *Encoder) chunkPaths(task ChunkTask) (input, output string, err error) {
+	dir := filepath.Join(e.workDir(task.VideoID, task.Version), "chunks")
+	input = filepath.Join(dir, fmt.Sprintf(chunkFile+".mkv", task.Chunk))
+	output = filepath.Join(dir, chunkOutputName(task))
+
+	for _, path := range []string{input, output} {
+		rel, err := filepath.Rel(e.paths.TempPath, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", "", fmt.Errorf("chunk path %s is outside the temp path", path)
+		}
+	}
+	return input, output, nil
+}
+
+// runChunk encodes the video of a chunk for its rendition
+func (e *Encoder) runChunk(ctx context.Context, task ChunkTask) error {
+	input, output, err := e.chunkPaths(task)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"-y", "-noautorotate", "-i", input, "-an", "-sn"}
+	filters := e.buildVideoFilters(task.Source, task.Rendition)
+	if vf := filters.String(); vf != "" {
+		args = append(args, "-vf", vf)
+	}
+	args = append(args, e.videoCodecArgs(task.Rendition, task.Source, filters, e.chunkKeyFrames(task))...)
+	args = append(args, output)
+
+	if err := e.runFFmpeg(ctx, 0, args); err != nil {
+		return fmt.Errorf("ffmpeg chunk encoding failed: %w", err)
+	}
+	return nil
+}
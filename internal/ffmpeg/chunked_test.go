@@ -0,0 +1,178 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Tungwong-Project/tungwong-video-worker/configs"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/resources"
+	"github.com/sirupsen/logrus"
+)
+
+func TestChunksServedWhileJobHoldsWholeBudget(t *testing.T) {
+	config := configs.FFmpegConfig{
+		VideoCodec:   "h264",
+		Preset:       "fast",
+		CRF:          23,
+		HLSTime:      6,
+		Deinterlacer: "bwdif",
+		Split:        configs.SplitEncodeConfig{Enabled: true, Remote: true},
+	}
+	encoder := NewEncoder(&config, &configs.PathsConfig{TempPath: t.TempDir()}, logrus.New())
+	budget := resources.NewBudget(4, 0)
+	encoder.SetBudget(budget)
+	encoder.SetChunkBudget(resources.NewChunkBudget(4))
+
+	// A non-split 4K job takes the whole job budget and another one queues
+	// behind it, as they would while their chunks are out on other workers
+	source := ProbeStream{CodecType: "video", Width: 3840, Height: 2160, RFrameRate: "60/1"}
+	probe := &ProbeResult{Streams: []ProbeStream{source}}
+	cost, processes := encoder.estimateCost("", probe, []Rendition{{Name: "source", Codec: "hevc"}}, false)
+	held, err := encoder.admit(context.Background(), cost, processes)
+	if err != nil {
+		t.Fatalf("admit job: %v", err)
+	}
+	defer held.Release()
+	if threads, limit, _, _ := budget.Usage(); threads != limit {
+		t.Fatalf("job holds %d of %d threads, want the whole budget", threads, limit)
+	}
+
+	queuedCtx, cancelQueued := context.WithCancel(context.Background())
+	defer cancelQueued()
+	go encoder.admit(queuedCtx, cost, processes)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for chunk := range 2 {
+		task := ChunkTask{
+			VideoID:   "video-1",
+			Version:   1,
+			Chunk:     chunk,
+			Start:     float64(chunk * 120),
+			End:       float64(chunk*120 + 120),
+			Rendition: Rendition{Name: "source", Codec: "hevc"},
+			Source:    &source,
+			Settings:  ChunkSettings{Preset: "fast", CRF: 23, HLSTime: 6, Deinterlacer: "bwdif"},
+		}
+		_, grant, err := encoder.admitChunk(ctx, task)
+		if err != nil {
+			t.Fatalf("chunk %d was not admitted: %v", chunk, err)
+		}
+		grant.Release()
+	}
+}
+
+func TestEstimateCostRemoteSplit(t *testing.T) {
+	source := ProbeStream{CodecType: "video", Width: 3840, Height: 2160, RFrameRate: "60/1"}
+	probe := &ProbeResult{Streams: []ProbeStream{source}}
+	renditions := []Rendition{{Name: "source", Codec: "hevc"}}
+
+	tests := []struct {
+		name          string
+		split         configs.SplitEncodeConfig
+		wantThreads   int
+		wantProcesses int
+	}{
+		{name: "not split", wantThreads: 45, wantProcesses: 1},
+		{name: "local split", split: configs.SplitEncodeConfig{Enabled: true, Parallelism: 4}, wantThreads: 180, wantProcesses: 4},
+		{name: "remote split", split: configs.SplitEncodeConfig{Enabled: true, Parallelism: 4, Remote: true}, wantThreads: 1, wantProcesses: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoder := NewEncoder(&configs.FFmpegConfig{Split: tt.split}, &configs.PathsConfig{}, logrus.New())
+			cost, processes := encoder.estimateCost("", probe, renditions, tt.split.Enabled)
+			if cost.Threads != tt.wantThreads || processes != tt.wantProcesses {
+				t.Fatalf("got %d threads for %d processes, want %d for %d", cost.Threads, processes, tt.wantThreads, tt.wantProcesses)
+			}
+		})
+	}
+}
+
+// failingDispatcher fails chunk 0 and blocks every other chunk until cancelled
+type failingDispatcher struct {
+	mu         sync.Mutex
+	dispatched []int
+	cancelled  int
+}
+
+func (d *failingDispatcher) DispatchChunk(ctx context.Context, task ChunkTask) error {
+	d.mu.Lock()
+	d.dispatched = append(d.dispatched, task.Chunk)
+	d.mu.Unlock()
+	if task.Chunk == 0 {
+		return errors.New("worker lost")
+	}
+	select {
+	case <-ctx.Done():
+		d.mu.Lock()
+		d.cancelled++
+		d.mu.Unlock()
+		return ctx.Err()
+	case <-time.After(5 * time.Second):
+		return nil
+	}
+}
+
+func TestEncodeChunksStopsOnFirstError(t *testing.T) {
+	config := configs.FFmpegConfig{
+		Preset:  "fast",
+		CRF:     23,
+		HLSTime: 6,
+		Split:   configs.SplitEncodeConfig{Enabled: true, Parallelism: 2},
+	}
+	encoder := NewEncoder(&config, &configs.PathsConfig{TempPath: t.TempDir()}, logrus.New())
+	dispatcher := &failingDispatcher{}
+	encoder.SetChunkDispatcher(dispatcher)
+
+	var chunks []sourceChunk
+	for i := range 6 {
+		chunks = append(chunks, sourceChunk{videoID: "video-1", index: i, start: float64(i * 60), end: float64(i*60 + 60)})
+	}
+	source := &ProbeStream{CodecType: "video", Width: 1920, Height: 1080, RFrameRate: "30/1"}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := encoder.encodeChunks(context.Background(), chunks, Rendition{Name: "source", Codec: "h264"}, source)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "chunk 0 of source rendition failed") {
+			t.Fatalf("expected chunk 0 failure, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("encodeChunks kept waiting on in-flight chunks after the first failure")
+	}
+
+	dispatcher.mu.Lock()
+	defer dispatcher.mu.Unlock()
+	if len(dispatcher.dispatched) > config.Split.Parallelism {
+		t.Fatalf("dispatched chunks %v after the first failure, want at most %d", dispatcher.dispatched, config.Split.Parallelism)
+	}
+	if dispatcher.cancelled != len(dispatcher.dispatched)-1 {
+		t.Fatalf("%d of %d in-flight chunks were cancelled", dispatcher.cancelled, len(dispatcher.dispatched)-1)
+	}
+}
+
+func TestWorkDirInUse(t *testing.T) {
+	encoder := NewEncoder(&configs.FFmpegConfig{}, &configs.PathsConfig{TempPath: "/tmp/worker"}, logrus.New())
+	dir := encoder.workDir("video-1", 2)
+
+	first := encoder.workDirs.use(dir)
+	second := encoder.workDirs.use(dir) // A retry of the same version
+	if !encoder.WorkDirInUse(dir + "/") {
+		t.Fatal("work directory not in use while jobs run in it")
+	}
+	first()
+	if !encoder.WorkDirInUse(dir) {
+		t.Fatal("work directory released while a job still runs in it")
+	}
+	second()
+	if encoder.WorkDirInUse(dir) {
+		t.Fatal("work directory still in use after all jobs finished")
+	}
+}
@@ -0,0 +1,78 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// codecLevel is one row of a codec's level limits
+type codecLevel struct {
+	name      string // As passed to the encoder
+	idc       int    // As written to the CODECS attribute
+	maxFrame  int    // Frame size in macroblocks (H.264) or luma samples (HEVC)
+	maxPerSec int64  // Same unit per second
+}
+
+// h264Levels are the H.264 levels renditions are signalled with. 4.0 is left
+// out: CRF output is not bitrate-capped and 4.1 allows the higher bitrate.
+var h264Levels = []codecLevel{
+	{"3.0", 30, 1620, 40500},
+	{"3.1", 31, 3600, 108000},
+	{"3.2", 32, 5120, 216000},
+	{"4.1", 41, 8192, 245760},
+	{"4.2", 42, 8704, 522240},
+	{"5.0", 50, 22080, 589824},
+	{"5.1", 51, 36864, 983040},
+	{"5.2", 52, 36864, 2073600},
+	{"6.0", 60, 139264, 4177920},
+	{"6.1", 61, 139264, 8355840},
+	{"6.2", 62, 139264, 16711680},
+}
+
+// hevcLevels are the HEVC Main tier levels renditions are signalled with
+var hevcLevels = []codecLevel{
+	{"3.0", 90, 552960, 16588800},
+	{"3.1", 93, 983040, 33177600},
+	{"4.0", 120, 2228224, 66846720},
+	{"4.1", 123, 2228224, 133693440},
+	{"5.0", 150, 8912896, 267386880},
+	{"5.1", 153, 8912896, 534773760},
+	{"5.2", 156, 8912896, 1069547520},
+	{"6.0", 180, 35651584, 1069547520},
+	{"6.1", 183, 35651584, 2139095040},
+	{"6.2", 186, 35651584, 4278190080},
+}
+
+// levelFor returns the lowest level of codec that fits the frame size and
+// rate, or the highest level for anything beyond it. An unknown rate is taken
+// as 60 fps so the level is never too low.
+func levelFor(codec string, width, height int, fps float64) codecLevel {
+	if fps <= 0 {
+		fps = 60
+	}
+	levels, frame := h264Levels, int64(math.Ceil(float64(width)/16)*math.Ceil(float64(height)/16))
+	if codec == "hevc" {
+		levels, frame = hevcLevels, int64(width)*int64(height)
+	}
+	perSec := int64(math.Ceil(float64(frame) * fps))
+	for _, level := range levels {
+		if frame <= int64(level.maxFrame) && perSec <= level.maxPerSec {
+			return level
+		}
+	}
+	return levels[len(levels)-1]
+}
+
+// codecsAttribute is the CODECS value of a variant: High profile H.264 or
+// Main 10 HEVC at the level it is encoded with, plus AAC-LC audio
+func codecsAttribute(codec string, level codecLevel, audio bool) string {
+	codecs := []string{fmt.Sprintf("avc1.6400%02x", level.idc)}
+	if codec == "hevc" {
+		codecs = []string{fmt.Sprintf("hvc1.2.4.L%d.B0", level.idc)}
+	}
+	if audio {
+		codecs = append(codecs, "mp4a.40.2")
+	}
+	return strings.Join(codecs, ",")
+}
@@ -0,0 +1,140 @@
+package ffmpeg
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/resources"
+	"github.com/sirupsen/logrus"
+)
+
+// threadPixelRate is the pixel rate one thread encodes in real time with
+// H.264, 720p at 30 fps
+const threadPixelRate = 1280 * 720 * 30
+
+// codecWeight is the CPU cost per pixel of a codec relative to H.264
+var codecWeight = map[string]float64{"h264": 1, "hevc": 2.5}
+
+// bitsPerPixel approximates the output size of a codec at typical CRF values
+var bitsPerPixel = map[string]float64{"h264": 0.1, "hevc": 0.06}
+
+// SetBudget admits jobs against budget before they encode. Without a budget
+// every job starts at once and ffmpeg picks its own thread count.
+func (e *Encoder) SetBudget(budget *resources.Budget) {
+	e.budget = budget
+}
+
+// SetChunkBudget admits chunks served for other workers against budget. It
+// must not be the job budget: a remote split job holds its grant while it
+// waits for its chunks.
+func (e *Encoder) SetChunkBudget(budget *resources.Budget) {
+	e.chunkBudget = budget
+}
+
+// estimateCost derives what a job needs from the probed source. Renditions
+// encode one after another, so the most expensive one (pixel rate weighted
+// by codec) sets the threads of each ffmpeg process; a local split encode
+// runs several of them at once. A remote split encode only cuts, joins and
+// packages streams itself, so one thread does. Split encodes also need
+// scratch space for the source chunks and the encoded chunks of every
+// rendition.
+func (e *Encoder) estimateCost(inputPath string, probe *ProbeResult, renditions []Rendition, split bool) (cost resources.Cost, processes int) {
+	video := probe.VideoStream()
+
+	var heaviest float64
+	for _, rendition := range renditions {
+		heaviest = max(heaviest, renditionPixelRate(video, rendition)*weight(codecWeight, rendition.Codec))
+	}
+
+	processes = 1
+	switch {
+	case split && e.config.Split.Remote:
+		cost.Threads = 1
+	case split:
+		processes = max(e.config.Split.Parallelism, 1)
+		fallthrough
+	default:
+		cost.Threads = max(int(math.Ceil(heaviest/threadPixelRate)), 1) * processes
+	}
+
+	if split {
+		if info, err := os.Stat(inputPath); err == nil {
+			cost.ScratchBytes = info.Size()
+		}
+		cost.ScratchBytes += int64(videoBytes(probe, renditions))
+	}
+	return cost, processes
+}
+
+// videoBytes estimates the size of the encoded video streams of renditions
+func videoBytes(probe *ProbeResult, renditions []Rendition) float64 {
+	video := probe.VideoStream()
+	var bytes float64
+	for _, rendition := range renditions {
+		bytes += renditionPixelRate(video, rendition) * weight(bitsPerPixel, rendition.Codec) * probe.Duration() / 8
+	}
+	return bytes
+}
+
+// renditionPixelRate is the pixels per second ffmpeg encodes for rendition
+func renditionPixelRate(video *ProbeStream, rendition Rendition) float64 {
+	if video == nil {
+		return 0
+	}
+
+	width, height := displaySize(video)
+	pixels := float64(width * height)
+	if shortSide := min(width, height); rendition.Height > 0 && shortSide > 0 {
+		scale := float64(rendition.Height) / float64(shortSide)
+		pixels *= scale * scale
+	}
+
+	fps := rendition.FrameRate
+	if fps <= 0 {
+		fps = video.FrameRate()
+	}
+	if fps <= 0 {
+		fps = 30
+	}
+	return pixels * fps
+}
+
+func weight(weights map[string]float64, codec string) float64 {
+	if w, ok := weights[codec]; ok {
+		return w
+	}
+	return weights["h264"]
+}
+
+// admit waits until the budget takes cost and sets the ffmpeg thread count
+// from what was granted. The returned grant must be released when the job's
+// encodes are done.
+func (e *Encoder) admit(ctx context.Context, cost resources.Cost, processes int) (*resources.Grant, error) {
+	if e.budget == nil {
+		return &resources.Grant{Cost: cost}, nil
+	}
+
+	grant, ok := e.budget.TryAcquire(cost)
+	if !ok {
+		e.tracker.SetStage("queued")
+		e.logger.WithFields(logrus.Fields{
+			"threads":    cost.Threads,
+			"scratch_mb": cost.ScratchBytes >> 20,
+		}).Info("Waiting for CPU threads or scratch space")
+
+		var err error
+		if grant, err = e.budget.Acquire(ctx, cost); err != nil {
+			return nil, fmt.Errorf("stopped waiting for resources: %w", err)
+		}
+	}
+
+	e.threads = max(grant.Threads/processes, 1)
+	e.logger.WithFields(logrus.Fields{
+		"threads":            grant.Threads,
+		"threads_per_ffmpeg": e.threads,
+		"scratch_mb":         grant.ScratchBytes >> 20,
+	}).Debug("Admitted job")
+	return grant, nil
+}
@@ -1,132 +1,252 @@
 package ffmpeg
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strconv"
-	"strings"
+	"sync/atomic"
 
 	"github.com/Tungwong-Project/tungwong-video-worker/configs"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/disk"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/models"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/output"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/resources"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/tracing"
+	"github.com/Tungwong-Project/tungwong-video-worker/pkg/logger"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Encoder struct {
-	config *configs.FFmpegConfig
-	paths  *configs.PathsConfig
-	logger *logrus.Logger
+	config      *configs.FFmpegConfig
+	paths       *configs.PathsConfig
+	logger      logrus.FieldLogger
+	chunks      ChunkDispatcher
+	tracker     Tracker
+	budget      *resources.Budget // Admits jobs by estimated cost; nil admits all
+	chunkBudget *resources.Budget // Admits chunks served for other workers; nil admits all
+	space       *disk.Space       // Reserves estimated output on disk; nil skips the check
+	threads     int               // ffmpeg -threads of this job, 0 lets ffmpeg decide
+	version     int               // Output version of this job, 0 writes to the video's directory itself
+	workDirs    *workDirs         // Work directories of running jobs, shared by all jobs
+
+	current atomic.Pointer[configs.FFmpegConfig] // Settings for new jobs, replaced on reload
+}
+
+// EncodeOptions carries per-job inputs besides the source file
+type EncodeOptions struct {
+	Profile   configs.EncodingProfile
+	Subtitles []SubtitleInput // Sidecar subtitle files
+	Tracker   Tracker         // Receives stage, progress and ffmpeg PID; may be nil
+	Version   int             // Writes to v<N> inside the video's output directories when set
 }
 
 type EncodeResult struct {
-	HLSPath       string
+	HLSPath       string // Master playlist
 	ThumbnailPath string
-	Duration      int // in seconds
+	SpritesPath   string // WebVTT mapping time ranges to sprite tiles
+	Duration      int    // in seconds
+	Subtitles     []SubtitleTrack
+	Renditions    []models.Rendition
+	OutputBytes   map[string]int64 // Bytes written per output type (hls, thumbnails)
 }
 
 func NewEncoder(config *configs.FFmpegConfig, paths *configs.PathsConfig, logger *logrus.Logger) *Encoder {
-	return &Encoder{
-		config: config,
-		paths:  paths,
-		logger: logger,
+	encoder := &Encoder{
+		config:   config,
+		paths:    paths,
+		logger:   logger,
+		tracker:  noopTracker{},
+		workDirs: &workDirs{dirs: make(map[string]int)},
 	}
+	encoder.chunks = encoder
+	encoder.current.Store(config)
+	return encoder
 }
 
-// EncodeToHLS converts a video file to HLS format
-func (e *Encoder) EncodeToHLS(inputPath, videoID string) (*EncodeResult, error) {
-	e.logger.WithFields(logrus.Fields{
+// SetConfig replaces the settings used by jobs started from now on; running
+// jobs keep the settings they started with
+func (e *Encoder) SetConfig(config *configs.FFmpegConfig) {
+	e.current.Store(config)
+}
+
+// SetChunkDispatcher routes chunk encodes of split jobs through dispatcher,
+// e.g. to other workers over NATS, instead of running them locally
+func (e *Encoder) SetChunkDispatcher(dispatcher ChunkDispatcher) {
+	e.chunks = dispatcher
+}
+
+// EncodeToHLS converts a video file to HLS format. Cancelling ctx kills the
+// running ffmpeg process and aborts the encode.
+func (e *Encoder) EncodeToHLS(ctx context.Context, inputPath, videoID string, opts EncodeOptions) (*EncodeResult, error) {
+	job := e.forJob(ctx, opts)
+
+	job.logger.WithFields(logrus.Fields{
 		"video_id": videoID,
 		"input":    inputPath,
+		"profile":  opts.Profile.Name,
 	}).Info("Starting HLS encoding")
 
+	stageCtx, span := job.startStage(ctx, "ffmpeg.probe", "probe")
+	probe, err := e.Probe(stageCtx, inputPath)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fail fast when the output does not fit on disk, then wait until the
+	// worker has the CPU threads and scratch space this job needs
+	split := job.shouldSplit(probe)
+	renditions := job.planRenditions(probe)
+	cost, processes := job.estimateCost(inputPath, probe, renditions, split)
+	release, err := job.reserveSpace(probe, renditions, opts.Profile, cost.ScratchBytes)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	grant, err := job.admit(ctx, cost, processes)
+	if err != nil {
+		return nil, err
+	}
+	defer grant.Release()
+
 	// Create output directory for this video
-	outputDir := filepath.Join(e.paths.OutputHLSPath, videoID)
+	outputDir := job.outputDir(e.paths.OutputHLSPath, videoID)
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create output directory: %w", err)
 	}
 
-	// Output paths
-	hlsPath := filepath.Join(outputDir, "playlist.m3u8")
-	segmentPattern := filepath.Join(outputDir, "segment_%03d.ts")
+	masterPath := filepath.Join(outputDir, "master.m3u8")
+	duration := probe.Duration()
 
-	// Build FFmpeg command for HLS encoding
-	args := []string{
-		"-i", inputPath,
-		"-c:v", "libx264",
-		"-preset", e.config.Preset,
-		"-crf", strconv.Itoa(e.config.CRF),
-		"-c:a", "aac",
-		"-b:a", "128k",
-		"-hls_time", strconv.Itoa(e.config.HLSTime),
-		"-hls_playlist_type", "vod",
-		"-hls_segment_filename", segmentPattern,
-		"-f", "hls",
-		hlsPath,
+	// Long sources are cut at keyframes once and every rendition encodes the chunks in parallel
+	var chunks []sourceChunk
+	if split {
+		stageCtx, span := job.startStage(ctx, "ffmpeg.split", "split")
+		defer job.workDirs.use(filepath.Clean(job.workDir(videoID, job.version)))()
+		chunks, err = job.splitSource(stageCtx, inputPath, videoID)
+		span.SetAttributes(attribute.Int("chunks", len(chunks)))
+		tracing.End(span, err)
+		if err != nil {
+			return nil, err
+		}
+		defer job.removeWorkDir(videoID, job.version)
 	}
 
-	cmd := exec.Command("ffmpeg", args...)
-	cmd.Stderr = os.Stderr // Show FFmpeg output
+	var variants []variantStream
+	var outputs []models.Rendition
+	for i, rendition := range renditions {
+		stageCtx, span := job.startStage(ctx, "ffmpeg.encode",
+			fmt.Sprintf("encode %s (%d/%d)", rendition.Name, i+1, len(renditions)),
+			attribute.String("rendition", rendition.Name),
+			attribute.String("codec", rendition.Codec),
+		)
+		variant, err := job.encodeRendition(stageCtx, inputPath, outputDir, rendition, probe, chunks)
+		tracing.End(span, err)
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, variant)
+		outputs = append(outputs, models.Rendition{
+			Name:       rendition.Name,
+			Codec:      rendition.Codec,
+			Width:      variant.Width,
+			Height:     variant.Height,
+			Bandwidth:  variant.Bandwidth,
+			FrameRate:  variant.FrameRate,
+			VideoRange: variant.VideoRange,
+		})
+	}
 
-	e.logger.WithField("command", strings.Join(cmd.Args, " ")).Debug("Executing FFmpeg")
+	// Extract subtitles before the master playlist so it can reference them
+	stageCtx, span = job.startStage(ctx, "ffmpeg.subtitles", "subtitles")
+	subtitles := job.extractSubtitles(stageCtx, inputPath, outputDir, probe, opts.Subtitles)
+	span.SetAttributes(attribute.Int("tracks", len(subtitles)))
+	tracing.End(span, nil)
 
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("ffmpeg encoding failed: %w", err)
+	// Replaces the preview master playlist, if any, in one atomic step
+	if err := writeMasterPlaylist(masterPath, variants, subtitles); err != nil {
+		return nil, err
 	}
+	// The preview rendition stays: players that loaded the preview master keep
+	// fetching its segments. It goes when retention prunes this version.
 
-	// Get video duration
-	duration, err := e.getVideoDuration(inputPath)
-	if err != nil {
-		e.logger.WithError(err).Warn("Failed to get video duration, using 0")
-		duration = 0
+	// Generate thumbnail and sprites when the profile asks for them
+	var thumbnailPath, spritesPath string
+	if opts.Profile.Thumbnail {
+		stageCtx, span := job.startStage(ctx, "ffmpeg.thumbnail", "thumbnail")
+		thumbnailPath, err = job.generateThumbnail(stageCtx, inputPath, videoID)
+		tracing.End(span, err)
+		if err != nil {
+			job.logger.WithError(err).Warn("Failed to generate thumbnail")
+			thumbnailPath = ""
+		}
 	}
-
-	// Generate thumbnail
-	thumbnailPath, err := e.generateThumbnail(inputPath, videoID)
-	if err != nil {
-		e.logger.WithError(err).Warn("Failed to generate thumbnail")
-		thumbnailPath = ""
+	if opts.Profile.Sprites {
+		stageCtx, span := job.startStage(ctx, "ffmpeg.sprites", "sprites")
+		spritesPath, err = job.generateSprites(stageCtx, inputPath, videoID, probe, opts.Profile.SpriteInterval)
+		tracing.End(span, err)
+		if err != nil {
+			job.logger.WithError(err).Warn("Failed to generate sprites")
+			spritesPath = ""
+		}
 	}
 
-	e.logger.WithFields(logrus.Fields{
-		"video_id": videoID,
-		"hls_path": hlsPath,
-		"duration": duration,
+	job.logger.WithFields(logrus.Fields{
+		"video_id":   videoID,
+		"hls_path":   masterPath,
+		"duration":   duration,
+		"renditions": len(variants),
+		"subtitles":  len(subtitles),
 	}).Info("HLS encoding completed")
 
 	return &EncodeResult{
-		HLSPath:       hlsPath,
+		HLSPath:       masterPath,
 		ThumbnailPath: thumbnailPath,
-		Duration:      duration,
+		SpritesPath:   spritesPath,
+		Duration:      int(duration),
+		Subtitles:     subtitles,
+		Renditions:    outputs,
+		OutputBytes: map[string]int64{
+			"hls":        dirSize(outputDir),
+			"thumbnails": dirSize(job.outputDir(e.paths.OutputThumbnailPath, videoID)),
+		},
 	}, nil
 }
 
-// getVideoDuration extracts video duration using ffprobe
-func (e *Encoder) getVideoDuration(inputPath string) (int, error) {
-	cmd := exec.Command("ffprobe",
-		"-v", "error",
-		"-show_entries", "format=duration",
-		"-of", "default=noprint_wrappers=1:nokey=1",
-		inputPath,
-	)
-
-	output, err := cmd.Output()
-	if err != nil {
-		return 0, fmt.Errorf("ffprobe failed: %w", err)
+// forJob returns an encoder using the profile's settings layered over the global
+// ones, logging with the job's logger from ctx and reporting to the job's tracker
+func (e *Encoder) forJob(ctx context.Context, opts EncodeOptions) *Encoder {
+	settings := e.current.Load().WithProfile(opts.Profile)
+	job := &Encoder{
+		config:   &settings,
+		paths:    e.paths,
+		logger:   logger.FromContext(ctx, e.logger),
+		chunks:   e.chunks,
+		tracker:  e.tracker,
+		budget:   e.budget,
+		space:    e.space,
+		version:  opts.Version,
+		workDirs: e.workDirs,
 	}
-
-	durationStr := strings.TrimSpace(string(output))
-	durationFloat, err := strconv.ParseFloat(durationStr, 64)
-	if err != nil {
-		return 0, fmt.Errorf("failed to parse duration: %w", err)
+	if opts.Tracker != nil {
+		job.tracker = opts.Tracker
 	}
+	return job
+}
 
-	return int(durationFloat), nil
+// startStage reports a new job stage to the tracker and opens a span for it
+func (e *Encoder) startStage(ctx context.Context, spanName, stage string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	e.tracker.SetStage(stage)
+	return tracing.Tracer().Start(ctx, spanName, trace.WithAttributes(attrs...))
 }
 
 // generateThumbnail creates a thumbnail from the video
-func (e *Encoder) generateThumbnail(inputPath, videoID string) (string, error) {
+func (e *Encoder) generateThumbnail(ctx context.Context, inputPath, videoID string) (string, error) {
 	// Create thumbnail directory
-	outputDir := filepath.Join(e.paths.OutputThumbnailPath, videoID)
+	outputDir := e.outputDir(e.paths.OutputThumbnailPath, videoID)
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
 		return "", fmt.Errorf("failed to create thumbnail directory: %w", err)
 	}
@@ -134,16 +254,16 @@ func (e *Encoder) generateThumbnail(inputPath, videoID string) (string, error) {
 	thumbnailPath := filepath.Join(outputDir, "thumbnail.jpg")
 
 	// Extract frame at 5 seconds (or at 10% of video duration for shorter videos)
-	cmd := exec.Command("ffmpeg",
+	err := e.runFFmpeg(ctx, 0, []string{
+		"-y", // The preview pass may already have written one
 		"-i", inputPath,
 		"-ss", "00:00:05",
 		"-vframes", "1",
 		"-vf", "scale=1280:720:force_original_aspect_ratio=decrease",
 		"-q:v", "2",
 		thumbnailPath,
-	)
-
-	if err := cmd.Run(); err != nil {
+	})
+	if err != nil {
 		return "", fmt.Errorf("thumbnail generation failed: %w", err)
 	}
 
@@ -155,16 +275,38 @@ func (e *Encoder) generateThumbnail(inputPath, videoID string) (string, error) {
 	return thumbnailPath, nil
 }
 
-// Cleanup removes temporary files if encoding fails
-func (e *Encoder) Cleanup(videoID string) {
-	dirs := []string{
-		filepath.Join(e.paths.OutputHLSPath, videoID),
-		filepath.Join(e.paths.OutputThumbnailPath, videoID),
+// outputDir is where this job writes under root: the video's directory, or
+// its v<N> subdirectory for a versioned encode
+func (e *Encoder) outputDir(root, videoID string) string {
+	if e.version > 0 {
+		return filepath.Join(root, videoID, output.VersionDir(e.version))
 	}
+	return filepath.Join(root, videoID)
+}
 
-	for _, dir := range dirs {
+// CleanupVersion removes what a failed versioned encode wrote, leaving the
+// video's other versions alone
+func (e *Encoder) CleanupVersion(videoID string, version int) {
+	for _, root := range []string{e.paths.OutputHLSPath, e.paths.OutputThumbnailPath} {
+		dir := filepath.Join(root, videoID, output.VersionDir(version))
 		if err := os.RemoveAll(dir); err != nil {
 			e.logger.WithError(err).WithField("dir", dir).Warn("Failed to cleanup directory")
 		}
 	}
+	e.removeWorkDir(videoID, version)
+}
+
+// dirSize returns the total size of regular files below dir
+func dirSize(dir string) int64 {
+	var total int64
+	filepath.WalkDir(dir, func(_ string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.Mode().IsRegular() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
 }
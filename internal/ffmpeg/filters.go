@@ -0,0 +1,166 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// standardFrameRates are the constant rates VFR sources are normalized to
+var standardFrameRates = []float64{24, 25, 30, 50, 60}
+
+// videoFilterGraph is the -vf chain for a rendition and the frame size it produces
+type videoFilterGraph struct {
+	filters []string
+	width   int
+	height  int
+}
+
+func (g *videoFilterGraph) add(filter string) {
+	g.filters = append(g.filters, filter)
+}
+
+// String renders the chain for -vf, or "" when no filtering is needed
+func (g *videoFilterGraph) String() string {
+	return strings.Join(g.filters, ",")
+}
+
+// buildVideoFilters derives the filter chain from probe results. Order matters:
+// deinterlacing must see the original fields, frame rate conversion runs before
+// scaling so dropped frames are never scaled, pixel aspect is corrected in the
+// source orientation, and padding runs last on the final frame size.
+func (e *Encoder) buildVideoFilters(video *ProbeStream, rendition Rendition) *videoFilterGraph {
+	graph := &videoFilterGraph{}
+	if video == nil {
+		return graph
+	}
+	graph.width, graph.height = video.Width, video.Height
+
+	// Deinterlace only frames flagged as interlaced, emitting one frame per frame
+	if video.Interlaced() {
+		graph.add(fmt.Sprintf("%s=mode=send_frame:parity=auto:deint=interlaced", e.deinterlacer()))
+	}
+
+	// Convert VFR sources and capped rungs to a constant frame rate
+	if rendition.FrameRate > 0 && (video.VariableFrameRate() || rendition.FrameRate < video.FrameRate()-0.01) {
+		graph.add("fps=" + formatFrameRate(rendition.FrameRate))
+	}
+
+	// Stretch non-square pixels horizontally so the output has square pixels
+	if num, den := video.SampleAspect(); num != den {
+		graph.add("scale=trunc(iw*sar/2)*2:ih,setsar=1")
+		graph.width = graph.width * num / den / 2 * 2
+	}
+
+	switch video.Rotation() {
+	case 90:
+		graph.add("transpose=clock")
+		graph.width, graph.height = graph.height, graph.width
+	case 180:
+		graph.add("hflip,vflip")
+	case 270:
+		graph.add("transpose=cclock")
+		graph.width, graph.height = graph.height, graph.width
+	}
+
+	// Scale the short side to the rung size, keeping the aspect ratio and even dimensions
+	if rendition.Height > 0 {
+		if graph.width < graph.height {
+			graph.add(fmt.Sprintf("scale=%d:-2", rendition.Height))
+			graph.height = evenRound(float64(graph.height) * float64(rendition.Height) / float64(graph.width))
+			graph.width = rendition.Height
+		} else {
+			graph.add(fmt.Sprintf("scale=-2:%d", rendition.Height))
+			graph.width = evenRound(float64(graph.width) * float64(rendition.Height) / float64(graph.height))
+			graph.height = rendition.Height
+		}
+	}
+
+	// SDR renditions of HDR sources are tone mapped to BT.709 in linear light
+	if video.HDRTransfer() != "" && !rendition.HDR {
+		graph.add("zscale=t=linear:npl=100,format=gbrpf32le,zscale=p=bt709")
+		graph.add(fmt.Sprintf("tonemap=tonemap=%s:desat=0", e.tonemapAlgorithm()))
+		graph.add("zscale=t=bt709:m=bt709:r=tv,format=yuv420p")
+	}
+
+	// libx264 with 4:2:0 chroma rejects odd dimensions
+	if graph.width%2 != 0 || graph.height%2 != 0 {
+		graph.add("pad=ceil(iw/2)*2:ceil(ih/2)*2")
+		graph.width += graph.width % 2
+		graph.height += graph.height % 2
+	}
+
+	return graph
+}
+
+// displaySize returns the upright, square-pixel frame size of the source
+func displaySize(video *ProbeStream) (width, height int) {
+	width, height = video.Width, video.Height
+	if num, den := video.SampleAspect(); num != den {
+		width = width * num / den / 2 * 2
+	}
+	if rotation := video.Rotation(); rotation == 90 || rotation == 270 {
+		width, height = height, width
+	}
+	return width, height
+}
+
+// targetFrameRate picks the constant output frame rate for a rung whose short side is
+// shortSide pixels. VFR sources snap to the nearest standard rate at or above their
+// average, and rates above the cap are divided down (59.94 to 29.97, 50 to 25) so
+// the cadence stays even.
+func (e *Encoder) targetFrameRate(video *ProbeStream, shortSide int) float64 {
+	fps := video.FrameRate()
+	if fps <= 0 {
+		return 0
+	}
+
+	if video.VariableFrameRate() {
+		standard := standardFrameRates[len(standardFrameRates)-1]
+		for _, rate := range standardFrameRates {
+			if rate >= fps-0.01 {
+				standard = rate
+				break
+			}
+		}
+		fps = standard
+	}
+
+	maxRate := float64(e.config.MaxFrameRate)
+	if shortSide < e.config.HighFrameRateMinHeight && (maxRate <= 0 || maxRate > 30) {
+		maxRate = 30
+	}
+	if maxRate > 0 && fps > maxRate+0.01 {
+		fps /= math.Ceil(fps / (maxRate + 0.01))
+	}
+
+	return fps
+}
+
+// formatFrameRate renders a rate for the fps filter, keeping NTSC rates exact
+func formatFrameRate(fps float64) string {
+	for _, base := range []float64{24, 30, 60} {
+		if math.Abs(fps-base*1000/1001) < 0.01 {
+			return fmt.Sprintf("%d/1001", int(base*1000))
+		}
+	}
+	return fmt.Sprintf("%g", math.Round(fps*1000)/1000)
+}
+
+func evenRound(v float64) int {
+	return int(math.Round(v/2)) * 2
+}
+
+func (e *Encoder) deinterlacer() string {
+	if e.config.Deinterlacer == "yadif" {
+		return "yadif"
+	}
+	return "bwdif"
+}
+
+func (e *Encoder) tonemapAlgorithm() string {
+	if e.config.Tonemap == "" {
+		return "hable"
+	}
+	return e.config.Tonemap
+}
@@ -0,0 +1,199 @@
+package ffmpeg
+
+import (
+	"math"
+	"testing"
+
+	"github.com/Tungwong-Project/tungwong-video-worker/configs"
+	"github.com/sirupsen/logrus"
+)
+
+func TestBuildVideoFilters(t *testing.T) {
+	const tonemap = "zscale=t=linear:npl=100,format=gbrpf32le,zscale=p=bt709,tonemap=tonemap=hable:desat=0,zscale=t=bt709:m=bt709:r=tv,format=yuv420p"
+	hd := ProbeStream{Width: 1920, Height: 1080, RFrameRate: "30/1", AvgFrameRate: "30/1"}
+	with := func(change func(s *ProbeStream)) *ProbeStream {
+		stream := hd
+		change(&stream)
+		return &stream
+	}
+
+	tests := []struct {
+		name         string
+		deinterlacer string
+		video        *ProbeStream
+		rendition    Rendition
+		want         string
+		wantWidth    int
+		wantHeight   int
+	}{
+		{name: "no video"},
+		{name: "untouched", video: &hd, want: "", wantWidth: 1920, wantHeight: 1080},
+		{name: "scaled", video: &hd, rendition: Rendition{Height: 720}, want: "scale=-2:720", wantWidth: 1280, wantHeight: 720},
+		{
+			name:      "rotated 90 by display matrix",
+			video:     with(func(s *ProbeStream) { s.SideDataList = []map[string]interface{}{{"rotation": float64(-90)}} }),
+			rendition: Rendition{Height: 720},
+			want:      "transpose=clock,scale=720:-2",
+			wantWidth: 720, wantHeight: 1280,
+		},
+		{
+			name:      "rotated 180 by tag",
+			video:     with(func(s *ProbeStream) { s.Tags = map[string]string{"rotate": "180"} }),
+			want:      "hflip,vflip",
+			wantWidth: 1920, wantHeight: 1080,
+		},
+		{
+			name:      "rotated 270",
+			video:     with(func(s *ProbeStream) { s.SideDataList = []map[string]interface{}{{"rotation": float64(90)}} }),
+			want:      "transpose=cclock",
+			wantWidth: 1080, wantHeight: 1920,
+		},
+		{
+			name:      "anamorphic pal",
+			video:     &ProbeStream{Width: 720, Height: 576, SampleAspectRatio: "16:15"},
+			want:      "scale=trunc(iw*sar/2)*2:ih,setsar=1",
+			wantWidth: 768, wantHeight: 576,
+		},
+		{
+			name:      "square pixels",
+			video:     with(func(s *ProbeStream) { s.SampleAspectRatio = "1:1" }),
+			want:      "",
+			wantWidth: 1920, wantHeight: 1080,
+		},
+		{
+			name:      "odd dimensions padded",
+			video:     &ProbeStream{Width: 853, Height: 481},
+			want:      "pad=ceil(iw/2)*2:ceil(ih/2)*2",
+			wantWidth: 854, wantHeight: 482,
+		},
+		{
+			name:      "interlaced",
+			video:     with(func(s *ProbeStream) { s.FieldOrder = "tt" }),
+			rendition: Rendition{Height: 720},
+			want:      "bwdif=mode=send_frame:parity=auto:deint=interlaced,scale=-2:720",
+			wantWidth: 1280, wantHeight: 720,
+		},
+		{
+			name:         "interlaced with yadif",
+			deinterlacer: "yadif",
+			video:        with(func(s *ProbeStream) { s.FieldOrder = "bb" }),
+			want:         "yadif=mode=send_frame:parity=auto:deint=interlaced",
+			wantWidth:    1920, wantHeight: 1080,
+		},
+		{
+			name:      "progressive",
+			video:     with(func(s *ProbeStream) { s.FieldOrder = "progressive" }),
+			want:      "",
+			wantWidth: 1920, wantHeight: 1080,
+		},
+		{
+			name:      "frame rate cap",
+			video:     with(func(s *ProbeStream) { s.RFrameRate, s.AvgFrameRate = "60000/1001", "60000/1001" }),
+			rendition: Rendition{Height: 480, FrameRate: 30000.0 / 1001},
+			want:      "fps=30000/1001,scale=-2:480",
+			wantWidth: 854, wantHeight: 480,
+		},
+		{
+			name:      "frame rate kept",
+			video:     &hd,
+			rendition: Rendition{Height: 480, FrameRate: 30},
+			want:      "scale=-2:480",
+			wantWidth: 854, wantHeight: 480,
+		},
+		{
+			name:      "vfr normalized",
+			video:     with(func(s *ProbeStream) { s.AvgFrameRate = "2850/100" }),
+			rendition: Rendition{FrameRate: 30},
+			want:      "fps=30",
+			wantWidth: 1920, wantHeight: 1080,
+		},
+		{
+			name:      "hdr tone mapped after scaling",
+			video:     with(func(s *ProbeStream) { s.ColorTransfer = "smpte2084" }),
+			rendition: Rendition{Height: 720},
+			want:      "scale=-2:720," + tonemap,
+			wantWidth: 1280, wantHeight: 720,
+		},
+		{
+			name:      "hdr rendition keeps hdr",
+			video:     with(func(s *ProbeStream) { s.ColorTransfer = "arib-std-b67" }),
+			rendition: Rendition{Height: 720, HDR: true},
+			want:      "scale=-2:720",
+			wantWidth: 1280, wantHeight: 720,
+		},
+		{
+			name: "every filter in order",
+			video: &ProbeStream{
+				Width: 1441, Height: 1080, SampleAspectRatio: "4:3", FieldOrder: "tb",
+				RFrameRate: "50/1", AvgFrameRate: "25/1", ColorTransfer: "smpte2084",
+				Tags: map[string]string{"rotate": "90"},
+			},
+			rendition: Rendition{Height: 1081, FrameRate: 25},
+			want: "bwdif=mode=send_frame:parity=auto:deint=interlaced,scale=trunc(iw*sar/2)*2:ih,setsar=1," +
+				"transpose=clock,scale=1081:-2," + tonemap + ",pad=ceil(iw/2)*2:ceil(ih/2)*2",
+			wantWidth: 1082, wantHeight: 1922,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoder := NewEncoder(&configs.FFmpegConfig{Deinterlacer: tt.deinterlacer}, &configs.PathsConfig{}, logrus.New())
+			graph := encoder.buildVideoFilters(tt.video, tt.rendition)
+			if got := graph.String(); got != tt.want {
+				t.Fatalf("filters:\n got %s\nwant %s", got, tt.want)
+			}
+			if graph.width != tt.wantWidth || graph.height != tt.wantHeight {
+				t.Fatalf("size = %dx%d, want %dx%d", graph.width, graph.height, tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+}
+
+func TestTargetFrameRate(t *testing.T) {
+	tests := []struct {
+		name      string
+		rRate     string
+		avg       string
+		maxRate   int
+		shortSide int
+		want      float64
+	}{
+		{name: "23.976 uncapped", rRate: "24000/1001", avg: "24000/1001", shortSide: 1080, want: 23.976},
+		{name: "23.976 under 24 cap", rRate: "24000/1001", avg: "24000/1001", maxRate: 24, shortSide: 1080, want: 23.976},
+		{name: "23.976 low rung", rRate: "24000/1001", avg: "24000/1001", maxRate: 60, shortSide: 360, want: 23.976},
+		{name: "29.97 under 60 cap", rRate: "30000/1001", avg: "30000/1001", maxRate: 60, shortSide: 1080, want: 29.970},
+		{name: "29.97 at 30 cap", rRate: "30000/1001", avg: "30000/1001", maxRate: 30, shortSide: 1080, want: 29.970},
+		{name: "29.97 halved by 24 cap", rRate: "30000/1001", avg: "30000/1001", maxRate: 24, shortSide: 1080, want: 14.985},
+		{name: "29.97 interlaced", rRate: "60/1", avg: "30000/1001", maxRate: 60, shortSide: 1080, want: 29.970},
+		{name: "50 under 60 cap", rRate: "50/1", avg: "50/1", maxRate: 60, shortSide: 1080, want: 50},
+		{name: "50 halved by 30 cap", rRate: "50/1", avg: "50/1", maxRate: 30, shortSide: 1080, want: 25},
+		{name: "50 low rung", rRate: "50/1", avg: "50/1", maxRate: 60, shortSide: 480, want: 25},
+		{name: "50 thirded by 24 cap", rRate: "50/1", avg: "50/1", maxRate: 24, shortSide: 1080, want: 16.667},
+		{name: "59.94 under 60 cap", rRate: "60000/1001", avg: "60000/1001", maxRate: 60, shortSide: 1080, want: 59.940},
+		{name: "59.94 halved by 30 cap", rRate: "60000/1001", avg: "60000/1001", maxRate: 30, shortSide: 1080, want: 29.970},
+		{name: "59.94 low rung", rRate: "60000/1001", avg: "60000/1001", maxRate: 60, shortSide: 719, want: 29.970},
+		{name: "59.94 at the high rate height", rRate: "60000/1001", avg: "60000/1001", maxRate: 60, shortSide: 720, want: 59.940},
+		{name: "120 uncapped", rRate: "120/1", avg: "120/1", shortSide: 1080, want: 120},
+		{name: "120 halved by 60 cap", rRate: "120/1", avg: "120/1", maxRate: 60, shortSide: 1080, want: 60},
+		{name: "120 quartered by 30 cap", rRate: "120/1", avg: "120/1", maxRate: 30, shortSide: 1080, want: 30},
+		{name: "120 fifthed by 24 cap", rRate: "120/1", avg: "120/1", maxRate: 24, shortSide: 1080, want: 24},
+		{name: "120 uncapped low rung", rRate: "120/1", avg: "120/1", shortSide: 480, want: 30},
+		{name: "120 under a 120 cap", rRate: "120/1", avg: "120/1", maxRate: 120, shortSide: 1080, want: 120},
+		{name: "vfr phone footage", rRate: "30/1", avg: "2850/100", maxRate: 60, shortSide: 1080, want: 30},
+		{name: "vfr screen recording", rRate: "60/1", avg: "1873/100", maxRate: 60, shortSide: 1080, want: 24},
+		{name: "vfr between standard rates", rRate: "60/1", avg: "4200/100", maxRate: 60, shortSide: 1080, want: 50},
+		{name: "vfr snapped then capped", rRate: "60/1", avg: "5500/100", maxRate: 30, shortSide: 1080, want: 30},
+		{name: "vfr low rung", rRate: "60/1", avg: "5500/100", maxRate: 60, shortSide: 480, want: 30},
+		{name: "vfr above standard rates", rRate: "240/1", avg: "9000/100", shortSide: 1080, want: 60},
+		{name: "unknown rate", rRate: "0/0", avg: "0/0", maxRate: 60, shortSide: 1080, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := configs.FFmpegConfig{MaxFrameRate: tt.maxRate, HighFrameRateMinHeight: 720}
+			encoder := NewEncoder(&config, &configs.PathsConfig{}, logrus.New())
+			video := &ProbeStream{RFrameRate: tt.rRate, AvgFrameRate: tt.avg}
+			if got := encoder.targetFrameRate(video, tt.shortSide); math.Abs(got-tt.want) > 0.001 {
+				t.Fatalf("targetFrameRate = %.3f, want %.3f", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package ffmpeg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/Tungwong-Project/tungwong-video-worker/configs"
+	"github.com/sirupsen/logrus"
+)
+
+// MezzanineExt is the container of mezzanine files
+const MezzanineExt = ".mkv"
+
+// EncodeMezzanine transcodes inputPath into a high-quality archive copy at
+// outputPath, keeping the source size, frame rate and colors, and copying the
+// audio. Embedded subtitles are not carried over. The file is written next to
+// outputPath and renamed into place once complete.
+func (e *Encoder) EncodeMezzanine(ctx context.Context, inputPath, outputPath string, source configs.SourceConfig, tracker Tracker) error {
+	job := e.forJob(ctx, EncodeOptions{
+		Profile: configs.EncodingProfile{
+			Name:       "mezzanine",
+			VideoCodec: source.MezzanineCodec,
+			Preset:     source.MezzaninePreset,
+			CRF:        source.MezzanineCRF,
+		},
+		Tracker: tracker,
+	})
+
+	job.tracker.SetStage("mezzanine")
+	probe, err := e.Probe(ctx, inputPath)
+	if err != nil {
+		return err
+	}
+
+	rendition := Rendition{Name: "mezzanine", Codec: job.config.VideoCodec}
+	if e.space != nil {
+		bytes := videoBytes(probe, []Rendition{rendition}) * outputMargin
+		release, err := e.space.Reserve(map[string]uint64{filepath.Dir(outputPath): uint64(bytes)})
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+	cost, processes := job.estimateCost(inputPath, probe, []Rendition{rendition}, false)
+	grant, err := job.admit(ctx, cost, processes)
+	if err != nil {
+		return err
+	}
+	defer grant.Release()
+
+	args := []string{
+		"-y",
+		"-i", inputPath,
+		"-map", "0:v:0",
+		"-map", "0:a?",
+		"-c:a", "copy",
+		"-preset", job.config.Preset,
+		"-crf", strconv.Itoa(job.config.CRF),
+	}
+	if job.config.VideoCodec == "hevc" {
+		args = append(args, "-c:v", "libx265")
+		if job.threads > 0 {
+			args = append(args, "-x265-params", fmt.Sprintf("pools=%d", job.threads))
+		}
+	} else {
+		args = append(args, "-c:v", "libx264")
+		if job.threads > 0 {
+			args = append(args, "-threads", strconv.Itoa(job.threads))
+		}
+	}
+
+	partial := outputPath + ".partial"
+	args = append(args, "-f", "matroska", partial)
+	if err := job.runFFmpeg(ctx, probe.Duration(), args); err != nil {
+		os.Remove(partial)
+		return fmt.Errorf("mezzanine transcode failed: %w", err)
+	}
+	if err := os.Rename(partial, outputPath); err != nil {
+		os.Remove(partial)
+		return fmt.Errorf("failed to move mezzanine into place: %w", err)
+	}
+
+	job.logger.WithFields(logrus.Fields{
+		"input":  inputPath,
+		"output": outputPath,
+		"codec":  job.config.VideoCodec,
+	}).Info("Mezzanine transcode completed")
+	return nil
+}
@@ -0,0 +1,111 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const subtitleGroupID = "subs"
+
+// variantStream is one #EXT-X-STREAM-INF entry of the master playlist
+type variantStream struct {
+	URI        string // Relative to the master playlist
+	Bandwidth  int
+	Codecs     string // RFC 6381 codecs of video and audio
+	FMP4       bool   // Segments are fragmented MP4 rather than MPEG-TS
+	Width      int
+	Height     int
+	VideoRange string // SDR, PQ or HLG
+	FrameRate  float64
+}
+
+// writeMasterPlaylist writes the multivariant playlist tying video variants and subtitle tracks together.
+// The file is replaced atomically so players never read a partially written playlist.
+func writeMasterPlaylist(path string, variants []variantStream, subtitles []SubtitleTrack) error {
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(masterPlaylist(variants, subtitles)), 0644); err != nil {
+		return fmt.Errorf("failed to write master playlist: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace master playlist: %w", err)
+	}
+	return nil
+}
+
+// masterPlaylist renders the multivariant playlist. Variants announce their
+// codecs so players skip what they cannot decode, and fMP4 segments need
+// protocol version 7.
+func masterPlaylist(variants []variantStream, subtitles []SubtitleTrack) string {
+	version := 3
+	for _, variant := range variants {
+		if variant.FMP4 {
+			version = 7
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	fmt.Fprintf(&b, "#EXT-X-VERSION:%d\n", version)
+
+	for _, track := range subtitles {
+		fmt.Fprintf(&b, "#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=%s,NAME=%s", quoted(subtitleGroupID), quoted(track.Label))
+		if track.Language != "" {
+			fmt.Fprintf(&b, ",LANGUAGE=%s", quoted(track.Language))
+		}
+		fmt.Fprintf(&b, ",DEFAULT=%s,AUTOSELECT=YES,URI=%s\n", yesNo(track.Default), quoted(track.PlaylistPath))
+	}
+
+	for _, variant := range variants {
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d", variant.Bandwidth)
+		if variant.Codecs != "" {
+			fmt.Fprintf(&b, ",CODECS=%s", quoted(variant.Codecs))
+		}
+		if variant.Width > 0 && variant.Height > 0 {
+			fmt.Fprintf(&b, ",RESOLUTION=%dx%d", variant.Width, variant.Height)
+		}
+		if variant.FrameRate > 0 {
+			fmt.Fprintf(&b, ",FRAME-RATE=%.3f", variant.FrameRate)
+		}
+		if variant.VideoRange != "" {
+			fmt.Fprintf(&b, ",VIDEO-RANGE=%s", variant.VideoRange)
+		}
+		if len(subtitles) > 0 {
+			fmt.Fprintf(&b, ",SUBTITLES=%s", quoted(subtitleGroupID))
+		}
+		fmt.Fprintf(&b, "\n%s\n", variant.URI)
+	}
+	return b.String()
+}
+
+// measureBandwidth estimates the peak bitrate of a variant from the size of its segments
+func measureBandwidth(dir, segmentGlob string, duration float64) int {
+	segments, err := filepath.Glob(filepath.Join(dir, segmentGlob))
+	if err != nil || len(segments) == 0 || duration <= 0 {
+		return 0
+	}
+
+	var total int64
+	for _, segment := range segments {
+		if info, err := os.Stat(segment); err == nil {
+			total += info.Size()
+		}
+	}
+
+	// Segment sizes vary, so report average bitrate with headroom as the peak
+	return int(float64(total*8) / duration * 1.2)
+}
+
+// quoted renders an HLS quoted-string. HLS has no escapes, so double quotes,
+// carriage returns and line feeds are dropped; everything else is kept as is.
+func quoted(value string) string {
+	return `"` + strings.NewReplacer(`"`, "", "\r", "", "\n", "").Replace(value) + `"`
+}
+
+func yesNo(v bool) string {
+	if v {
+		return "YES"
+	}
+	return "NO"
+}
@@ -0,0 +1,89 @@
+package ffmpeg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMasterPlaylistMixedLadder(t *testing.T) {
+	variants := []variantStream{
+		{
+			URI: "2160p_hdr/playlist.m3u8", Bandwidth: 24000000, Width: 3840, Height: 2160, FrameRate: 23.976,
+			Codecs: codecsAttribute("hevc", levelFor("hevc", 3840, 2160, 23.976), true), FMP4: true, VideoRange: "PQ",
+		},
+		{
+			URI: "1080p/playlist.m3u8", Bandwidth: 6000000, Width: 1920, Height: 1080, FrameRate: 23.976,
+			Codecs: codecsAttribute("h264", levelFor("h264", 1920, 1080, 23.976), true), VideoRange: "SDR",
+		},
+		{
+			URI: "720p/playlist.m3u8", Bandwidth: 3000000, Width: 1280, Height: 720, FrameRate: 23.976,
+			Codecs: codecsAttribute("h264", levelFor("h264", 1280, 720, 23.976), true), VideoRange: "SDR",
+		},
+	}
+	subtitles := []SubtitleTrack{{Label: "English", Language: "en", Default: true, PlaylistPath: "subtitles/en/playlist.m3u8"}}
+
+	want := `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="English",LANGUAGE="en",DEFAULT=YES,AUTOSELECT=YES,URI="subtitles/en/playlist.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=24000000,CODECS="hvc1.2.4.L150.B0,mp4a.40.2",RESOLUTION=3840x2160,FRAME-RATE=23.976,VIDEO-RANGE=PQ,SUBTITLES="subs"
+2160p_hdr/playlist.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=6000000,CODECS="avc1.640029,mp4a.40.2",RESOLUTION=1920x1080,FRAME-RATE=23.976,VIDEO-RANGE=SDR,SUBTITLES="subs"
+1080p/playlist.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=3000000,CODECS="avc1.64001f,mp4a.40.2",RESOLUTION=1280x720,FRAME-RATE=23.976,VIDEO-RANGE=SDR,SUBTITLES="subs"
+720p/playlist.m3u8
+`
+	if got := masterPlaylist(variants, subtitles); got != want {
+		t.Fatalf("master playlist:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestMasterPlaylistVersion(t *testing.T) {
+	sdr := variantStream{URI: "720p/playlist.m3u8", Bandwidth: 3000000}
+	if got := masterPlaylist([]variantStream{sdr}, nil); !strings.HasPrefix(got, "#EXTM3U\n#EXT-X-VERSION:3\n") {
+		t.Fatalf("MPEG-TS only playlist:\n%s\nwant version 3", got)
+	}
+}
+
+func TestLevelFor(t *testing.T) {
+	tests := []struct {
+		codec         string
+		width, height int
+		fps           float64
+		want          string
+	}{
+		{codec: "h264", width: 640, height: 360, fps: 30, want: "3.0"},
+		{codec: "h264", width: 1280, height: 720, fps: 30, want: "3.1"},
+		{codec: "h264", width: 1280, height: 720, fps: 60, want: "3.2"},
+		{codec: "h264", width: 1920, height: 1080, fps: 30, want: "4.1"},
+		{codec: "h264", width: 1920, height: 1080, fps: 60, want: "4.2"},
+		{codec: "h264", width: 3840, height: 2160, fps: 30, want: "5.1"},
+		{codec: "h264", width: 1920, height: 1080, fps: 0, want: "4.2"},
+		{codec: "hevc", width: 1920, height: 1080, fps: 30, want: "4.0"},
+		{codec: "hevc", width: 1920, height: 1080, fps: 60, want: "4.1"},
+		{codec: "hevc", width: 3840, height: 2160, fps: 30, want: "5.0"},
+		{codec: "hevc", width: 3840, height: 2160, fps: 60, want: "5.1"},
+		{codec: "hevc", width: 15360, height: 8640, fps: 120, want: "6.2"},
+	}
+	for _, tt := range tests {
+		if got := levelFor(tt.codec, tt.width, tt.height, tt.fps).name; got != tt.want {
+			t.Errorf("levelFor(%s, %dx%d@%g) = %s, want %s", tt.codec, tt.width, tt.height, tt.fps, got, tt.want)
+		}
+	}
+}
+
+func TestMasterPlaylistQuotedStrings(t *testing.T) {
+	subtitles := []SubtitleTrack{{
+		Label:        "Director's \"commentary\"\r\nby Ана\\Bob",
+		Language:     "ru\n",
+		PlaylistPath: "subtitles/0_ru/playlist.m3u8",
+	}}
+	want := `#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="Director's commentaryby Ана\Bob",LANGUAGE="ru",DEFAULT=NO,AUTOSELECT=YES,URI="subtitles/0_ru/playlist.m3u8"` + "\n"
+
+	got := masterPlaylist(nil, subtitles)
+	if !strings.Contains(got, want) {
+		t.Fatalf("master playlist:\n%s\nwant line:\n%s", got, want)
+	}
+	if lines := strings.Count(got, "\n"); lines != 3 {
+		t.Fatalf("master playlist has %d lines, want 3:\n%s", lines, got)
+	}
+}
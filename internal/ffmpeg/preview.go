@@ -0,0 +1,104 @@
+package ffmpeg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/output"
+	"github.com/sirupsen/logrus"
+)
+
+const previewRenditionName = "preview"
+
+// EncodePreview quickly encodes a single low-resolution rendition and publishes
+// a master playlist pointing at it, so the video is watchable while the full
+// ladder is still encoding. EncodeToHLS later swaps the master playlist.
+func (e *Encoder) EncodePreview(ctx context.Context, inputPath, videoID string, opts EncodeOptions) (*EncodeResult, error) {
+	job := e.forJob(ctx, opts)
+
+	job.logger.WithFields(logrus.Fields{
+		"video_id": videoID,
+		"profile":  opts.Profile.Name,
+	}).Info("Starting preview encoding")
+
+	job.tracker.SetStage("preview")
+	probe, err := e.Probe(ctx, inputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the first (largest) SDR rung of the preview profile is encoded
+	rendition := job.planRenditions(probe)[0]
+	rendition.Name = previewRenditionName
+
+	cost, processes := job.estimateCost(inputPath, probe, []Rendition{rendition}, false)
+	images := opts.Profile
+	images.Sprites = false // The preview only makes the thumbnail
+	release, err := job.reserveSpace(probe, []Rendition{rendition}, images, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	grant, err := job.admit(ctx, cost, processes)
+	if err != nil {
+		return nil, err
+	}
+	defer grant.Release()
+
+	outputDir := job.outputDir(e.paths.OutputHLSPath, videoID)
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	variant, err := job.encodeRendition(ctx, inputPath, outputDir, rendition, probe, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	masterPath := filepath.Join(outputDir, "master.m3u8")
+	if err := writeMasterPlaylist(masterPath, []variantStream{variant}, nil); err != nil {
+		return nil, err
+	}
+
+	var thumbnailPath string
+	if opts.Profile.Thumbnail {
+		thumbnailPath, err = job.generateThumbnail(ctx, inputPath, videoID)
+		if err != nil {
+			job.logger.WithError(err).Warn("Failed to generate thumbnail")
+			thumbnailPath = ""
+		}
+	}
+
+	job.logger.WithFields(logrus.Fields{
+		"video_id": videoID,
+		"hls_path": masterPath,
+	}).Info("Preview encoding completed")
+
+	return &EncodeResult{
+		HLSPath:       masterPath,
+		ThumbnailPath: thumbnailPath,
+		Duration:      int(probe.Duration()),
+	}, nil
+}
+
+// CleanupExceptPreview removes what a failed versioned encode wrote but keeps
+// the published preview: its rendition, the master playlist pointing at it and
+// the thumbnails
+func (e *Encoder) CleanupExceptPreview(videoID string, version int) {
+	dir := filepath.Join(e.paths.OutputHLSPath, videoID, output.VersionDir(version))
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		e.logger.WithError(err).WithField("dir", dir).Warn("Failed to cleanup directory")
+	}
+	for _, entry := range entries {
+		if entry.Name() == previewRenditionName || entry.Name() == "master.m3u8" {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			e.logger.WithError(err).WithField("path", filepath.Join(dir, entry.Name())).Warn("Failed to cleanup directory")
+		}
+	}
+	e.removeWorkDir(videoID, version)
+}
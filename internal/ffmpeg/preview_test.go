@@ -0,0 +1,58 @@
+package ffmpeg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Tungwong-Project/tungwong-video-worker/configs"
+	"github.com/sirupsen/logrus"
+)
+
+func TestCleanupExceptPreview(t *testing.T) {
+	paths := &configs.PathsConfig{
+		OutputHLSPath:       t.TempDir(),
+		OutputThumbnailPath: t.TempDir(),
+		TempPath:            t.TempDir(),
+	}
+	encoder := NewEncoder(&configs.FFmpegConfig{}, paths, logrus.New())
+
+	files := map[string]bool{ // Whether the file survives
+		"hls/video-1/v2/master.m3u8":             true,
+		"hls/video-1/v2/preview/playlist.m3u8":   true,
+		"hls/video-1/v2/preview/segment_000.ts":  true,
+		"hls/video-1/v2/1080p/segment_000.ts":    false,
+		"hls/video-1/v2/subtitles/0_en/full.vtt": false,
+		"thumbnails/video-1/v2/thumbnail.jpg":    true,
+		"hls/video-1/v1/master.m3u8":             true,
+		"hls/video-1/v1/1080p/segment_000.ts":    true,
+	}
+	path := func(name string) string {
+		if rest, ok := strings.CutPrefix(name, "hls/"); ok {
+			return filepath.Join(paths.OutputHLSPath, rest)
+		}
+		rest, _ := strings.CutPrefix(name, "thumbnails/")
+		return filepath.Join(paths.OutputThumbnailPath, rest)
+	}
+	for name := range files {
+		if err := os.MkdirAll(filepath.Dir(path(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path(name), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	encoder.CleanupExceptPreview("video-1", 2)
+
+	for name, kept := range files {
+		_, err := os.Stat(path(name))
+		if kept && err != nil {
+			t.Errorf("%s was removed", name)
+		}
+		if !kept && err == nil {
+			t.Errorf("%s was kept", name)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package ffmpeg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ProbeResult holds the container and stream metadata reported by ffprobe
+type ProbeResult struct {
+	Format  ProbeFormat   `json:"format"`
+	Streams []ProbeStream `json:"streams"`
+}
+
+type ProbeFormat struct {
+	FormatName string `json:"format_name"`
+	Duration   string `json:"duration"`
+	BitRate    string `json:"bit_rate"`
+}
+
+type ProbeStream struct {
+	Index             int                      `json:"index"`
+	CodecType         string                   `json:"codec_type"`
+	CodecName         string                   `json:"codec_name"`
+	Width             int                      `json:"width"`
+	Height            int                      `json:"height"`
+	SampleAspectRatio string                   `json:"sample_aspect_ratio"`
+	FieldOrder        string                   `json:"field_order"`
+	RFrameRate        string                   `json:"r_frame_rate"`
+	AvgFrameRate      string                   `json:"avg_frame_rate"`
+	PixFmt            string                   `json:"pix_fmt"`
+	ColorTransfer     string                   `json:"color_transfer"`
+	ColorPrimaries    string                   `json:"color_primaries"`
+	ColorSpace        string                   `json:"color_space"`
+	Tags              map[string]string        `json:"tags"`
+	Disposition       map[string]int           `json:"disposition"`
+	SideDataList      []map[string]interface{} `json:"side_data_list"`
+}
+
+// Probe inspects the input file with ffprobe
+func (e *Encoder) Probe(ctx context.Context, inputPath string) (*ProbeResult, error) {
+	cmd := exec.CommandContext(ctx, "ffprobe",
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		inputPath,
+	)
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	var result ProbeResult
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	return &result, nil
+}
+
+// Duration returns the container duration in seconds, or 0 if unknown
+func (r *ProbeResult) Duration() float64 {
+	duration, err := strconv.ParseFloat(r.Format.Duration, 64)
+	if err != nil {
+		return 0
+	}
+	return duration
+}
+
+// VideoStream returns the first video stream that is not cover art, or nil if there is none
+func (r *ProbeResult) VideoStream() *ProbeStream {
+	for i := range r.Streams {
+		if r.Streams[i].CodecType == "video" && r.Streams[i].Disposition["attached_pic"] == 0 {
+			return &r.Streams[i]
+		}
+	}
+	return nil
+}
+
+// HasAudio reports whether the input has an audio stream
+func (r *ProbeResult) HasAudio() bool {
+	for _, s := range r.Streams {
+		if s.CodecType == "audio" {
+			return true
+		}
+	}
+	return false
+}
+
+// SubtitleStreams returns all subtitle streams in input order
+func (r *ProbeResult) SubtitleStreams() []ProbeStream {
+	var streams []ProbeStream
+	for _, s := range r.Streams {
+		if s.CodecType == "subtitle" {
+			streams = append(streams, s)
+		}
+	}
+	return streams
+}
+
+// Rotation returns the clockwise rotation in degrees (0, 90, 180 or 270) needed
+// to display the stream upright, from the display matrix or the legacy rotate tag
+func (s *ProbeStream) Rotation() int {
+	degrees := 0
+	if tag, ok := s.Tags["rotate"]; ok {
+		degrees, _ = strconv.Atoi(tag)
+	}
+	for _, sideData := range s.SideDataList {
+		// The display matrix stores counter-clockwise rotation
+		if rotation, ok := sideData["rotation"].(float64); ok {
+			degrees = -int(rotation)
+		}
+	}
+
+	degrees %= 360
+	if degrees < 0 {
+		degrees += 360
+	}
+	// Snap to the nearest quarter turn; arbitrary angles are not supported
+	return (degrees + 45) / 90 * 90 % 360
+}
+
+// SampleAspect returns the sample (pixel) aspect ratio, defaulting to square pixels
+func (s *ProbeStream) SampleAspect() (num, den int) {
+	parts := strings.Split(s.SampleAspectRatio, ":")
+	if len(parts) != 2 {
+		return 1, 1
+	}
+	num, errNum := strconv.Atoi(parts[0])
+	den, errDen := strconv.Atoi(parts[1])
+	if errNum != nil || errDen != nil || num <= 0 || den <= 0 {
+		return 1, 1
+	}
+	return num, den
+}
+
+// Interlaced reports whether the field order marks the stream as interlaced
+func (s *ProbeStream) Interlaced() bool {
+	switch s.FieldOrder {
+	case "tt", "bb", "tb", "bt":
+		return true
+	}
+	return false
+}
+
+// HDRTransfer returns the HLS VIDEO-RANGE of an HDR stream (PQ or HLG), or "" for SDR
+func (s *ProbeStream) HDRTransfer() string {
+	switch s.ColorTransfer {
+	case "smpte2084":
+		return "PQ"
+	case "arib-std-b67":
+		return "HLG"
+	}
+	return ""
+}
+
+// FrameRate returns the average frame rate, falling back to the container's base rate
+func (s *ProbeStream) FrameRate() float64 {
+	if fps := parseRational(s.AvgFrameRate); fps > 0 {
+		return fps
+	}
+	return parseRational(s.RFrameRate)
+}
+
+// VariableFrameRate reports whether the average rate deviates from the base rate,
+// as it does for screen recordings and phone footage with dropped frames. A base
+// rate at a whole multiple of the average, like the field rate of interlaced
+// video (60/1 for 30000/1001), or at 5/4 of it for soft-telecined film is a
+// constant rate, matched within 0.2% to allow for NTSC rates.
+func (s *ProbeStream) VariableFrameRate() bool {
+	base := parseRational(s.RFrameRate)
+	avg := parseRational(s.AvgFrameRate)
+	if base <= 0 || avg <= 0 || math.Abs(base-avg)/base <= 0.01 {
+		return false
+	}
+	ratio := base / avg
+	for _, cadence := range []float64{math.Round(ratio), 1.25} {
+		if cadence > 1 && math.Abs(ratio-cadence)/cadence <= 0.002 {
+			return false
+		}
+	}
+	return true
+}
+
+// parseRational parses ffprobe's "num/den" notation, returning 0 when undefined
+func parseRational(value string) float64 {
+	parts := strings.Split(value, "/")
+	if len(parts) != 2 {
+		return 0
+	}
+	num, errNum := strconv.ParseFloat(parts[0], 64)
+	den, errDen := strconv.ParseFloat(parts[1], 64)
+	if errNum != nil || errDen != nil || den == 0 {
+		return 0
+	}
+	return num / den
+}
@@ -0,0 +1,32 @@
+package ffmpeg
+
+import "testing"
+
+func TestVariableFrameRate(t *testing.T) {
+	tests := []struct {
+		name  string
+		rRate string
+		avg   string
+		want  bool
+	}{
+		{name: "constant", rRate: "30/1", avg: "30/1"},
+		{name: "ntsc", rRate: "30000/1001", avg: "30000/1001"},
+		{name: "rounded base rate", rRate: "30/1", avg: "30000/1001"},
+		{name: "interlaced field rate", rRate: "60/1", avg: "30000/1001"},
+		{name: "interlaced pal", rRate: "50/1", avg: "25/1"},
+		{name: "soft telecine", rRate: "30000/1001", avg: "24000/1001"},
+		{name: "timebase multiple", rRate: "120/1", avg: "24/1"},
+		{name: "unknown average", rRate: "30/1", avg: "0/0"},
+		{name: "dropped frames", rRate: "30/1", avg: "2850/100", want: true},
+		{name: "screen recording", rRate: "60/1", avg: "1873/100", want: true},
+		{name: "near a multiple", rRate: "60/1", avg: "2950/100", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := ProbeStream{RFrameRate: tt.rRate, AvgFrameRate: tt.avg}
+			if got := stream.VariableFrameRate(); got != tt.want {
+				t.Fatalf("VariableFrameRate(%s, %s) = %v, want %v", tt.rRate, tt.avg, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package ffmpeg
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Rendition is one video variant of the HLS output
+type Rendition struct {
+	Name      string  `json:"name"`       // Output subdirectory
+	Codec     string  `json:"codec"`      // h264 or hevc
+	HDR       bool    `json:"hdr"`        // Keep the source HDR transfer instead of tone mapping to SDR
+	Height    int     `json:"height"`     // Short side in pixels, 0 keeps the source size
+	FrameRate float64 `json:"frame_rate"` // Constant output frame rate, 0 when unknown
+}
+
+// planRenditions decides which variants to encode for the probed source.
+// Every ladder rung the source can fill gets an SDR rendition; HDR
+// sources optionally also keep an HDR HEVC rendition of the top rung.
+func (e *Encoder) planRenditions(probe *ProbeResult) []Rendition {
+	video := probe.VideoStream()
+	if video == nil {
+		return []Rendition{{Name: "source", Codec: e.config.VideoCodec}}
+	}
+
+	width, height := displaySize(video)
+	shortSide := height
+	if width < height {
+		shortSide = width
+	}
+
+	var renditions []Rendition
+	for _, rung := range e.ladderRungs(shortSide) {
+		name := "source"
+		rungSide := shortSide
+		if rung > 0 {
+			name = fmt.Sprintf("%dp", rung)
+			rungSide = rung
+		}
+		renditions = append(renditions, Rendition{
+			Name:      name,
+			Codec:     e.config.VideoCodec,
+			Height:    rung,
+			FrameRate: e.targetFrameRate(video, rungSide),
+		})
+	}
+
+	if video.HDRTransfer() != "" && e.config.HDRRendition {
+		top := renditions[0]
+		top.Name += "_hdr"
+		top.Codec = "hevc"
+		top.HDR = true
+		renditions = append(renditions, top)
+	}
+
+	return renditions
+}
+
+// ladderRungs returns the configured rung sizes the source can fill, largest first.
+// Upscaling is never done; without a configured ladder the source size is kept.
+func (e *Encoder) ladderRungs(shortSide int) []int {
+	var rungs []int
+	for _, rung := range e.config.Ladder {
+		if rung > 0 && rung <= shortSide {
+			rungs = append(rungs, rung)
+		}
+	}
+	if len(rungs) == 0 {
+		return []int{0}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(rungs)))
+	return rungs
+}
+
+// encodeRendition runs ffmpeg for a single rendition and returns its master playlist entry.
+// When the source was split into chunks, video is encoded per chunk in parallel first.
+func (e *Encoder) encodeRendition(ctx context.Context, inputPath, outputDir string, rendition Rendition, probe *ProbeResult, chunks []sourceChunk) (variantStream, error) {
+	renditionDir := filepath.Join(outputDir, rendition.Name)
+	if err := os.MkdirAll(renditionDir, 0755); err != nil {
+		return variantStream{}, fmt.Errorf("failed to create rendition directory: %w", err)
+	}
+
+	hlsPath := filepath.Join(renditionDir, "playlist.m3u8")
+	video := probe.VideoStream()
+	filters := e.buildVideoFilters(video, rendition)
+
+	var args []string
+	if len(chunks) > 0 {
+		// Video was encoded chunk by chunk; stitch it back together and take audio
+		// from the source so it stays continuous across chunk boundaries
+		concatList, err := e.encodeChunks(ctx, chunks, rendition, video)
+		if err != nil {
+			return variantStream{}, err
+		}
+		args = []string{
+			"-f", "concat",
+			"-safe", "0",
+			"-i", concatList,
+			"-i", inputPath,
+			"-map", "0:v:0",
+			"-map", "1:a:0?",
+			"-c:v", "copy",
+		}
+	} else {
+		// Build FFmpeg command for HLS encoding. Rotation is applied by our own
+		// filter chain, so ffmpeg's automatic rotation must be disabled.
+		args = []string{
+			"-noautorotate",
+			"-i", inputPath,
+			"-sn", // Subtitles are extracted to WebVTT separately
+		}
+		if vf := filters.String(); vf != "" {
+			args = append(args, "-vf", vf)
+		}
+		args = append(args, e.videoCodecArgs(rendition, video, filters, e.segmentKeyFrames())...)
+	}
+	args = append(args,
+		"-c:a", "aac",
+		"-b:a", e.config.AudioBitrate,
+		"-hls_time", strconv.Itoa(e.config.HLSTime),
+		"-hls_playlist_type", "vod",
+		"-hls_flags", "independent_segments",
+	)
+
+	// HEVC in HLS must be carried in fragmented MP4 rather than MPEG-TS
+	segmentGlob := "segment_*.ts"
+	if rendition.Codec == "hevc" {
+		segmentGlob = "segment_*.m4s"
+		args = append(args,
+			"-hls_segment_type", "fmp4",
+			"-hls_fmp4_init_filename", "init.mp4",
+		)
+	}
+	args = append(args,
+		"-hls_segment_filename", filepath.Join(renditionDir, strings.Replace(segmentGlob, "*", "%03d", 1)),
+		"-f", "hls",
+		hlsPath,
+	)
+
+	e.logger.WithFields(logrus.Fields{
+		"rendition": rendition.Name,
+		"filters":   filters.String(),
+	}).Debug("Encoding rendition")
+
+	if err := e.runFFmpeg(ctx, probe.Duration(), args); err != nil {
+		return variantStream{}, fmt.Errorf("ffmpeg encoding failed for %s rendition: %w", rendition.Name, err)
+	}
+
+	variant := variantStream{
+		URI:        filepath.ToSlash(filepath.Join(rendition.Name, "playlist.m3u8")),
+		Bandwidth:  measureBandwidth(renditionDir, segmentGlob, probe.Duration()),
+		Codecs:     codecsAttribute(rendition.Codec, levelFor(rendition.Codec, filters.width, filters.height, rendition.FrameRate), probe.HasAudio()),
+		FMP4:       rendition.Codec == "hevc",
+		VideoRange: "SDR",
+		FrameRate:  rendition.FrameRate,
+	}
+	if video != nil {
+		variant.Width = filters.width
+		variant.Height = filters.height
+		if rendition.HDR {
+			variant.VideoRange = video.HDRTransfer()
+		}
+	}
+
+	return variant, nil
+}
+
+// videoCodecArgs returns encoder, keyframe and color tagging arguments for a rendition.
+// Keyframes are forced on every segment boundary and the GOP is pinned to the
+// segment length so all renditions cut at the same timestamps. Profile and
+// level are pinned to what the master playlist's CODECS attribute announces.
+func (e *Encoder) videoCodecArgs(rendition Rendition, video *ProbeStream, filters *videoFilterGraph, forceKeyFrames string) []string {
+	gop := int(math.Round(rendition.FrameRate * float64(e.config.HLSTime)))
+	level := levelFor(rendition.Codec, filters.width, filters.height, rendition.FrameRate)
+
+	if rendition.Codec == "hevc" {
+		x265Params := []string{"scenecut=0", "level-idc=" + level.name}
+		if gop > 0 {
+			x265Params = append(x265Params, fmt.Sprintf("keyint=%d:min-keyint=%d", gop, gop))
+		}
+
+		args := []string{
+			"-c:v", "libx265",
+			"-preset", e.config.Preset,
+			"-crf", strconv.Itoa(e.config.CRF),
+			"-tag:v", "hvc1", // Required by Apple players
+			"-pix_fmt", "yuv420p10le",
+			"-force_key_frames", forceKeyFrames,
+		}
+		if e.threads > 0 {
+			x265Params = append(x265Params, fmt.Sprintf("pools=%d", e.threads)) // libx265 ignores -threads
+		}
+		if rendition.HDR && video != nil {
+			transfer := video.ColorTransfer
+			x265Params = append(x265Params, "colorprim=bt2020:colormatrix=bt2020nc:transfer="+transfer)
+			if transfer == "smpte2084" {
+				x265Params = append(x265Params, "hdr10=1:hdr10-opt=1")
+			}
+			args = append(args,
+				"-color_primaries", "bt2020",
+				"-color_trc", transfer,
+				"-colorspace", "bt2020nc",
+			)
+		}
+		return append(args, "-x265-params", strings.Join(x265Params, ":"))
+	}
+
+	args := []string{
+		"-c:v", "libx264",
+		"-preset", e.config.Preset,
+		"-crf", strconv.Itoa(e.config.CRF),
+		"-pix_fmt", "yuv420p",
+		"-profile:v", "high",
+		"-level:v", level.name,
+		"-force_key_frames", forceKeyFrames,
+		"-sc_threshold", "0",
+	}
+	if e.threads > 0 {
+		args = append(args, "-threads", strconv.Itoa(e.threads))
+	}
+	if gop > 0 {
+		args = append(args, "-g", strconv.Itoa(gop), "-keyint_min", strconv.Itoa(gop))
+	}
+	if video != nil && video.HDRTransfer() != "" {
+		// Tone-mapped output must be tagged as BT.709 or players assume the source colors
+		args = append(args,
+			"-color_primaries", "bt709",
+			"-color_trc", "bt709",
+			"-colorspace", "bt709",
+		)
+	}
+	return args
+}
+
+// segmentKeyFrames is the -force_key_frames expression placing a keyframe on every segment boundary
+func (e *Encoder) segmentKeyFrames() string {
+	return fmt.Sprintf("expr:gte(t,n_forced*%d)", e.config.HLSTime)
+}
@@ -0,0 +1,153 @@
+package ffmpeg
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/disk"
+)
+
+// Tracker receives the state of a running job so it can be inspected while it runs
+type Tracker interface {
+	SetStage(stage string)
+	SetProgress(fraction float64) // 0 to 1 within the current stage
+	SetPID(pid int)               // 0 once the process exits
+}
+
+type noopTracker struct{}
+
+func (noopTracker) SetStage(string)     {}
+func (noopTracker) SetProgress(float64) {}
+func (noopTracker) SetPID(int)          {}
+
+// ExitError is a failed ffmpeg run. It keeps the last lines ffmpeg wrote to
+// stderr, which usually explain the failure.
+type ExitError struct {
+	Err    error
+	Stderr []string
+}
+
+func (e *ExitError) Error() string {
+	if len(e.Stderr) == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%v: %s", e.Err, e.Stderr[len(e.Stderr)-1])
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches disk.ErrDiskFull when ffmpeg ran out of space writing its output
+func (e *ExitError) Is(target error) bool {
+	if target != disk.ErrDiskFull {
+		return false
+	}
+	for _, line := range e.Stderr {
+		if strings.Contains(line, "No space left on device") {
+			return true
+		}
+	}
+	return false
+}
+
+// StderrTail returns the ffmpeg stderr lines kept by an ExitError in err's chain
+func StderrTail(err error) []string {
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Stderr
+	}
+	return nil
+}
+
+// runFFmpeg runs ffmpeg until it exits or ctx is cancelled, which kills it.
+// The process ID is reported to the job tracker, as is the fraction of the
+// input processed when duration is known. stderr is logged line by line at
+// debug level, and its tail is kept in the returned *ExitError.
+func (e *Encoder) runFFmpeg(ctx context.Context, duration float64, args []string) error {
+	args = append([]string{"-nostats", "-progress", "pipe:1"}, args...)
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to attach to ffmpeg output: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return fmt.Errorf("failed to attach to ffmpeg output: %w", err)
+	}
+
+	e.logger.WithField("command", strings.Join(cmd.Args, " ")).Debug("Executing FFmpeg")
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	e.tracker.SetPID(cmd.Process.Pid)
+	defer e.tracker.SetPID(0)
+
+	tail := make(chan []string, 1)
+	go func() {
+		tail <- e.logStderr(stderr)
+	}()
+	e.readProgress(stdout, duration)
+	stderrTail := <-tail
+
+	if err := cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return &ExitError{Err: err, Stderr: stderrTail}
+	}
+	return nil
+}
+
+// logStderr logs ffmpeg's stderr at debug level until it closes and returns
+// the last LogTailLines lines
+func (e *Encoder) logStderr(r io.Reader) []string {
+	limit := max(e.config.LogTailLines, 1)
+	tail := make([]string, 0, limit)
+	logger := e.logger.WithField("source", "ffmpeg")
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		logger.Debug(line)
+
+		if len(tail) == limit {
+			tail = append(tail[:0], tail[1:]...)
+		}
+		tail = append(tail, line)
+	}
+	io.Copy(io.Discard, r)
+	return tail
+}
+
+// readProgress consumes ffmpeg's -progress key=value stream until it closes
+func (e *Encoder) readProgress(r io.Reader, duration float64) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		// out_time_ms is in microseconds as well, kept for older ffmpeg builds
+		if !ok || duration <= 0 || (key != "out_time_us" && key != "out_time_ms") {
+			continue
+		}
+		micros, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || micros < 0 {
+			continue
+		}
+		e.tracker.SetProgress(min(float64(micros)/1e6/duration, 1))
+	}
+	if err := scanner.Err(); err != nil {
+		e.logger.WithError(err).Debug("Failed to read ffmpeg progress")
+	}
+	io.Copy(io.Discard, r)
+}
@@ -0,0 +1,52 @@
+package ffmpeg
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Tungwong-Project/tungwong-video-worker/configs"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestLogStderr(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	logger.SetLevel(logrus.DebugLevel)
+	encoder := NewEncoder(&configs.FFmpegConfig{LogTailLines: 2}, &configs.PathsConfig{}, logger)
+
+	stderr := "Input #0, mov,mp4\n\n  Stream #0:0: Video: h264\nError while decoding stream\nConversion failed!\n"
+	tail := encoder.logStderr(strings.NewReader(stderr))
+
+	if want := []string{"Error while decoding stream", "Conversion failed!"}; strings.Join(tail, "|") != strings.Join(want, "|") {
+		t.Fatalf("tail = %q, want %q", tail, want)
+	}
+	entries := hook.AllEntries()
+	if len(entries) != 4 {
+		t.Fatalf("logged %d lines, want every non-empty line", len(entries))
+	}
+	for _, entry := range entries {
+		if entry.Level != logrus.DebugLevel || entry.Data["source"] != "ffmpeg" {
+			t.Fatalf("entry %q at %s with %v, want debug with source=ffmpeg", entry.Message, entry.Level, entry.Data)
+		}
+	}
+}
+
+func TestExitError(t *testing.T) {
+	exitErr := &ExitError{Err: errors.New("exit status 1"), Stderr: []string{"Invalid data found", "Conversion failed!"}}
+	wrapped := fmt.Errorf("720p rendition failed: %w", exitErr)
+
+	if exitErr.Error() != "exit status 1: Conversion failed!" {
+		t.Fatalf("Error() = %q, want the last stderr line", exitErr.Error())
+	}
+	if tail := StderrTail(wrapped); len(tail) != 2 {
+		t.Fatalf("StderrTail = %q, want both lines through wrapping", tail)
+	}
+	if tail := StderrTail(errors.New("probe failed")); tail != nil {
+		t.Fatalf("StderrTail = %q for a non-ffmpeg error", tail)
+	}
+	if (&ExitError{Err: errors.New("signal: killed")}).Error() != "signal: killed" {
+		t.Fatal("Error() without stderr is not the process error")
+	}
+}
@@ -0,0 +1,90 @@
+package ffmpeg
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/Tungwong-Project/tungwong-video-worker/configs"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/disk"
+	"github.com/sirupsen/logrus"
+)
+
+// outputMargin covers container overhead and sources that compress worse than
+// the bits-per-pixel estimate
+const outputMargin = 1.25
+
+// Estimated sizes of the image outputs
+const (
+	thumbnailBytes  = 1 << 20
+	spriteTileBytes = 8 << 10
+)
+
+// SetSpace reserves the estimated output of every job on its volumes before it
+// encodes, so a job that cannot fit fails fast with disk.ErrDiskFull instead
+// of filling the disk halfway through
+func (e *Encoder) SetSpace(space *disk.Space) {
+	e.space = space
+}
+
+// reserveSpace reserves the estimated HLS, thumbnail and scratch output of a
+// job. The returned release must be called once the job's files are final.
+func (e *Encoder) reserveSpace(probe *ProbeResult, renditions []Rendition, profile configs.EncodingProfile, scratchBytes int64) (func(), error) {
+	if e.space == nil {
+		return func() {}, nil
+	}
+
+	duration := probe.Duration()
+	hls := videoBytes(probe, renditions)
+	hls += float64(len(renditions)) * float64(parseBitrate(e.config.AudioBitrate)) * duration / 8
+	hls *= outputMargin
+
+	var images float64
+	if profile.Thumbnail {
+		images += thumbnailBytes
+	}
+	if profile.Sprites {
+		interval := profile.SpriteInterval
+		if interval <= 0 {
+			interval = 10
+		}
+		images += math.Ceil(duration/float64(interval)) * spriteTileBytes
+	}
+
+	needs := map[string]uint64{
+		e.paths.OutputHLSPath:       uint64(hls),
+		e.paths.OutputThumbnailPath: uint64(images),
+		e.paths.TempPath:            uint64(max(scratchBytes, 0)),
+	}
+	release, err := e.space.Reserve(needs)
+	if err != nil {
+		return nil, err
+	}
+
+	e.logger.WithFields(logrus.Fields{
+		"hls_mb":     uint64(hls) >> 20,
+		"images_mb":  uint64(images) >> 20,
+		"scratch_mb": scratchBytes >> 20,
+	}).Debug("Reserved disk space")
+	return release, nil
+}
+
+// parseBitrate reads an ffmpeg bitrate like 128k or 5M in bits per second
+func parseBitrate(value string) int64 {
+	value = strings.TrimSpace(value)
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(value, "k"), strings.HasSuffix(value, "K"):
+		multiplier = 1e3
+	case strings.HasSuffix(value, "M"):
+		multiplier = 1e6
+	}
+	if multiplier != 1 {
+		value = value[:len(value)-1]
+	}
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0
+	}
+	return int64(rate * multiplier)
+}
@@ -0,0 +1,24 @@
+package ffmpeg
+
+import "testing"
+
+func TestParseBitrate(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+	}{
+		{"128k", 128000},
+		{"192K", 192000},
+		{"5M", 5000000},
+		{"2.5M", 2500000},
+		{" 96k ", 96000},
+		{"64000", 64000},
+		{"", 0},
+		{"fast", 0},
+	}
+	for _, tt := range tests {
+		if got := parseBitrate(tt.value); got != tt.want {
+			t.Errorf("parseBitrate(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
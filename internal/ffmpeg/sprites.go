@@ -0,0 +1,86 @@
+package ffmpeg
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	spriteTileWidth = 160
+	spriteColumns   = 10
+	spriteRows      = 10
+)
+
+// generateSprites renders scrubbing preview sprite sheets and the WebVTT file
+// that maps each time range to a tile (#xywh media fragments)
+func (e *Encoder) generateSprites(ctx context.Context, inputPath, videoID string, probe *ProbeResult, interval int) (string, error) {
+	video := probe.VideoStream()
+	duration := probe.Duration()
+	if video == nil || duration <= 0 {
+		return "", fmt.Errorf("sprites need a video stream with a known duration")
+	}
+	if interval <= 0 {
+		interval = 10
+	}
+
+	outputDir := filepath.Join(e.outputDir(e.paths.OutputThumbnailPath, videoID), "sprites")
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create sprites directory: %w", err)
+	}
+
+	// ffmpeg autorotates here, so tiles follow the upright display size
+	width, height := displaySize(video)
+	tileHeight := evenRound(float64(spriteTileWidth) * float64(height) / float64(width))
+
+	err := e.runFFmpeg(ctx, duration, []string{
+		"-y",
+		"-i", inputPath,
+		"-vf", fmt.Sprintf("fps=1/%d,scale=%d:%d,tile=%dx%d", interval, spriteTileWidth, tileHeight, spriteColumns, spriteRows),
+		"-q:v", "5",
+		filepath.Join(outputDir, "sprite_%03d.jpg"),
+	})
+	if err != nil {
+		return "", fmt.Errorf("sprite generation failed: %w", err)
+	}
+
+	var vtt strings.Builder
+	vtt.WriteString("WEBVTT\n\n")
+
+	tiles := int(math.Ceil(duration / float64(interval)))
+	perSheet := spriteColumns * spriteRows
+	for i := 0; i < tiles; i++ {
+		start := float64(i * interval)
+		end := math.Min(start+float64(interval), duration)
+		sheet := i/perSheet + 1 // The image2 muxer numbers files from 1
+		x := (i % spriteColumns) * spriteTileWidth
+		y := (i % perSheet / spriteColumns) * tileHeight
+
+		fmt.Fprintf(&vtt, "%s --> %s\nsprite_%03d.jpg#xywh=%d,%d,%d,%d\n\n",
+			formatVTTTimestamp(start), formatVTTTimestamp(end), sheet, x, y, spriteTileWidth, tileHeight)
+	}
+
+	vttPath := filepath.Join(outputDir, "sprites.vtt")
+	if err := os.WriteFile(vttPath, []byte(vtt.String()), 0644); err != nil {
+		return "", fmt.Errorf("failed to write sprites vtt: %w", err)
+	}
+
+	e.logger.WithFields(logrus.Fields{
+		"video_id":    videoID,
+		"sprites_vtt": vttPath,
+		"tiles":       tiles,
+	}).Info("Sprites generated")
+
+	return vttPath, nil
+}
+
+// formatVTTTimestamp renders seconds as hh:mm:ss.ttt
+func formatVTTTimestamp(seconds float64) string {
+	millis := int64(math.Round(seconds * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", millis/3600000, millis/60000%60, millis/1000%60, millis%1000)
+}
@@ -0,0 +1,264 @@
+package ffmpeg
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// webvttTimestampMap aligns WebVTT cues with the MPEG-TS timeline. FFmpeg's
+// mpegts muxer starts timestamps at 1.4s (126000 ticks of the 90kHz clock).
+const webvttTimestampMap = "X-TIMESTAMP-MAP=MPEGTS:126000,LOCAL:00:00:00.000"
+
+// textSubtitleCodecs are the subtitle codecs ffmpeg can convert to WebVTT.
+// Bitmap formats such as PGS and VobSub cannot be converted and are skipped.
+var textSubtitleCodecs = map[string]bool{
+	"subrip":   true,
+	"srt":      true,
+	"ass":      true,
+	"ssa":      true,
+	"mov_text": true,
+	"webvtt":   true,
+	"text":     true,
+}
+
+var unsafeNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// SubtitleInput is a sidecar subtitle file supplied alongside the upload
+type SubtitleInput struct {
+	Path     string
+	Language string
+	Label    string
+	Default  bool
+}
+
+// SubtitleTrack is a WebVTT rendition written next to the HLS output
+type SubtitleTrack struct {
+	Label        string
+	Language     string
+	Default      bool
+	PlaylistPath string // Relative to the video output directory
+}
+
+type webvttCue struct {
+	start float64
+	end   float64
+	text  string
+}
+
+// extractSubtitles converts embedded and sidecar subtitles to segmented WebVTT.
+// Failures are logged per track so a broken subtitle never fails the encode.
+func (e *Encoder) extractSubtitles(ctx context.Context, inputPath, outputDir string, probe *ProbeResult, sidecars []SubtitleInput) []SubtitleTrack {
+	var tracks []SubtitleTrack
+
+	for _, stream := range probe.SubtitleStreams() {
+		logger := e.logger.WithFields(logrus.Fields{
+			"stream_index": stream.Index,
+			"codec":        stream.CodecName,
+		})
+
+		if !textSubtitleCodecs[stream.CodecName] {
+			logger.Warn("Skipping subtitle stream that cannot be converted to WebVTT")
+			continue
+		}
+
+		language := stream.Tags["language"]
+		label := stream.Tags["title"]
+		if label == "" {
+			label = languageLabel(language, len(tracks))
+		}
+
+		track := SubtitleTrack{
+			Label:    label,
+			Language: language,
+			Default:  stream.Disposition["default"] == 1,
+		}
+		args := []string{"-map", fmt.Sprintf("0:%d", stream.Index)}
+		dirName := subtitleTrackName(len(tracks), language)
+		if err := e.writeSubtitleTrack(ctx, inputPath, args, outputDir, dirName, probe.Duration(), &track); err != nil {
+			logger.WithError(err).Warn("Failed to extract subtitle stream")
+			continue
+		}
+		tracks = append(tracks, track)
+	}
+
+	for _, sidecar := range sidecars {
+		logger := e.logger.WithField("subtitle_file", sidecar.Path)
+
+		label := sidecar.Label
+		if label == "" {
+			label = languageLabel(sidecar.Language, len(tracks))
+		}
+
+		track := SubtitleTrack{
+			Label:    label,
+			Language: sidecar.Language,
+			Default:  sidecar.Default,
+		}
+		args := []string{"-map", "0:s:0"}
+		dirName := subtitleTrackName(len(tracks), sidecar.Language)
+		if err := e.writeSubtitleTrack(ctx, sidecar.Path, args, outputDir, dirName, probe.Duration(), &track); err != nil {
+			logger.WithError(err).Warn("Failed to convert sidecar subtitle")
+			continue
+		}
+		tracks = append(tracks, track)
+	}
+
+	return tracks
+}
+
+// writeSubtitleTrack converts one subtitle source to WebVTT and segments it for HLS
+func (e *Encoder) writeSubtitleTrack(ctx context.Context, source string, mapArgs []string, outputDir, dirName string, duration float64, track *SubtitleTrack) error {
+	trackDir := filepath.Join(outputDir, "subtitles", dirName)
+	if err := os.MkdirAll(trackDir, 0755); err != nil {
+		return fmt.Errorf("failed to create subtitle directory: %w", err)
+	}
+
+	vttPath := filepath.Join(trackDir, "full.vtt")
+	args := append([]string{"-y", "-i", source}, mapArgs...)
+	args = append(args, "-c:s", "webvtt", vttPath)
+
+	if err := e.runFFmpeg(ctx, 0, args); err != nil {
+		return fmt.Errorf("webvtt conversion failed: %w", err)
+	}
+
+	cues, err := parseWebVTT(vttPath)
+	if err != nil {
+		return err
+	}
+
+	if err := e.segmentWebVTT(cues, trackDir, duration); err != nil {
+		return err
+	}
+
+	track.PlaylistPath = filepath.ToSlash(filepath.Join("subtitles", dirName, "playlist.m3u8"))
+	return nil
+}
+
+// segmentWebVTT splits cues into HLS_TIME-sized WebVTT segments and writes their playlist.
+// A cue spanning a boundary is repeated in every segment it overlaps, as HLS requires.
+func (e *Encoder) segmentWebVTT(cues []webvttCue, trackDir string, duration float64) error {
+	for _, cue := range cues {
+		duration = math.Max(duration, cue.end)
+	}
+
+	segmentTime := float64(e.config.HLSTime)
+	segmentCount := int(math.Ceil(duration / segmentTime))
+	if segmentCount == 0 {
+		segmentCount = 1
+	}
+
+	var playlist strings.Builder
+	playlist.WriteString("#EXTM3U\n")
+	playlist.WriteString("#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&playlist, "#EXT-X-TARGETDURATION:%d\n", e.config.HLSTime)
+	playlist.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	playlist.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+
+	for i := 0; i < segmentCount; i++ {
+		start := float64(i) * segmentTime
+		end := math.Min(start+segmentTime, duration)
+		if end <= start {
+			end = start + segmentTime
+		}
+
+		var segment strings.Builder
+		segment.WriteString("WEBVTT\n")
+		segment.WriteString(webvttTimestampMap + "\n\n")
+		for _, cue := range cues {
+			if cue.start < end && cue.end > start {
+				segment.WriteString(cue.text)
+				segment.WriteString("\n\n")
+			}
+		}
+
+		name := fmt.Sprintf("segment_%03d.vtt", i)
+		if err := os.WriteFile(filepath.Join(trackDir, name), []byte(segment.String()), 0644); err != nil {
+			return fmt.Errorf("failed to write subtitle segment: %w", err)
+		}
+
+		fmt.Fprintf(&playlist, "#EXTINF:%.3f,\n%s\n", end-start, name)
+	}
+
+	playlist.WriteString("#EXT-X-ENDLIST\n")
+
+	if err := os.WriteFile(filepath.Join(trackDir, "playlist.m3u8"), []byte(playlist.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write subtitle playlist: %w", err)
+	}
+	return nil
+}
+
+// parseWebVTT reads the cue blocks of a WebVTT file, dropping the header, NOTE and STYLE blocks
+func parseWebVTT(path string) ([]webvttCue, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webvtt file: %w", err)
+	}
+
+	content := strings.ReplaceAll(string(data), "\r\n", "\n")
+	var cues []webvttCue
+
+	for _, block := range strings.Split(content, "\n\n") {
+		block = strings.Trim(block, "\n")
+		lines := strings.Split(block, "\n")
+
+		for _, line := range lines {
+			if !strings.Contains(line, "-->") {
+				continue
+			}
+
+			fields := strings.Fields(line)
+			if len(fields) < 3 {
+				break
+			}
+			start, err := parseVTTTimestamp(fields[0])
+			if err != nil {
+				return nil, err
+			}
+			end, err := parseVTTTimestamp(fields[2])
+			if err != nil {
+				return nil, err
+			}
+
+			cues = append(cues, webvttCue{start: start, end: end, text: block})
+			break
+		}
+	}
+
+	return cues, nil
+}
+
+// parseVTTTimestamp parses "hh:mm:ss.ttt" or "mm:ss.ttt" into seconds
+func parseVTTTimestamp(value string) (float64, error) {
+	parts := strings.Split(value, ":")
+	var seconds float64
+	for _, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid webvtt timestamp %q", value)
+		}
+		seconds = seconds*60 + v
+	}
+	return seconds, nil
+}
+
+func subtitleTrackName(index int, language string) string {
+	if language == "" {
+		language = "und"
+	}
+	return fmt.Sprintf("%d_%s", index, unsafeNameChars.ReplaceAllString(language, ""))
+}
+
+func languageLabel(language string, index int) string {
+	if language == "" || language == "und" {
+		return fmt.Sprintf("Subtitle %d", index+1)
+	}
+	return language
+}
@@ -0,0 +1,170 @@
+package ffmpeg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Tungwong-Project/tungwong-video-worker/configs"
+	"github.com/sirupsen/logrus"
+)
+
+func TestParseWebVTT(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []webvttCue
+		wantErr string
+	}{
+		{
+			name:    "cues",
+			content: "WEBVTT\n\n00:00:01.000 --> 00:00:02.500\nHello\n\n1\n00:01:02.250 --> 01:00:03.000 align:start\nWorld\n",
+			want: []webvttCue{
+				{start: 1, end: 2.5, text: "00:00:01.000 --> 00:00:02.500\nHello"},
+				{start: 62.25, end: 3603, text: "1\n00:01:02.250 --> 01:00:03.000 align:start\nWorld"},
+			},
+		},
+		{
+			name:    "short timestamps and CRLF",
+			content: "WEBVTT\r\n\r\n00:05.000 --> 00:07.125\r\nHi\r\n",
+			want:    []webvttCue{{start: 5, end: 7.125, text: "00:05.000 --> 00:07.125\nHi"}},
+		},
+		{
+			name:    "header, note and style dropped",
+			content: "WEBVTT - Movie\n\nNOTE made by hand\n\nSTYLE\n::cue { color: red }\n\n00:00:00.000 --> 00:00:01.000\nOnly cue\n",
+			want:    []webvttCue{{start: 0, end: 1, text: "00:00:00.000 --> 00:00:01.000\nOnly cue"}},
+		},
+		{
+			name:    "empty",
+			content: "WEBVTT\n",
+		},
+		{
+			name:    "bad timestamp",
+			content: "WEBVTT\n\n00:00:xx.000 --> 00:00:01.000\nBroken\n",
+			wantErr: "invalid webvtt timestamp",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "full.vtt")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			cues, err := parseWebVTT(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseWebVTT: %v", err)
+			}
+			if len(cues) != len(tt.want) {
+				t.Fatalf("got %d cues %+v, want %d", len(cues), cues, len(tt.want))
+			}
+			for i := range cues {
+				if cues[i] != tt.want[i] {
+					t.Fatalf("cue %d = %+v, want %+v", i, cues[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSegmentWebVTT(t *testing.T) {
+	cue := func(start, end float64, text string) webvttCue {
+		return webvttCue{start: start, end: end, text: text}
+	}
+	tests := []struct {
+		name         string
+		cues         []webvttCue
+		duration     float64
+		wantSegments [][]string // Cue texts per segment
+		wantExtinf   []string
+	}{
+		{
+			name:         "cues within segments",
+			cues:         []webvttCue{cue(1, 2, "first"), cue(7, 8, "second")},
+			duration:     12,
+			wantSegments: [][]string{{"first"}, {"second"}},
+			wantExtinf:   []string{"6.000", "6.000"},
+		},
+		{
+			name:         "cue spanning a boundary is repeated",
+			cues:         []webvttCue{cue(5, 13, "long"), cue(13, 14, "after")},
+			duration:     15,
+			wantSegments: [][]string{{"long"}, {"long"}, {"long", "after"}},
+			wantExtinf:   []string{"6.000", "6.000", "3.000"},
+		},
+		{
+			name:         "cue ending on a boundary stays in its segment",
+			cues:         []webvttCue{cue(0, 6, "exact")},
+			duration:     12,
+			wantSegments: [][]string{{"exact"}, nil},
+			wantExtinf:   []string{"6.000", "6.000"},
+		},
+		{
+			name:         "cues past the duration extend it",
+			cues:         []webvttCue{cue(10, 14, "late")},
+			duration:     6,
+			wantSegments: [][]string{nil, {"late"}, {"late"}},
+			wantExtinf:   []string{"6.000", "6.000", "2.000"},
+		},
+		{
+			name:         "no cues",
+			wantSegments: [][]string{nil},
+			wantExtinf:   []string{"6.000"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			encoder := NewEncoder(&configs.FFmpegConfig{HLSTime: 6}, &configs.PathsConfig{}, logrus.New())
+			if err := encoder.segmentWebVTT(tt.cues, dir, tt.duration); err != nil {
+				t.Fatalf("segmentWebVTT: %v", err)
+			}
+
+			for i, want := range tt.wantSegments {
+				data, err := os.ReadFile(filepath.Join(dir, "segment_00"+string(rune('0'+i))+".vtt"))
+				if err != nil {
+					t.Fatalf("segment %d: %v", i, err)
+				}
+				header := "WEBVTT\n" + webvttTimestampMap + "\n\n"
+				if !strings.HasPrefix(string(data), header) {
+					t.Fatalf("segment %d starts with %q, want the X-TIMESTAMP-MAP header", i, data)
+				}
+				var got []string
+				for _, block := range strings.Split(strings.TrimPrefix(string(data), header), "\n\n") {
+					if block != "" {
+						got = append(got, block)
+					}
+				}
+				if strings.Join(got, "|") != strings.Join(want, "|") {
+					t.Fatalf("segment %d cues = %v, want %v", i, got, want)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(dir, "segment_00"+string(rune('0'+len(tt.wantSegments)))+".vtt")); err == nil {
+				t.Fatalf("more than %d segments written", len(tt.wantSegments))
+			}
+
+			playlist, err := os.ReadFile(filepath.Join(dir, "playlist.m3u8"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var extinf []string
+			for _, line := range strings.Split(string(playlist), "\n") {
+				if value, ok := strings.CutPrefix(line, "#EXTINF:"); ok {
+					extinf = append(extinf, strings.TrimSuffix(value, ","))
+				}
+			}
+			if strings.Join(extinf, " ") != strings.Join(tt.wantExtinf, " ") {
+				t.Fatalf("segment durations = %v, want %v", extinf, tt.wantExtinf)
+			}
+			if !strings.Contains(string(playlist), "#EXT-X-TARGETDURATION:6\n") || !strings.HasSuffix(string(playlist), "#EXT-X-ENDLIST\n") {
+				t.Fatalf("playlist:\n%s", playlist)
+			}
+		})
+	}
+}
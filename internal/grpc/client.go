@@ -3,12 +3,19 @@ package grpc
 import (
 	"context"
 	"fmt"
+	"path"
+	"sync/atomic"
 	"time"
 
 	videov1 "github.com/Tungwong-Project/tungwong-protos/gen/go/video"
+	"github.com/Tungwong-Project/tungwong-video-worker/configs"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/metrics"
+	"github.com/Tungwong-Project/tungwong-video-worker/pkg/logger"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
@@ -17,18 +24,51 @@ type VideoManagementClient struct {
 	conn     *grpc.ClientConn
 	workerID string
 	logger   *logrus.Logger
+
+	maxRetries atomic.Int64 // Retries video-management is told to allow, replaced on reload
 }
 
-func NewVideoManagementClient(address, workerID string, logger *logrus.Logger) (*VideoManagementClient, error) {
-	// Connect to gRPC server
-	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+// NewVideoManagementClient creates a client for video-management. The
+// connection is established lazily on the first call.
+func NewVideoManagementClient(config configs.GRPCConfig, workerID string, logger *logrus.Logger) (*VideoManagementClient, error) {
+	creds, err := transportCredentials(config.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithChainUnaryInterceptor(
+			deadlineInterceptor(time.Duration(config.CallTimeout)*time.Second),
+			tracingInterceptor,
+			metricsInterceptor,
+		),
+	}
+	if rpcCreds := perRPCCredentials(config); rpcCreds != nil {
+		if !config.TLS.Enabled {
+			logger.Warn("gRPC credentials are configured without TLS and will be sent in plaintext")
+		}
+		opts = append(opts, grpc.WithPerRPCCredentials(rpcCreds))
+	}
+	if config.KeepaliveTime > 0 {
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:    time.Duration(config.KeepaliveTime) * time.Second,
+			Timeout: time.Duration(config.KeepaliveTimeout) * time.Second,
+		}))
+	}
+
+	conn, err := grpc.NewClient(config.VideoManagementURL, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to video management service: %w", err)
+		return nil, fmt.Errorf("failed to create video management client: %w", err)
 	}
 
 	client := videov1.NewVideoManagementServiceClient(conn)
 
-	logger.WithField("address", address).Info("Connected to video management gRPC service")
+	logger.WithFields(logrus.Fields{
+		"address": config.VideoManagementURL,
+		"tls":     config.TLS.Enabled,
+		"mtls":    config.TLS.Enabled && config.TLS.CertFile != "",
+	}).Info("Created video management gRPC client")
 
 	return &VideoManagementClient{
 		client:   client,
@@ -38,9 +78,16 @@ func NewVideoManagementClient(address, workerID string, logger *logrus.Logger) (
 	}, nil
 }
 
+// SetMaxRetries changes how many retries a failure report allows before
+// video-management marks the video as permanently failed
+func (c *VideoManagementClient) SetMaxRetries(retries int) {
+	c.maxRetries.Store(int64(retries))
+}
+
 // MarkVideoProcessing notifies video-management that worker started processing
 func (c *VideoManagementClient) MarkVideoProcessing(ctx context.Context, videoID string) error {
-	c.logger.WithField("video_id", videoID).Info("Marking video as processing")
+	log := logger.FromContext(ctx, c.logger)
+	log.WithField("video_id", videoID).Info("Marking video as processing")
 
 	req := &videov1.MarkVideoProcessingRequest{
 		VideoId:   videoID,
@@ -57,21 +104,45 @@ func (c *VideoManagementClient) MarkVideoProcessing(ctx context.Context, videoID
 		return fmt.Errorf("failed to mark video as processing: %s", resp.Message)
 	}
 
-	c.logger.WithField("video_id", videoID).Info("Video marked as processing")
+	log.WithField("video_id", videoID).Info("Video marked as processing")
 	return nil
 }
 
 // UpdateVideoStatus updates video status after successful encoding
-func (c *VideoManagementClient) UpdateVideoStatus(ctx context.Context, videoID, hlsPath, thumbnailPath string, duration int) error {
-	c.logger.WithFields(logrus.Fields{
+func (c *VideoManagementClient) UpdateVideoStatus(ctx context.Context, videoID, hlsPath, thumbnailPath string, duration int, completion Completion) error {
+	return c.updateStatus(completion.outgoing(ctx), videoID, "done", hlsPath, thumbnailPath, duration)
+}
+
+// ReportVideoVersion reports a new output version of a video that is already
+// published, e.g. from a re-encode. The status is version_ready rather than
+// done, so video-management keeps serving the current version until it
+// chooses to switch. version_ready is not a status the video-management API
+// is known to accept, so a successful call does not mean the version is
+// published.
+func (c *VideoManagementClient) ReportVideoVersion(ctx context.Context, videoID, hlsPath, thumbnailPath string, duration int, completion Completion) error {
+	return c.updateStatus(completion.outgoing(ctx), videoID, "version_ready", hlsPath, thumbnailPath, duration)
+}
+
+// MarkVideoPreviewReady reports that a low-resolution preview is playable while
+// the full ladder is still encoding. preview_ready is not a status the
+// video-management API is known to accept, so the report is best-effort: it
+// may be rejected or ignored, and a later failure cannot withdraw it.
+func (c *VideoManagementClient) MarkVideoPreviewReady(ctx context.Context, videoID, hlsPath, thumbnailPath string, duration int) error {
+	return c.updateStatus(ctx, videoID, "preview_ready", hlsPath, thumbnailPath, duration)
+}
+
+func (c *VideoManagementClient) updateStatus(ctx context.Context, videoID, status, hlsPath, thumbnailPath string, duration int) error {
+	log := logger.FromContext(ctx, c.logger)
+	log.WithFields(logrus.Fields{
 		"video_id": videoID,
+		"status":   status,
 		"hls_path": hlsPath,
 		"duration": duration,
-	}).Info("Updating video status to done")
+	}).Info("Updating video status")
 
 	req := &videov1.UpdateVideoStatusRequest{
 		VideoId:       videoID,
-		Status:        "done",
+		Status:        status,
 		HlsPath:       hlsPath,
 		ThumbnailPath: thumbnailPath,
 		Duration:      int32(duration),
@@ -87,13 +158,17 @@ func (c *VideoManagementClient) UpdateVideoStatus(ctx context.Context, videoID,
 		return fmt.Errorf("failed to update video status: %s", resp.Message)
 	}
 
-	c.logger.WithField("video_id", videoID).Info("Video status updated to done")
+	log.WithFields(logrus.Fields{
+		"video_id": videoID,
+		"status":   status,
+	}).Info("Video status updated")
 	return nil
 }
 
 // HandleVideoFailure reports video processing failure
 func (c *VideoManagementClient) HandleVideoFailure(ctx context.Context, videoID, failureReason, errorCode string, retryCount int) (bool, error) {
-	c.logger.WithFields(logrus.Fields{
+	log := logger.FromContext(ctx, c.logger)
+	log.WithFields(logrus.Fields{
 		"video_id":       videoID,
 		"failure_reason": failureReason,
 		"retry_count":    retryCount,
@@ -103,7 +178,7 @@ func (c *VideoManagementClient) HandleVideoFailure(ctx context.Context, videoID,
 		VideoId:       videoID,
 		FailureReason: failureReason,
 		ErrorCode:     errorCode,
-		ShouldRetry:   retryCount < 3, // Max 3 retries
+		ShouldRetry:   int64(retryCount) < c.maxRetries.Load(),
 		RetryCount:    int32(retryCount),
 		FailedAt:      timestamppb.Now(),
 	}
@@ -117,7 +192,7 @@ func (c *VideoManagementClient) HandleVideoFailure(ctx context.Context, videoID,
 		return false, fmt.Errorf("failed to handle video failure: %s", resp.Message)
 	}
 
-	c.logger.WithFields(logrus.Fields{
+	log.WithFields(logrus.Fields{
 		"video_id":     videoID,
 		"should_retry": resp.ShouldRetry,
 	}).Info("Video failure handled")
@@ -125,6 +200,20 @@ func (c *VideoManagementClient) HandleVideoFailure(ctx context.Context, videoID,
 	return resp.ShouldRetry, nil
 }
 
+// Healthy reports whether the connection to video-management is usable. Idle and
+// connecting channels are fine; a failing or closed channel is not.
+func (c *VideoManagementClient) Healthy() (string, error) {
+	state := c.conn.GetState()
+	switch state {
+	case connectivity.TransientFailure, connectivity.Shutdown:
+		return state.String(), fmt.Errorf("grpc connection is %s", state)
+	case connectivity.Idle:
+		// Idle channels only reconnect on use; nudge it so the next check is accurate
+		c.conn.Connect()
+	}
+	return state.String(), nil
+}
+
 // Close closes the gRPC connection
 func (c *VideoManagementClient) Close() error {
 	c.logger.Info("Closing video management gRPC client")
@@ -133,6 +222,7 @@ func (c *VideoManagementClient) Close() error {
 
 // WithRetry wraps a gRPC call with retry logic
 func (c *VideoManagementClient) WithRetry(ctx context.Context, operation func() error, maxRetries int) error {
+	log := logger.FromContext(ctx, c.logger)
 	var err error
 	for i := 0; i < maxRetries; i++ {
 		err = operation()
@@ -142,12 +232,30 @@ func (c *VideoManagementClient) WithRetry(ctx context.Context, operation func()
 
 		if i < maxRetries-1 {
 			waitTime := time.Duration(i+1) * 5 * time.Second
-			c.logger.WithFields(logrus.Fields{
+			log.WithFields(logrus.Fields{
 				"attempt":   i + 1,
 				"wait_time": waitTime,
 			}).Warn("Retrying gRPC call")
-			time.Sleep(waitTime)
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("operation cancelled after %d attempts: %w", i+1, err)
+			case <-time.After(waitTime):
+			}
 		}
 	}
 	return fmt.Errorf("operation failed after %d retries: %w", maxRetries, err)
 }
+
+// metricsInterceptor records latency and errors of every unary call by method
+func metricsInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	name := path.Base(method)
+	start := time.Now()
+
+	err := invoker(ctx, method, req, reply, cc, opts...)
+
+	metrics.GRPCDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.GRPCErrors.WithLabelValues(name, status.Code(err).String()).Inc()
+	}
+	return err
+}
@@ -0,0 +1,49 @@
+package grpc
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// Request metadata describing a completed job
+const (
+	sourceActionKey = "x-source-action"
+	sourcePathKey   = "x-source-path"
+	versionKey      = "x-output-version"
+	profileKey      = "x-profile"
+)
+
+// Completion describes a finished job beyond what UpdateVideoStatusRequest
+// carries. It travels as request metadata until the proto has fields for it;
+// paths are percent-encoded since metadata values must be ASCII. The keys are
+// not part of the video-management API, so they are best-effort: the server
+// may ignore them, and nothing in the worker relies on them being read.
+type Completion struct {
+	SourceAction string // What is done with the uploaded original
+	SourcePath   string // Where the original or its mezzanine will be; empty when it is deleted
+	Version      int    // Output version the job wrote, 0 when unversioned
+	Profile      string // Encoding profile of the output
+}
+
+func (c Completion) outgoing(ctx context.Context) context.Context {
+	var pairs []string
+	if c.SourceAction != "" {
+		pairs = append(pairs, sourceActionKey, c.SourceAction)
+	}
+	if c.SourcePath != "" {
+		pairs = append(pairs, sourcePathKey, (&url.URL{Path: c.SourcePath}).EscapedPath())
+	}
+	if c.Version > 0 {
+		pairs = append(pairs, versionKey, strconv.Itoa(c.Version))
+	}
+	if c.Profile != "" {
+		pairs = append(pairs, profileKey, c.Profile)
+	}
+	if len(pairs) == 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, pairs...)
+}
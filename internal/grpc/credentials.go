@@ -0,0 +1,71 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Tungwong-Project/tungwong-video-worker/configs"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// transportCredentials returns TLS credentials built from config, or plaintext
+// credentials when TLS is disabled
+func transportCredentials(config configs.TLSConfig) (credentials.TransportCredentials, error) {
+	if !config.Enabled {
+		return insecure.NewCredentials(), nil
+	}
+
+	tlsConfig, err := config.ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up gRPC TLS: %w", err)
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// tokenCredentials attaches a bearer token and/or API key to every call
+type tokenCredentials struct {
+	metadata map[string]string
+	secure   bool
+}
+
+// perRPCCredentials returns the auth metadata configured for every call, or
+// nil when neither a token nor an API key is set
+func perRPCCredentials(config configs.GRPCConfig) credentials.PerRPCCredentials {
+	md := make(map[string]string)
+	if config.AuthToken != "" {
+		md["authorization"] = "Bearer " + config.AuthToken
+	}
+	if config.APIKey != "" {
+		md[config.APIKeyHeader] = config.APIKey
+	}
+	if len(md) == 0 {
+		return nil
+	}
+	return tokenCredentials{metadata: md, secure: config.TLS.Enabled}
+}
+
+func (t tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return t.metadata, nil
+}
+
+// RequireTransportSecurity refuses to send credentials in plaintext once TLS
+// is configured, so a misconfigured endpoint cannot downgrade the connection
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return t.secure
+}
+
+// deadlineInterceptor bounds calls made without a deadline, so a hung
+// video-management API cannot block a job forever
+func deadlineInterceptor(timeout time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); !ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
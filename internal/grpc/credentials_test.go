@@ -0,0 +1,122 @@
+package grpc
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Tungwong-Project/tungwong-video-worker/configs"
+	"google.golang.org/grpc"
+)
+
+func TestTransportCredentials(t *testing.T) {
+	notPEM := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		config       configs.TLSConfig
+		wantProtocol string
+		wantErr      string
+	}{
+		{name: "plaintext", wantProtocol: "insecure"},
+		{name: "tls", config: configs.TLSConfig{Enabled: true, ServerName: "api.internal"}, wantProtocol: "tls"},
+		{name: "bad CA", config: configs.TLSConfig{Enabled: true, CAFile: notPEM}, wantErr: "failed to set up gRPC TLS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			creds, err := transportCredentials(tt.config)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("transportCredentials: %v", err)
+			}
+			if got := creds.Info().SecurityProtocol; got != tt.wantProtocol {
+				t.Fatalf("security protocol = %s, want %s", got, tt.wantProtocol)
+			}
+		})
+	}
+}
+
+func TestPerRPCCredentials(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     configs.GRPCConfig
+		wantMD     map[string]string
+		wantSecure bool
+	}{
+		{name: "none"},
+		{
+			name:   "token",
+			config: configs.GRPCConfig{AuthToken: "t0ken"},
+			wantMD: map[string]string{"authorization": "Bearer t0ken"},
+		},
+		{
+			name:       "token and api key over tls",
+			config:     configs.GRPCConfig{AuthToken: "t0ken", APIKey: "k3y", APIKeyHeader: "x-api-key", TLS: configs.TLSConfig{Enabled: true}},
+			wantMD:     map[string]string{"authorization": "Bearer t0ken", "x-api-key": "k3y"},
+			wantSecure: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			creds := perRPCCredentials(tt.config)
+			if tt.wantMD == nil {
+				if creds != nil {
+					t.Fatalf("credentials = %v, want none", creds)
+				}
+				return
+			}
+			md, err := creds.GetRequestMetadata(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(md) != len(tt.wantMD) {
+				t.Fatalf("metadata = %v, want %v", md, tt.wantMD)
+			}
+			for key, want := range tt.wantMD {
+				if md[key] != want {
+					t.Fatalf("metadata %s = %q, want %q", key, md[key], want)
+				}
+			}
+			if creds.RequireTransportSecurity() != tt.wantSecure {
+				t.Fatalf("RequireTransportSecurity = %v, want %v", creds.RequireTransportSecurity(), tt.wantSecure)
+			}
+		})
+	}
+}
+
+func TestDeadlineInterceptor(t *testing.T) {
+	interceptor := deadlineInterceptor(time.Minute)
+	deadline := func(ctx context.Context) time.Duration {
+		var remaining time.Duration
+		invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			if d, ok := ctx.Deadline(); ok {
+				remaining = time.Until(d)
+			}
+			return nil
+		}
+		if err := interceptor(ctx, "/video.v1.VideoService/UpdateVideoStatus", nil, nil, nil, invoker); err != nil {
+			t.Fatal(err)
+		}
+		return remaining
+	}
+
+	if got := deadline(context.Background()); got <= 55*time.Second || got > time.Minute {
+		t.Fatalf("call without deadline got %v, want the one-minute default", got)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if got := deadline(ctx); got > 5*time.Second {
+		t.Fatalf("call with a 5s deadline got %v, want it kept", got)
+	}
+}
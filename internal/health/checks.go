@@ -0,0 +1,63 @@
+package health
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/disk"
+)
+
+// BinaryCheck verifies an executable is on PATH and reports its version.
+// The version is read once; later checks only confirm the binary still exists.
+func BinaryCheck(name string) Check {
+	var (
+		once    sync.Once
+		version string
+		err     error
+	)
+
+	return func() (string, error) {
+		once.Do(func() {
+			var output []byte
+			output, err = exec.Command(name, "-version").Output()
+			if err == nil {
+				version = strings.SplitN(strings.TrimSpace(string(output)), "\n", 2)[0]
+			}
+		})
+		if err != nil {
+			return "", fmt.Errorf("%s is not runnable: %w", name, err)
+		}
+
+		if _, lookErr := exec.LookPath(name); lookErr != nil {
+			return "", fmt.Errorf("%s not found: %w", name, lookErr)
+		}
+		return version, nil
+	}
+}
+
+// DirectoryCheck verifies dir is writable and its volume has at least minFreeBytes available
+func DirectoryCheck(dir string, minFreeBytes uint64) Check {
+	return func() (string, error) {
+		probe, err := os.CreateTemp(dir, ".readyz-*")
+		if err != nil {
+			return "", fmt.Errorf("directory is not writable: %w", err)
+		}
+		probe.Close()
+		os.Remove(probe.Name())
+
+		free, err := disk.Free(dir)
+		if err != nil {
+			// Writable but free space is unknown on this platform
+			return "free space unknown", nil
+		}
+
+		detail := fmt.Sprintf("%d MiB free", free>>20)
+		if free < minFreeBytes {
+			return detail, fmt.Errorf("only %d MiB free, need %d MiB", free>>20, minFreeBytes>>20)
+		}
+		return detail, nil
+	}
+}
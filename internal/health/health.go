@@ -0,0 +1,94 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Check reports a dependency's state; detail is shown in the readiness output
+type Check func() (detail string, err error)
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+type checkResult struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type report struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks,omitempty"`
+}
+
+// Checker backs the liveness and readiness endpoints
+type Checker struct {
+	mu       sync.RWMutex
+	checks   []namedCheck
+	draining atomic.Bool
+}
+
+func NewChecker() *Checker {
+	return &Checker{}
+}
+
+// Register adds a readiness check
+func (c *Checker) Register(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// SetDraining marks the worker as shutting down so readiness fails and no new
+// traffic or jobs are routed to it while in-flight work finishes
+func (c *Checker) SetDraining() {
+	c.draining.Store(true)
+}
+
+// LivenessHandler answers /healthz: the process is up and serving
+func (c *Checker) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, http.StatusOK, report{Status: "ok"})
+	})
+}
+
+// ReadinessHandler answers /readyz by running every registered check
+func (c *Checker) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.draining.Load() {
+			writeReport(w, http.StatusServiceUnavailable, report{Status: "draining"})
+			return
+		}
+
+		c.mu.RLock()
+		checks := c.checks
+		c.mu.RUnlock()
+
+		result := report{Status: "ready", Checks: make(map[string]checkResult, len(checks))}
+		status := http.StatusOK
+
+		for _, nc := range checks {
+			detail, err := nc.check()
+			res := checkResult{OK: err == nil, Detail: detail}
+			if err != nil {
+				res.Error = err.Error()
+				result.Status = "not_ready"
+				status = http.StatusServiceUnavailable
+			}
+			result.Checks[nc.name] = res
+		}
+
+		writeReport(w, status, result)
+	})
+}
+
+func writeReport(w http.ResponseWriter, status int, r report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(r)
+}
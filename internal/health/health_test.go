@@ -0,0 +1,122 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReadiness(t *testing.T) {
+	ok := func() (string, error) { return "connected", nil }
+	down := func() (string, error) { return "", errors.New("connection refused") }
+
+	tests := []struct {
+		name       string
+		checks     map[string]Check
+		draining   bool
+		wantCode   int
+		wantStatus string
+		wantErrors []string // Checks expected to fail
+	}{
+		{name: "no checks", wantCode: http.StatusOK, wantStatus: "ready"},
+		{
+			name:       "all passing",
+			checks:     map[string]Check{"nats": ok, "grpc": ok},
+			wantCode:   http.StatusOK,
+			wantStatus: "ready",
+		},
+		{
+			name:       "one failing",
+			checks:     map[string]Check{"nats": ok, "grpc": down},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: "not_ready",
+			wantErrors: []string{"grpc"},
+		},
+		{
+			name:       "draining",
+			checks:     map[string]Check{"nats": ok},
+			draining:   true,
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: "draining",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := NewChecker()
+			for name, check := range tt.checks {
+				checker.Register(name, check)
+			}
+			if tt.draining {
+				checker.SetDraining()
+			}
+
+			recorder := httptest.NewRecorder()
+			checker.ReadinessHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if recorder.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d", recorder.Code, tt.wantCode)
+			}
+			var got report
+			if err := json.NewDecoder(recorder.Body).Decode(&got); err != nil {
+				t.Fatalf("decode report: %v", err)
+			}
+			if got.Status != tt.wantStatus {
+				t.Fatalf("status = %s, want %s", got.Status, tt.wantStatus)
+			}
+			if tt.draining {
+				return
+			}
+			if len(got.Checks) != len(tt.checks) {
+				t.Fatalf("report has %d checks, want %d", len(got.Checks), len(tt.checks))
+			}
+			for name, result := range got.Checks {
+				failing := slices.Contains(tt.wantErrors, name)
+				if result.OK == failing || (failing && result.Error == "") {
+					t.Errorf("check %s = %+v, want failing %v", name, result, failing)
+				}
+			}
+		})
+	}
+}
+
+func TestLivenessWhileDraining(t *testing.T) {
+	checker := NewChecker()
+	checker.Register("nats", func() (string, error) { return "", errors.New("down") })
+	checker.SetDraining()
+
+	recorder := httptest.NewRecorder()
+	checker.LivenessHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("code = %d, want 200 whatever the dependencies", recorder.Code)
+	}
+}
+
+func TestDirectoryCheck(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := DirectoryCheck(dir, 0)(); err != nil {
+		t.Fatalf("writable directory: %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("probe file left behind: %v", entries)
+	}
+
+	if detail, err := DirectoryCheck(dir, math.MaxUint64)(); err == nil && detail != "free space unknown" {
+		t.Fatalf("expected a free space error, got detail %q", detail)
+	}
+
+	if _, err := DirectoryCheck(filepath.Join(dir, "missing"), 0)(); err == nil || !strings.Contains(err.Error(), "not writable") {
+		t.Fatalf("expected a writability error, got %v", err)
+	}
+}
+
+func TestBinaryCheck(t *testing.T) {
+	if _, err := BinaryCheck("video-worker-no-such-binary")(); err == nil || !strings.Contains(err.Error(), "not runnable") {
+		t.Fatalf("expected a missing binary error, got %v", err)
+	}
+}
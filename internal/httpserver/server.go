@@ -0,0 +1,52 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+// Server exposes the worker's operational HTTP endpoints
+type Server struct {
+	server *http.Server
+	mux    *http.ServeMux
+	logger *logrus.Logger
+}
+
+func NewServer(addr string, logger *logrus.Logger) *Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	return &Server{
+		server: &http.Server{
+			Addr:              addr,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+		mux:    mux,
+		logger: logger,
+	}
+}
+
+// Handle registers an additional endpoint
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Start serves in the background until Shutdown is called
+func (s *Server) Start() {
+	go func() {
+		s.logger.WithField("addr", s.server.Addr).Info("HTTP server listening")
+		if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.WithError(err).Error("HTTP server failed")
+		}
+	}()
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
@@ -0,0 +1,43 @@
+package httpserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	server := NewServer(":0", logrus.New())
+	server.Handle("/healthz", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	metrics.JobsFailed.WithLabelValues("DISK_FULL").Inc()
+
+	recorder := httptest.NewRecorder()
+	server.server.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET /metrics = %d, want 200", recorder.Code)
+	}
+	body, _ := io.ReadAll(recorder.Body)
+	for _, want := range []string{
+		"# TYPE video_worker_jobs_started_total counter",
+		`video_worker_jobs_failed_total{error_code="DISK_FULL"} 1`,
+		"# TYPE video_worker_encode_duration_seconds histogram",
+		"go_goroutines",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("/metrics is missing %q", want)
+		}
+	}
+
+	recorder = httptest.NewRecorder()
+	server.server.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if recorder.Code != http.StatusNoContent {
+		t.Fatalf("GET /healthz = %d, want the handler registered next to /metrics", recorder.Code)
+	}
+}
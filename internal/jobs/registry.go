@@ -0,0 +1,188 @@
+package jobs
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+type contextKey struct{}
+
+// Job is a video being processed by this worker. It implements ffmpeg.Tracker
+// so the encoder can report what it is doing.
+type Job struct {
+	ID        string // Stream sequence of the job's message
+	VideoID   string
+	Title     string
+	Attempt   int
+	StartedAt time.Time
+
+	mu        sync.Mutex
+	stage     string
+	progress  float64
+	pid       int
+	cancel    context.CancelFunc
+	cancelled bool
+	requeue   bool
+}
+
+// Snapshot is a point-in-time view of a job for the admin API
+type Snapshot struct {
+	ID         string    `json:"id"`
+	VideoID    string    `json:"video_id"`
+	Title      string    `json:"title"`
+	Attempt    int       `json:"attempt"`
+	StartedAt  time.Time `json:"started_at"`
+	Stage      string    `json:"stage"`
+	Progress   float64   `json:"progress"` // 0 to 1 within the current stage
+	FFmpegPID  int       `json:"ffmpeg_pid,omitempty"`
+	Cancelling bool      `json:"cancelling,omitempty"`
+}
+
+func (j *Job) SetStage(stage string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.stage = stage
+	j.progress = 0
+}
+
+func (j *Job) SetProgress(fraction float64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.progress = fraction
+}
+
+func (j *Job) SetPID(pid int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.pid = pid
+}
+
+// Cancelled reports whether the job was cancelled and, if so, whether its
+// message should be redelivered. A nil job was never cancelled.
+func (j *Job) Cancelled() (cancelled, requeue bool) {
+	if j == nil {
+		return false, false
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.cancelled, j.requeue
+}
+
+func (j *Job) snapshot() Snapshot {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return Snapshot{
+		ID:         j.ID,
+		VideoID:    j.VideoID,
+		Title:      j.Title,
+		Attempt:    j.Attempt,
+		StartedAt:  j.StartedAt,
+		Stage:      j.stage,
+		Progress:   j.progress,
+		FFmpegPID:  j.pid,
+		Cancelling: j.cancelled,
+	}
+}
+
+// Registry tracks the jobs running on this worker by message, so an upload
+// and a re-encode of the same video are separate jobs
+type Registry struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+func NewRegistry() *Registry {
+	return &Registry{jobs: make(map[string]*Job)}
+}
+
+// Start registers a job and returns a context that is cancelled when the job
+// is cancelled. The job can be retrieved from the context with FromContext.
+func (r *Registry) Start(parent context.Context, id, videoID, title string, attempt int) (context.Context, *Job) {
+	ctx, cancel := context.WithCancel(parent)
+	job := &Job{
+		ID:        id,
+		VideoID:   videoID,
+		Title:     title,
+		Attempt:   attempt,
+		StartedAt: time.Now(),
+		stage:     "queued",
+		cancel:    cancel,
+	}
+
+	r.mu.Lock()
+	r.jobs[id] = job
+	r.mu.Unlock()
+
+	return context.WithValue(ctx, contextKey{}, job), job
+}
+
+// Finish removes a job once its message has been acked, naked or termed
+func (r *Registry) Finish(job *Job) {
+	job.cancel()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.jobs[job.ID] == job {
+		delete(r.jobs, job.ID)
+	}
+}
+
+// List returns all running jobs, oldest first
+func (r *Registry) List() []Snapshot {
+	r.mu.Lock()
+	jobs := make([]*Job, 0, len(r.jobs))
+	for _, job := range r.jobs {
+		jobs = append(jobs, job)
+	}
+	r.mu.Unlock()
+
+	snapshots := make([]Snapshot, len(jobs))
+	for i, job := range jobs {
+		snapshots[i] = job.snapshot()
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].StartedAt.Before(snapshots[j].StartedAt)
+	})
+	return snapshots
+}
+
+// Get returns a running job by ID
+func (r *Registry) Get(id string) (Snapshot, bool) {
+	r.mu.Lock()
+	job, ok := r.jobs[id]
+	r.mu.Unlock()
+
+	if !ok {
+		return Snapshot{}, false
+	}
+	return job.snapshot(), true
+}
+
+// Cancel aborts a running job. With requeue the message is naked for
+// redelivery, otherwise it is terminated. Returns the job, or false if no
+// such job runs.
+func (r *Registry) Cancel(id string, requeue bool) (Snapshot, bool) {
+	r.mu.Lock()
+	job, ok := r.jobs[id]
+	r.mu.Unlock()
+
+	if !ok {
+		return Snapshot{}, false
+	}
+
+	job.mu.Lock()
+	job.cancelled = true
+	job.requeue = requeue
+	job.mu.Unlock()
+
+	job.cancel()
+	return job.snapshot(), true
+}
+
+// FromContext returns the job a context was started for, or nil
+func FromContext(ctx context.Context) *Job {
+	job, _ := ctx.Value(contextKey{}).(*Job)
+	return job
+}
@@ -0,0 +1,92 @@
+package jobs
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRegistryCancel(t *testing.T) {
+	tests := []struct {
+		name    string
+		requeue bool
+	}{
+		{name: "terminate"},
+		{name: "requeue", requeue: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := NewRegistry()
+			ctx, job := registry.Start(context.Background(), "42", "video-1", "Movie", 2)
+			if FromContext(ctx) != job {
+				t.Fatal("job is not in its context")
+			}
+			if cancelled, _ := job.Cancelled(); cancelled {
+				t.Fatal("new job is cancelled")
+			}
+
+			snapshot, ok := registry.Cancel("42", tt.requeue)
+			if !ok || snapshot.VideoID != "video-1" || !snapshot.Cancelling {
+				t.Fatalf("Cancel = %+v, %v", snapshot, ok)
+			}
+			select {
+			case <-ctx.Done():
+			default:
+				t.Fatal("job context not cancelled")
+			}
+			if cancelled, requeue := job.Cancelled(); !cancelled || requeue != tt.requeue {
+				t.Fatalf("Cancelled() = %v, %v, want true, %v", cancelled, requeue, tt.requeue)
+			}
+
+			if _, ok := registry.Cancel("43", tt.requeue); ok {
+				t.Fatal("cancelled a job that does not run")
+			}
+		})
+	}
+}
+
+func TestRegistryJobsByMessage(t *testing.T) {
+	registry := NewRegistry()
+	_, upload := registry.Start(context.Background(), "1", "video-1", "Movie", 1)
+	time.Sleep(time.Millisecond)
+	_, reencode := registry.Start(context.Background(), "2", "video-1", "Movie", 1)
+
+	reencode.SetStage("encode 720p (2/4)")
+	reencode.SetProgress(0.5)
+	reencode.SetPID(1234)
+
+	list := registry.List()
+	if len(list) != 2 || list[0].ID != "1" || list[1].ID != "2" {
+		t.Fatalf("List() = %+v, want both jobs of the video, oldest first", list)
+	}
+	got, ok := registry.Get("2")
+	if !ok || got.Stage != "encode 720p (2/4)" || got.Progress != 0.5 || got.FFmpegPID != 1234 {
+		t.Fatalf("Get(2) = %+v, %v", got, ok)
+	}
+	reencode.SetStage("thumbnail")
+	if got, _ := registry.Get("2"); got.Progress != 0 {
+		t.Fatalf("progress = %v after a new stage, want 0", got.Progress)
+	}
+
+	registry.Finish(upload)
+	if _, ok := registry.Get("1"); ok {
+		t.Fatal("finished job still listed")
+	}
+
+	// A redelivery registered under the same ID is not removed by the old job
+	_, redelivered := registry.Start(context.Background(), "2", "video-1", "Movie", 2)
+	registry.Finish(reencode)
+	if got, ok := registry.Get("2"); !ok || got.Attempt != 2 {
+		t.Fatalf("Get(2) = %+v, %v, want the redelivered job", got, ok)
+	}
+	registry.Finish(redelivered)
+	if list := registry.List(); len(list) != 0 {
+		t.Fatalf("List() = %+v, want none", list)
+	}
+}
+
+func TestNilJobNotCancelled(t *testing.T) {
+	if cancelled, requeue := FromContext(context.Background()).Cancelled(); cancelled || requeue {
+		t.Fatal("a context without a job reports a cancellation")
+	}
+}
@@ -0,0 +1,27 @@
+package jobs
+
+import "errors"
+
+// TerminalError is a job failure no redelivery can fix, such as a request
+// naming a profile that does not exist. Its message is rejected like an
+// invalid one instead of being retried.
+type TerminalError struct {
+	Code string // Error code reported for the failure, e.g. UNKNOWN_PROFILE
+	Err  error
+}
+
+func (e *TerminalError) Error() string { return e.Err.Error() }
+
+func (e *TerminalError) Unwrap() error { return e.Err }
+
+// Terminal marks err, failing with code, as not worth retrying
+func Terminal(code string, err error) error {
+	return &TerminalError{Code: code, Err: err}
+}
+
+// AsTerminal returns the terminal error in err's chain, if any
+func AsTerminal(err error) (*TerminalError, bool) {
+	var terminal *TerminalError
+	ok := errors.As(err, &terminal)
+	return terminal, ok
+}
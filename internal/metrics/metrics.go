@@ -0,0 +1,176 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "video_worker"
+
+var (
+	JobsStarted = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_started_total",
+		Help:      "Video processing jobs started.",
+	})
+
+	JobsSucceeded = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_succeeded_total",
+		Help:      "Video processing jobs completed successfully.",
+	})
+
+	JobsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_failed_total",
+		Help:      "Video processing jobs failed, by error code.",
+	}, []string{"error_code"})
+
+	JobsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "jobs_in_flight",
+		Help:      "Video processing jobs currently running.",
+	})
+
+	MaxConcurrentJobs = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "max_concurrent_jobs",
+		Help:      "Configured job concurrency limit.",
+	})
+
+	ThreadBudget = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "thread_budget",
+		Help:      "CPU threads jobs may use at once.",
+	})
+
+	ThreadsInUse = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "threads_in_use",
+		Help:      "CPU threads held by running jobs.",
+	})
+
+	ChunkThreadsInUse = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "chunk_threads_in_use",
+		Help:      "CPU threads held by split-encode chunks served for other workers.",
+	})
+
+	ScratchBytesReserved = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "scratch_bytes_reserved",
+		Help:      "Scratch disk space held by running jobs.",
+	})
+
+	SourceActions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "source_actions_total",
+		Help:      "Source files handled after encoding, by action and result.",
+	}, []string{"action", "result"})
+
+	DiskReservedBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "disk_reserved_bytes",
+		Help:      "Estimated output of running jobs reserved against free disk space.",
+	})
+
+	TempDirsCollected = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "temp_dirs_collected_total",
+		Help:      "Orphaned directories removed from the temp path.",
+	})
+
+	OutputVersionsRemoved = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "output_versions_removed_total",
+		Help:      "Old output versions removed by the retention policy.",
+	})
+
+	AdmissionWaitSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "admission_wait_seconds",
+		Help:      "Time jobs waited for CPU threads or scratch space before encoding.",
+		Buckets:   []float64{1, 5, 15, 60, 300, 900, 1800, 3600},
+	})
+
+	EncodeDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "encode_duration_seconds",
+		Help:      "Wall-clock time spent encoding a video.",
+		Buckets:   prometheus.ExponentialBuckets(10, 2, 11), // 10s to ~2.8h
+	})
+
+	EncodeSpeed = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "encode_speed_ratio",
+		Help:      "Media duration divided by encode time; above 1 is faster than realtime.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2, 4, 8, 16},
+	})
+
+	QueuePending = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_pending_messages",
+		Help:      "Messages waiting in the JetStream consumer.",
+	})
+
+	LanePending = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_lane_pending_messages",
+		Help:      "Messages waiting in each priority lane.",
+	}, []string{"lane"})
+
+	JobsRerouted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_rerouted_total",
+		Help:      "Jobs moved to another priority lane, by target lane.",
+	}, []string{"lane"})
+
+	QueueAckPending = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_ack_pending_messages",
+		Help:      "Messages delivered but not yet acknowledged.",
+	})
+
+	GRPCDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "grpc_client_duration_seconds",
+		Help:      "Latency of gRPC calls to video-management, by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	GRPCErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "grpc_client_errors_total",
+		Help:      "Failed gRPC calls to video-management, by method and status code.",
+	}, []string{"method", "code"})
+
+	OutputBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "output_bytes_total",
+		Help:      "Bytes written per output type.",
+	}, []string{"output"})
+
+	ConfigReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_reloads_total",
+		Help:      "Configuration reload attempts, by result (applied, unchanged, failed).",
+	}, []string{"result"})
+
+	MessagesRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_rejected_total",
+		Help:      "Job messages rejected instead of retried, by reason (malformed, unsupported_version, invalid, or a permanent job failure such as unknown_profile).",
+	}, []string{"reason"})
+
+	NATSDisconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "nats_disconnects_total",
+		Help:      "Times the NATS connection was lost.",
+	})
+
+	NATSReconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "nats_reconnects_total",
+		Help:      "Times the NATS connection was re-established.",
+	})
+)
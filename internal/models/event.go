@@ -0,0 +1,69 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Lifecycle event types, appended to the events subject prefix
+const (
+	EventStarted   = "started"
+	EventProgress  = "progress"
+	EventCompleted = "completed"
+	EventFailed    = "failed"
+)
+
+// EncodeEvent is published to NATS as a job moves through its lifecycle
+type EncodeEvent struct {
+	ID        string    `json:"id"`   // Unique per job attempt and event; also the JetStream dedup ID
+	Type      string    `json:"type"` // started, progress, completed or failed
+	VideoID   string    `json:"video_id"`
+	WorkerID  string    `json:"worker_id"`
+	Attempt   int       `json:"attempt"`
+	Profile   string    `json:"profile,omitempty"`
+	Version   int       `json:"version,omitempty"` // Output version the job writes
+	Timestamp time.Time `json:"timestamp"`
+
+	// Progress events
+	Stage    string  `json:"stage,omitempty"`
+	Progress float64 `json:"progress,omitempty"` // Fraction of the current stage, 0 to 1
+	Sequence int     `json:"sequence,omitempty"` // Orders progress events of one attempt
+
+	// Completed events
+	HLSPath       string      `json:"hls_path,omitempty"`
+	ThumbnailPath string      `json:"thumbnail_path,omitempty"`
+	Duration      int         `json:"duration,omitempty"`       // Video length in seconds
+	EncodeSeconds float64     `json:"encode_seconds,omitempty"` // Wall-clock time the job took
+	Renditions    []Rendition `json:"renditions,omitempty"`
+	SourceAction  string      `json:"source_action,omitempty"` // What was done with the original: keep, delete, archive or mezzanine
+	SourcePath    string      `json:"source_path,omitempty"`   // Where the original or its mezzanine is now
+
+	// Failed events
+	ErrorCode string `json:"error_code,omitempty"`
+	Error     string `json:"error,omitempty"`
+	WillRetry bool   `json:"will_retry,omitempty"`
+}
+
+// SetID derives the event ID from the job attempt and event type, plus the
+// output version when there is one and the sequence of a progress event. A
+// retried publish of the same event gets the same ID, any other event a new one.
+func (e *EncodeEvent) SetID() {
+	e.ID = fmt.Sprintf("%s-%d-%s", e.VideoID, e.Attempt, e.Type)
+	if e.Version > 0 {
+		e.ID = fmt.Sprintf("%s-v%d-%d-%s", e.VideoID, e.Version, e.Attempt, e.Type)
+	}
+	if e.Type == EventProgress {
+		e.ID = fmt.Sprintf("%s-%d", e.ID, e.Sequence)
+	}
+}
+
+// Rendition describes an encoded HLS variant
+type Rendition struct {
+	Name       string  `json:"name"`
+	Codec      string  `json:"codec"`
+	Width      int     `json:"width"`
+	Height     int     `json:"height"`
+	Bandwidth  int     `json:"bandwidth"` // Peak bits per second
+	FrameRate  float64 `json:"frame_rate,omitempty"`
+	VideoRange string  `json:"video_range"` // SDR, PQ or HLG
+}
@@ -0,0 +1,38 @@
+package models
+
+import "testing"
+
+func TestEncodeEventSetID(t *testing.T) {
+	tests := []struct {
+		name  string
+		event EncodeEvent
+		want  string
+	}{
+		{name: "started", event: EncodeEvent{Type: EventStarted, VideoID: "video-1", Attempt: 1}, want: "video-1-1-started"},
+		{name: "retry", event: EncodeEvent{Type: EventStarted, VideoID: "video-1", Attempt: 2}, want: "video-1-2-started"},
+		{name: "versioned", event: EncodeEvent{Type: EventCompleted, VideoID: "video-1", Attempt: 1, Version: 3}, want: "video-1-v3-1-completed"},
+		{name: "progress", event: EncodeEvent{Type: EventProgress, VideoID: "video-1", Attempt: 1, Sequence: 4}, want: "video-1-1-progress-4"},
+		{name: "versioned progress", event: EncodeEvent{Type: EventProgress, VideoID: "video-1", Attempt: 2, Version: 3, Sequence: 1}, want: "video-1-v3-2-progress-1"},
+	}
+	seen := map[string]string{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.event.SetID()
+			if tt.event.ID != tt.want {
+				t.Fatalf("ID = %q, want %q", tt.event.ID, tt.want)
+			}
+			if other, ok := seen[tt.event.ID]; ok {
+				t.Fatalf("ID %q shared with %s", tt.event.ID, other)
+			}
+			seen[tt.event.ID] = tt.name
+
+			// A retried publish of the same event must be dropped as a duplicate
+			again := tt.event
+			again.ID = ""
+			again.SetID()
+			if again.ID != tt.event.ID {
+				t.Fatalf("second ID = %q, want %q", again.ID, tt.event.ID)
+			}
+		})
+	}
+}
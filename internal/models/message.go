@@ -1,11 +1,109 @@
 package models
 
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// CurrentSchemaVersion is the newest message envelope version the worker
+// understands. Messages without a schema_version use the legacy flat format.
+const CurrentSchemaVersion = 1
+
+// Job priorities carried by the envelope
+const (
+	PriorityLow    = "low"
+	PriorityNormal = "normal"
+	PriorityHigh   = "high"
+)
+
+// MessageEnvelope wraps a video upload job with metadata about the message
+type MessageEnvelope struct {
+	SchemaVersion int                `json:"schema_version"`
+	MessageID     string             `json:"message_id"`
+	ProducedAt    time.Time          `json:"produced_at"`
+	Priority      string             `json:"priority,omitempty"` // low, normal or high; normal when empty
+	Payload       VideoUploadMessage `json:"payload"`
+}
+
 type VideoUploadMessage struct {
-	VideoID        string `json:"video_id"`
-	FileName       string `json:"file_name"`
-	UploadFilePath string `json:"upload_file_path"`
-	OriginalFormat string `json:"original_format"`
-	UploaderID     string `json:"uploader_id"`
-	Title          string `json:"title"`
-	Description    string `json:"description"`
+	VideoID        string         `json:"video_id"`
+	FileName       string         `json:"file_name"`
+	UploadFilePath string         `json:"upload_file_path"`
+	OriginalFormat string         `json:"original_format"`
+	UploaderID     string         `json:"uploader_id"`
+	Title          string         `json:"title"`
+	Description    string         `json:"description"`
+	Profile        string         `json:"profile,omitempty"` // Encoding profile, default when empty
+	Subtitles      []SubtitleFile `json:"subtitles,omitempty"`
+}
+
+// SubtitleFile is a sidecar subtitle uploaded together with the video
+type SubtitleFile struct {
+	FilePath string `json:"file_path"`
+	Language string `json:"language"`
+	Label    string `json:"label"`
+	Default  bool   `json:"default"`
+}
+
+// videoIDPattern keeps video IDs safe to use as a directory name
+var videoIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// Validate reports every reason the envelope cannot be processed
+func (e *MessageEnvelope) Validate() error {
+	var errs []error
+	if e.MessageID == "" {
+		errs = append(errs, errors.New("message_id: must not be empty"))
+	}
+	if e.ProducedAt.IsZero() {
+		errs = append(errs, errors.New("produced_at: must be set"))
+	}
+	switch e.Priority {
+	case "", PriorityLow, PriorityNormal, PriorityHigh:
+	default:
+		errs = append(errs, fmt.Errorf("priority: must be low, normal or high, got %q", e.Priority))
+	}
+	if err := e.Payload.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Validate reports every reason the job cannot be processed
+func (m *VideoUploadMessage) Validate() error {
+	var errs []error
+	switch {
+	case m.VideoID == "":
+		errs = append(errs, errors.New("video_id: must not be empty"))
+	case !videoIDPattern.MatchString(m.VideoID):
+		errs = append(errs, fmt.Errorf("video_id: %q may only contain letters, digits, '.', '_' and '-'", m.VideoID))
+	}
+	if err := validatePath("upload_file_path", m.UploadFilePath); err != nil {
+		errs = append(errs, err)
+	}
+	for i, sub := range m.Subtitles {
+		if err := validatePath(fmt.Sprintf("subtitles[%d].file_path", i), sub.FilePath); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validatePath rejects empty paths and paths that could escape the input
+// directory
+func validatePath(field, path string) error {
+	if path == "" {
+		return fmt.Errorf("%s: must not be empty", field)
+	}
+	if strings.ContainsRune(path, 0) {
+		return fmt.Errorf("%s: must not contain NUL bytes", field)
+	}
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return fmt.Errorf("%s: %q must not contain '..'", field, path)
+		}
+	}
+	return nil
 }
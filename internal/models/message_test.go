@@ -0,0 +1,109 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidatePath(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		wantErr string
+	}{
+		{name: "file", path: "movie.mp4"},
+		{name: "nested", path: "uploads/2026/movie.mp4"},
+		{name: "absolute", path: "/data/uploads/movie.mp4"},
+		{name: "dots in name", path: "my..movie.mp4"},
+		{name: "empty", path: "", wantErr: "must not be empty"},
+		{name: "parent", path: "../movie.mp4", wantErr: "must not contain '..'"},
+		{name: "parent inside", path: "uploads/../../etc/passwd", wantErr: "must not contain '..'"},
+		{name: "parent last", path: "uploads/..", wantErr: "must not contain '..'"},
+		{name: "backslash parent", path: `uploads\..\movie.mp4`, wantErr: "must not contain '..'"},
+		{name: "nul", path: "movie.mp4\x00.txt", wantErr: "must not contain NUL bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePath("upload_file_path", tt.path)
+			checkErr(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestVideoUploadMessageValidate(t *testing.T) {
+	valid := func() VideoUploadMessage {
+		return VideoUploadMessage{VideoID: "550e8400-e29b-41d4-a716-446655440000", UploadFilePath: "movie.mp4"}
+	}
+	tests := []struct {
+		name    string
+		modify  func(m *VideoUploadMessage)
+		wantErr string
+	}{
+		{name: "valid", modify: func(m *VideoUploadMessage) {}},
+		{name: "dotted id", modify: func(m *VideoUploadMessage) { m.VideoID = "video_1.v2-b" }},
+		{name: "empty id", modify: func(m *VideoUploadMessage) { m.VideoID = "" }, wantErr: "video_id: must not be empty"},
+		{name: "dot id", modify: func(m *VideoUploadMessage) { m.VideoID = "." }, wantErr: "video_id:"},
+		{name: "parent id", modify: func(m *VideoUploadMessage) { m.VideoID = ".." }, wantErr: "video_id:"},
+		{name: "slash in id", modify: func(m *VideoUploadMessage) { m.VideoID = "a/b" }, wantErr: "video_id:"},
+		{name: "long id", modify: func(m *VideoUploadMessage) { m.VideoID = strings.Repeat("a", 129) }, wantErr: "video_id:"},
+		{name: "empty path", modify: func(m *VideoUploadMessage) { m.UploadFilePath = "" }, wantErr: "upload_file_path: must not be empty"},
+		{
+			name: "subtitle parent",
+			modify: func(m *VideoUploadMessage) {
+				m.Subtitles = []SubtitleFile{{FilePath: "en.vtt"}, {FilePath: "../fr.vtt"}}
+			},
+			wantErr: "subtitles[1].file_path",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := valid()
+			tt.modify(&m)
+			checkErr(t, m.Validate(), tt.wantErr)
+		})
+	}
+}
+
+func TestMessageEnvelopeValidate(t *testing.T) {
+	valid := func() MessageEnvelope {
+		return MessageEnvelope{
+			SchemaVersion: CurrentSchemaVersion,
+			MessageID:     "msg-1",
+			ProducedAt:    time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC),
+			Payload:       VideoUploadMessage{VideoID: "video-1", UploadFilePath: "movie.mp4"},
+		}
+	}
+	tests := []struct {
+		name    string
+		modify  func(e *MessageEnvelope)
+		wantErr string
+	}{
+		{name: "valid", modify: func(e *MessageEnvelope) {}},
+		{name: "high priority", modify: func(e *MessageEnvelope) { e.Priority = PriorityHigh }},
+		{name: "no message id", modify: func(e *MessageEnvelope) { e.MessageID = "" }, wantErr: "message_id: must not be empty"},
+		{name: "no produced at", modify: func(e *MessageEnvelope) { e.ProducedAt = time.Time{} }, wantErr: "produced_at: must be set"},
+		{name: "unknown priority", modify: func(e *MessageEnvelope) { e.Priority = "urgent" }, wantErr: `got "urgent"`},
+		{name: "invalid payload", modify: func(e *MessageEnvelope) { e.Payload.VideoID = "../x" }, wantErr: "video_id:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := valid()
+			tt.modify(&e)
+			checkErr(t, e.Validate(), tt.wantErr)
+		})
+	}
+}
+
+// checkErr fails t unless err contains want, or is nil when want is empty
+func checkErr(t *testing.T, err error, want string) {
+	t.Helper()
+	switch {
+	case want == "" && err != nil:
+		t.Fatalf("unexpected error: %v", err)
+	case want != "" && err == nil:
+		t.Fatalf("expected error containing %q, got nil", want)
+	case want != "" && !strings.Contains(err.Error(), want):
+		t.Fatalf("expected error containing %q, got %v", want, err)
+	}
+}
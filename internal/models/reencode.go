@@ -0,0 +1,53 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ReencodeMessage asks for an existing video to be encoded again from its
+// archived original, e.g. after the ladder changed or a codec was added
+type ReencodeMessage struct {
+	VideoID   string         `json:"video_id"`
+	Profile   string         `json:"profile"`             // Encoding profile of the new version
+	FileName  string         `json:"file_name,omitempty"` // Original in the archive; the largest file there when empty
+	Reason    string         `json:"reason,omitempty"`    // Why the video is re-encoded, for logs and events
+	Subtitles []SubtitleFile `json:"subtitles,omitempty"` // Sidecars next to the archived original
+}
+
+// Validate reports every reason the re-encode cannot be processed
+func (m *ReencodeMessage) Validate() error {
+	var errs []error
+	switch {
+	case m.VideoID == "":
+		errs = append(errs, errors.New("video_id: must not be empty"))
+	case !videoIDPattern.MatchString(m.VideoID):
+		errs = append(errs, fmt.Errorf("video_id: %q may only contain letters, digits, '.', '_' and '-'", m.VideoID))
+	}
+	if m.Profile == "" {
+		errs = append(errs, errors.New("profile: must not be empty"))
+	}
+	if m.FileName != "" {
+		if err := validateFileName("file_name", m.FileName); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for i, sub := range m.Subtitles {
+		if err := validatePath(fmt.Sprintf("subtitles[%d].file_path", i), sub.FilePath); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateFileName accepts a bare file name only
+func validateFileName(field, name string) error {
+	if err := validatePath(field, name); err != nil {
+		return err
+	}
+	if strings.ContainsAny(name, `/\`) || name == "." {
+		return fmt.Errorf("%s: %q must be a file name, not a path", field, name)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/ffmpeg"
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+// ChunkRunner executes split-encode chunks on this worker
+type ChunkRunner interface {
+	// EncodeChunk runs a chunk once the chunk budget admits it
+	EncodeChunk(ctx context.Context, task ffmpeg.ChunkTask) error
+}
+
+type chunkReply struct {
+	Error string `json:"error,omitempty"`
+}
+
+// ServeChunks accepts chunk sub-jobs from other workers. Workers share a queue
+// group, so every chunk is encoded exactly once. While consumption is paused
+// no chunks are taken either.
+func (c *Consumer) ServeChunks(runner ChunkRunner) error {
+	c.chunkRunner = runner
+	c.chunkCtx, c.stopChunks = context.WithCancel(context.Background())
+
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+	if c.paused {
+		return nil // Subscribed on Resume
+	}
+	return c.subscribeChunks()
+}
+
+// subscribeChunks starts taking chunks. Callers hold pauseMu.
+func (c *Consumer) subscribeChunks() error {
+	sub, err := c.nc.QueueSubscribe(c.config.NATS.ChunkSubject, c.config.NATS.Consumer, c.serveChunk)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to chunk subject: %w", err)
+	}
+
+	c.chunkSub = sub
+	c.logger.WithField("subject", c.config.NATS.ChunkSubject).Info("Serving split-encode chunks")
+	return nil
+}
+
+// unsubscribeChunks stops taking chunks; chunks already taken keep running.
+// Callers hold pauseMu.
+func (c *Consumer) unsubscribeChunks() {
+	if c.chunkSub == nil {
+		return
+	}
+	if err := c.chunkSub.Drain(); err != nil {
+		c.logger.WithError(err).Error("Failed to drain chunk subscription")
+	}
+	c.chunkSub = nil
+}
+
+// serveChunk encodes a chunk in the background, so chunks run side by side as
+// far as the budget admits them. A chunk is given up after the chunk timeout,
+// when the requesting worker stops waiting, or on shutdown.
+func (c *Consumer) serveChunk(msg *nats.Msg) {
+	var task ffmpeg.ChunkTask
+	if err := json.Unmarshal(msg.Data, &task); err != nil {
+		c.logger.WithError(err).Error("Failed to unmarshal chunk task")
+		c.replyChunk(msg, err)
+		return
+	}
+
+	c.chunksRunning.Add(1)
+	go func() {
+		defer c.chunksRunning.Done()
+		ctx, cancel := context.WithTimeout(c.chunkCtx, time.Duration(c.config.FFmpeg.Split.ChunkTimeout)*time.Second)
+		defer cancel()
+
+		c.logger.WithFields(logrus.Fields{
+			"video_id":  task.VideoID,
+			"chunk":     task.Chunk,
+			"rendition": task.Rendition.Name,
+		}).Debug("Encoding chunk for another worker")
+		c.replyChunk(msg, c.chunkRunner.EncodeChunk(ctx, task))
+	}()
+}
+
+// DispatchChunk sends a chunk to whichever worker picks it up and waits for the
+// result. When no worker is listening the chunk is encoded locally, admitted
+// like a served chunk since the job holds no threads for it.
+func (c *Consumer) DispatchChunk(ctx context.Context, task ffmpeg.ChunkTask) error {
+	data, err := json.Marshal(task)
+	if err != nil {
+		return fmt.Errorf("failed to marshal chunk task: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(c.config.FFmpeg.Split.ChunkTimeout)*time.Second)
+	defer cancel()
+
+	reply, err := c.nc.RequestWithContext(ctx, c.config.NATS.ChunkSubject, data)
+	if errors.Is(err, nats.ErrNoResponders) && c.chunkRunner != nil {
+		c.logger.WithField("video_id", task.VideoID).Warn("No workers serving chunks, encoding locally")
+		return c.chunkRunner.EncodeChunk(ctx, task)
+	}
+	if err != nil {
+		return fmt.Errorf("chunk request failed: %w", err)
+	}
+
+	var result chunkReply
+	if err := json.Unmarshal(reply.Data, &result); err != nil {
+		return fmt.Errorf("failed to parse chunk reply: %w", err)
+	}
+	if result.Error != "" {
+		return errors.New(result.Error)
+	}
+	return nil
+}
+
+func (c *Consumer) replyChunk(msg *nats.Msg, err error) {
+	var reply chunkReply
+	if err != nil {
+		reply.Error = err.Error()
+	}
+
+	data, _ := json.Marshal(reply)
+	if err := msg.Respond(data); err != nil {
+		c.logger.WithError(err).Error("Failed to reply to chunk task")
+	}
+}
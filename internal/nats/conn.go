@@ -0,0 +1,108 @@
+package nats
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/Tungwong-Project/tungwong-video-worker/configs"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+// connState remembers why the connection last went down so health checks can
+// report it
+type connState struct {
+	mu      sync.Mutex
+	lastErr error
+}
+
+func (s *connState) set(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastErr = err
+}
+
+func (s *connState) get() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastErr
+}
+
+// connect opens the NATS connection with the configured authentication, TLS
+// and reconnect behaviour
+func connect(config configs.NATSConfig, name string, state *connState, logger *logrus.Logger) (*nats.Conn, error) {
+	opts, err := connectOptions(config, name, state, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	nc, err := nats.Connect(config.URL, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+
+	logger.WithField("url", nc.ConnectedUrlRedacted()).Info("Connected to NATS")
+	return nc, nil
+}
+
+// connectOptions turns config into connection options. Only one kind of
+// authentication is used: a creds file, an NKey seed, a token or a user and
+// password, in that order.
+func connectOptions(config configs.NATSConfig, name string, state *connState, logger *logrus.Logger) ([]nats.Option, error) {
+	opts := []nats.Option{
+		nats.Name(name),
+		nats.ReconnectWait(time.Duration(config.ReconnectWait) * time.Second),
+		nats.MaxReconnects(config.MaxReconnects),
+		nats.DisconnectErrHandler(func(nc *nats.Conn, err error) {
+			metrics.NATSDisconnects.Inc()
+			state.set(err)
+			logger.WithError(err).Warn("Disconnected from NATS, reconnecting")
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			metrics.NATSReconnects.Inc()
+			state.set(nil)
+			logger.WithField("url", nc.ConnectedUrlRedacted()).Info("Reconnected to NATS")
+		}),
+		nats.ClosedHandler(func(nc *nats.Conn) {
+			if err := nc.LastError(); err != nil {
+				state.set(err)
+				logger.WithError(err).Error("NATS connection closed")
+				return
+			}
+			logger.Info("NATS connection closed")
+		}),
+		nats.ErrorHandler(func(nc *nats.Conn, sub *nats.Subscription, err error) {
+			entry := logger.WithError(err)
+			if sub != nil {
+				entry = entry.WithField("subject", sub.Subject)
+			}
+			entry.Error("NATS asynchronous error")
+		}),
+	}
+
+	switch {
+	case config.CredsFile != "":
+		opts = append(opts, nats.UserCredentials(config.CredsFile))
+	case config.NKeyFile != "":
+		opt, err := nats.NkeyOptionFromSeed(config.NKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load NATS nkey seed: %w", err)
+		}
+		opts = append(opts, opt)
+	case config.Token != "":
+		opts = append(opts, nats.Token(config.Token))
+	case config.User != "":
+		opts = append(opts, nats.UserInfo(config.User, config.Password))
+	}
+
+	if config.TLS.Enabled {
+		tlsConfig, err := config.TLS.ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up NATS TLS: %w", err)
+		}
+		opts = append(opts, nats.Secure(tlsConfig))
+	}
+	return opts, nil
+}
@@ -0,0 +1,130 @@
+package nats
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/Tungwong-Project/tungwong-video-worker/configs"
+	"github.com/sirupsen/logrus"
+)
+
+func TestConnectOptions(t *testing.T) {
+	notPEM := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	base := configs.NATSConfig{URL: "nats://localhost:4222", ReconnectWait: 3, MaxReconnects: 10}
+
+	tests := []struct {
+		name       string
+		change     func(c *configs.NATSConfig)
+		check      func(o *nats.Options) bool
+		wantErr    string
+		wantOptErr string // Applying the options fails, naming the file that was chosen
+	}{
+		{
+			name:   "reconnect",
+			change: func(c *configs.NATSConfig) {},
+			check: func(o *nats.Options) bool {
+				return o.Name == "video-worker-1" && o.ReconnectWait == 3*time.Second && o.MaxReconnect == 10 && !o.Secure
+			},
+		},
+		{
+			name:   "user and password",
+			change: func(c *configs.NATSConfig) { c.User, c.Password = "worker", "s3cret" },
+			check:  func(o *nats.Options) bool { return o.User == "worker" && o.Password == "s3cret" && o.Token == "" },
+		},
+		{
+			name:   "token over user",
+			change: func(c *configs.NATSConfig) { c.Token, c.User = "t0ken", "worker" },
+			check:  func(o *nats.Options) bool { return o.Token == "t0ken" && o.User == "" },
+		},
+		{
+			name: "creds file over token",
+			change: func(c *configs.NATSConfig) {
+				c.CredsFile, c.Token = filepath.Join(t.TempDir(), "worker.creds"), "t0ken"
+			},
+			wantOptErr: "worker.creds",
+		},
+		{
+			name:    "missing nkey seed",
+			change:  func(c *configs.NATSConfig) { c.NKeyFile = filepath.Join(t.TempDir(), "missing.nk") },
+			wantErr: "failed to load NATS nkey seed",
+		},
+		{
+			name:   "tls",
+			change: func(c *configs.NATSConfig) { c.TLS = configs.TLSConfig{Enabled: true, ServerName: "nats.internal"} },
+			check: func(o *nats.Options) bool {
+				return o.Secure && o.TLSConfig != nil && o.TLSConfig.ServerName == "nats.internal"
+			},
+		},
+		{
+			name:    "tls with bad CA",
+			change:  func(c *configs.NATSConfig) { c.TLS = configs.TLSConfig{Enabled: true, CAFile: notPEM} },
+			wantErr: "failed to set up NATS TLS",
+		},
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := base
+			tt.change(&config)
+			opts, err := connectOptions(config, "video-worker-1", &connState{}, logger)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("connectOptions: %v", err)
+			}
+			options := nats.GetDefaultOptions()
+			for _, opt := range opts {
+				if err := opt(&options); err != nil {
+					if tt.wantOptErr == "" || !strings.Contains(err.Error(), tt.wantOptErr) {
+						t.Fatalf("option: %v", err)
+					}
+					return
+				}
+			}
+			if tt.wantOptErr != "" {
+				t.Fatalf("options applied, want an error naming %s", tt.wantOptErr)
+			}
+			if !tt.check(&options) {
+				t.Fatalf("unexpected options: %+v", options)
+			}
+		})
+	}
+}
+
+func TestConnStateFollowsReconnects(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	state := &connState{}
+	opts, err := connectOptions(configs.NATSConfig{}, "video-worker-1", state, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := nats.GetDefaultOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	lost := errors.New("connection reset by peer")
+	options.DisconnectedErrCB(nil, lost)
+	if state.get() != lost {
+		t.Fatalf("state = %v after a disconnect, want %v", state.get(), lost)
+	}
+	options.ReconnectedCB(nil)
+	if state.get() != nil {
+		t.Fatalf("state = %v after reconnecting, want nil", state.get())
+	}
+}
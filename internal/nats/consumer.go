@@ -2,34 +2,91 @@ package nats
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nats-io/nats.go"
 	"github.com/Tungwong-Project/tungwong-video-worker/configs"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/disk"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/jobs"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/metrics"
 	"github.com/Tungwong-Project/tungwong-video-worker/internal/models"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/tracing"
+	"github.com/Tungwong-Project/tungwong-video-worker/pkg/logger"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// diskFullRetryDelay is the least a job that failed for lack of disk space
+// waits before it is redelivered
+const diskFullRetryDelay = time.Minute
+
+// redeliveryPollWait is how long a lane is polled for jobs due for redelivery
+// while no lane has new jobs
+const redeliveryPollWait = 250 * time.Millisecond
+
+// laneRefreshInterval is how often the fetch loop asks for the consumer info
+// of all lanes
+const laneRefreshInterval = time.Second
+
 type Processor interface {
 	Process(ctx context.Context, msg *models.VideoUploadMessage) error
+	Reencode(ctx context.Context, msg *models.ReencodeMessage) error
 }
 
 type Consumer struct {
-	nc        *nats.Conn
-	js        nats.JetStreamContext
-	sub       *nats.Subscription
-	config    *configs.Config
-	processor Processor
-	logger    *logrus.Logger
+	nc          *nats.Conn
+	js          nats.JetStreamContext
+	lanes       []*lane
+	scheduler   *scheduler
+	prioritizer Prioritizer
+	chunkRunner ChunkRunner
+	config      *configs.Config
+	processor   Processor
+	jobs        *jobs.Registry
+	slots       *slots
+	maxRetries  atomic.Int64 // Redeliveries before a failing message is terminated
+	conn        connState
+	inflight    sync.WaitGroup
+	logger      *logrus.Logger
+
+	lanesCheckedAt time.Time // Last consumer info of all lanes, touched only by the fetch loop
+	unackedLanes   []*lane   // Lanes with unacknowledged jobs at lanesCheckedAt
+	eventsOff      bool      // Set by provision when bind-only mode finds no events stream
+
+	chunkCtx      context.Context // Cancelled on shutdown to abort chunks served for other workers
+	stopChunks    context.CancelFunc
+	chunksRunning sync.WaitGroup
+
+	pauseMu  sync.Mutex
+	paused   bool
+	resumed  chan struct{}      // Closed on Resume while paused
+	chunkSub *nats.Subscription // Takes chunks of other workers; nil while paused
 }
 
-func NewConsumer(config *configs.Config, processor Processor, logger *logrus.Logger) (*Consumer, error) {
+func NewConsumer(config *configs.Config, processor Processor, registry *jobs.Registry, logger *logrus.Logger) (*Consumer, error) {
+	consumer := &Consumer{
+		config:    config,
+		processor: processor,
+		jobs:      registry,
+		lanes:     newLanes(config.NATS),
+		scheduler: newScheduler(config.Priority.Weights),
+		slots:     newSlots(config.Worker.MaxConcurrentJobs),
+		logger:    logger,
+	}
+	consumer.maxRetries.Store(int64(config.Retry.MaxRetries))
+
 	// Connect to NATS
-	nc, err := nats.Connect(config.NATS.URL)
+	nc, err := connect(config.NATS, "video-worker "+config.Worker.ID, &consumer.conn, logger)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+		return nil, err
 	}
 
 	// Create JetStream context
@@ -39,120 +96,448 @@ func NewConsumer(config *configs.Config, processor Processor, logger *logrus.Log
 		return nil, fmt.Errorf("failed to create JetStream context: %w", err)
 	}
 
-	return &Consumer{
-		nc:        nc,
-		js:        js,
-		config:    config,
-		processor: processor,
-		logger:    logger,
-	}, nil
+	consumer.nc = nc
+	consumer.js = js
+	return consumer, nil
 }
 
 func (c *Consumer) Start(ctx context.Context) error {
 	c.logger.Info("Starting NATS consumer...")
 
-	// Ensure stream exists
-	if err := c.ensureStream(); err != nil {
-		return fmt.Errorf("failed to ensure stream: %w", err)
+	// Create or reconcile the stream and the durable consumer of every lane
+	if err := c.provision(); err != nil {
+		return fmt.Errorf("failed to provision JetStream: %w", err)
 	}
 
-	// Subscribe to JetStream with durable consumer
-	sub, err := c.js.QueueSubscribe(
-		c.config.NATS.Subject,
-		c.config.NATS.Consumer,
-		c.handleMessage,
-		nats.Durable(c.config.NATS.Durable),
-		nats.ManualAck(),
-		nats.AckWait(10*time.Minute), // Give 10 minutes for processing
-		nats.MaxDeliver(c.config.Retry.MaxRetries+1),
-	)
-	if err != nil {
-		return fmt.Errorf("failed to subscribe: %w", err)
+	// Pull from durable consumers shared by all workers, so each worker only
+	// takes as many messages as it has free job slots
+	for _, l := range c.lanes {
+		sub, err := c.js.PullSubscribe(l.bindSubject, l.durable, nats.Bind(c.config.NATS.Stream, l.durable))
+		if err != nil {
+			c.drainLanes()
+			return fmt.Errorf("failed to subscribe to the %s lane: %w", l.priority, err)
+		}
+		l.sub = sub
+		c.logger.WithFields(logrus.Fields{
+			"lane":    l.priority,
+			"subject": l.subject,
+			"durable": l.durable,
+		}).Info("Subscribed to priority lane")
 	}
 
-	c.sub = sub
 	c.logger.WithFields(logrus.Fields{
-		"subject":  c.config.NATS.Subject,
-		"consumer": c.config.NATS.Consumer,
+		"lanes":    len(c.lanes),
+		"max_jobs": c.config.Worker.MaxConcurrentJobs,
 	}).Info("NATS consumer started successfully")
 
-	// Wait for context cancellation
-	<-ctx.Done()
+	go c.reportQueueLag(ctx)
+
+	// Fetch until context cancellation
+	c.fetchLoop(ctx)
 	return c.Stop()
 }
 
-func (c *Consumer) handleMessage(msg *nats.Msg) {
-	c.logger.WithField("subject", msg.Subject).Debug("Received message")
+// fetchLoop pulls one message per free job slot and processes each in its own goroutine
+func (c *Consumer) fetchLoop(ctx context.Context) {
+	for {
+		if !c.waitUntilResumed(ctx) || !c.slots.Acquire(ctx) {
+			return
+		}
+
+		l, msgs, err := c.fetchNext(ctx)
+		if err != nil || len(msgs) == 0 {
+			c.slots.Release()
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, nats.ErrTimeout) {
+				c.logger.WithError(err).Warn("Failed to fetch messages")
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+
+		c.inflight.Add(1)
+		go func(msg *nats.Msg) {
+			defer c.inflight.Done()
+			defer c.slots.Release()
+			c.handleMessage(msg, l)
+		}(msgs[0])
+	}
+}
+
+// fetchNext pulls one message from the lane the scheduler picks among those
+// with jobs waiting. Without new jobs anywhere, it polls a lane whose
+// unacknowledged jobs may be due for redelivery. A single lane is fetched
+// from directly.
+//
+// Pending counts come from the metadata of the last message fetched from each
+// lane. Consumer info of every lane is only asked for once per
+// laneRefreshInterval, so jobs arriving in a lane that ran dry are noticed
+// within it.
+func (c *Consumer) fetchNext(ctx context.Context) (*lane, []*nats.Msg, error) {
+	l := c.lanes[0]
+	wait := 5 * time.Second
+	if len(c.lanes) > 1 {
+		if time.Since(c.lanesCheckedAt) >= laneRefreshInterval {
+			_, c.unackedLanes = c.readyLanes()
+			c.lanesCheckedAt = time.Now()
+		}
+
+		var ready []*lane
+		for _, l := range c.lanes {
+			if l.pending > 0 {
+				ready = append(ready, l)
+			}
+		}
+		wait = time.Second // Another worker may have taken the job meanwhile
+		if l = c.scheduler.pick(ready); l == nil {
+			l = c.scheduler.pick(c.unackedLanes)
+			wait = redeliveryPollWait
+		}
+		if l == nil {
+			// Nothing waiting in any lane, look again after the next refresh
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Until(c.lanesCheckedAt.Add(laneRefreshInterval))):
+			}
+			return nil, nil, nil
+		}
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	msgs, err := l.sub.Fetch(1, nats.Context(fetchCtx))
+	l.pending = 0
+	if len(msgs) > 0 {
+		if meta, err := msgs[0].Metadata(); err == nil {
+			l.pending = meta.NumPending
+		}
+	}
+	return l, msgs, err
+}
+
+// waitUntilResumed blocks while consumption is paused. Returns false if ctx is done first.
+func (c *Consumer) waitUntilResumed(ctx context.Context) bool {
+	c.pauseMu.Lock()
+	paused, resumed := c.paused, c.resumed
+	c.pauseMu.Unlock()
+
+	if !paused {
+		return true
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-resumed:
+		return true
+	}
+}
+
+// Pause stops fetching new messages. Running jobs continue.
+func (c *Consumer) Pause() {
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+
+	if !c.paused {
+		c.paused = true
+		c.resumed = make(chan struct{})
+		c.unsubscribeChunks()
+		c.logger.Info("Consumption paused")
+	}
+}
+
+// Resume starts fetching messages again after Pause
+func (c *Consumer) Resume() {
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+
+	if c.paused {
+		c.paused = false
+		close(c.resumed)
+		if c.chunkRunner != nil {
+			if err := c.subscribeChunks(); err != nil {
+				c.logger.WithError(err).Error("Failed to serve split-encode chunks again")
+			}
+		}
+		c.logger.Info("Consumption resumed")
+	}
+}
+
+// SetMaxJobs changes how many jobs run at once. Lowering it lets running jobs
+// finish and holds back new ones until enough have completed.
+func (c *Consumer) SetMaxJobs(limit int) {
+	c.slots.SetLimit(limit)
+}
+
+// SetWeights changes how free job slots are shared between the high, normal
+// and low lanes
+func (c *Consumer) SetWeights(weights []int) {
+	c.scheduler.SetWeights(weights)
+}
+
+// SetPrioritizer picks the lane of jobs that do not name a priority
+func (c *Consumer) SetPrioritizer(prioritizer Prioritizer) {
+	c.prioritizer = prioritizer
+}
+
+// SetMaxRetries changes how often a failing message is redelivered. The
+// durable's MaxDeliver is only updated on restart, so raising it has no
+// effect beyond the value the worker started with.
+func (c *Consumer) SetMaxRetries(retries int) {
+	c.maxRetries.Store(int64(retries))
+}
+
+// ConsumerStatus describes whether the worker is taking new jobs
+type ConsumerStatus struct {
+	Paused  bool `json:"paused"`
+	Running int  `json:"running"`
+	MaxJobs int  `json:"max_jobs"`
+}
+
+func (c *Consumer) Status() ConsumerStatus {
+	c.pauseMu.Lock()
+	paused := c.paused
+	c.pauseMu.Unlock()
+
+	running, limit := c.slots.Usage()
+	return ConsumerStatus{Paused: paused, Running: running, MaxJobs: limit}
+}
+
+func (c *Consumer) handleMessage(msg *nats.Msg, l *lane) {
+	c.logger.WithFields(logrus.Fields{"subject": msg.Subject, "lane": l.priority}).Debug("Received message")
+
+	// Continue the trace of whoever published the event. Jobs are not tied to
+	// the consumer's context so shutdown lets them finish.
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), headerCarrier(msg.Header))
+	ctx, span := tracing.Tracer().Start(ctx, "process "+msg.Subject,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "nats"),
+			attribute.String("messaging.destination.name", msg.Subject),
+		),
+	)
+	var spanErr error
+	defer func() { tracing.End(span, spanErr) }()
+
+	attempt := 1
+	meta, _ := msg.Metadata()
+	if meta != nil {
+		attempt = int(meta.NumDelivered)
+	}
 
-	// Parse message
-	var videoMsg models.VideoUploadMessage
-	if err := json.Unmarshal(msg.Data, &videoMsg); err != nil {
-		c.logger.WithError(err).Error("Failed to unmarshal message")
-		msg.Term() // Terminate - bad message format
+	if l.priority == laneReencode {
+		spanErr = c.handleReencode(ctx, span, msg, meta, attempt)
 		return
 	}
 
-	c.logger.WithFields(logrus.Fields{
-		"video_id": videoMsg.VideoID,
-		"title":    videoMsg.Title,
+	// Parse and validate the message; invalid messages are never retried
+	envelope, reason := decodeMessage(msg.Data)
+	if reason != nil {
+		spanErr = reason
+		c.reject(msg, meta, reason)
+		return
+	}
+	videoMsg := envelope.Payload
+
+	// Move jobs that belong in another priority lane there
+	if target := c.laneFor(ctx, l, envelope); target != nil {
+		log := c.logger.WithFields(logrus.Fields{"video_id": videoMsg.VideoID, "from": l.priority, "to": target.priority})
+		if err := c.reroute(msg, meta, target); err != nil {
+			log.WithError(err).Warn("Failed to move job to its priority lane, processing it here")
+		} else {
+			log.Info("Moved job to its priority lane")
+			msg.Ack()
+			return
+		}
+	}
+
+	// Legacy messages carry no envelope metadata, take it from JetStream
+	if envelope.MessageID == "" {
+		envelope.MessageID = messageID(msg, meta)
+	}
+	if envelope.ProducedAt.IsZero() && meta != nil {
+		envelope.ProducedAt = meta.Timestamp
+	}
+
+	span.SetAttributes(
+		attribute.String("video.id", videoMsg.VideoID),
+		attribute.String("messaging.message.id", envelope.MessageID),
+		attribute.Int("messaging.delivery_attempt", attempt),
+	)
+
+	// Every line logged for this job carries the same identifying fields
+	log := c.jobLogger(ctx, videoMsg.VideoID, envelope.MessageID, attempt)
+	log.WithFields(logrus.Fields{
+		"title":          videoMsg.Title,
+		"schema_version": envelope.SchemaVersion,
+		"lane":           l.priority,
+		"produced_at":    envelope.ProducedAt,
 	}).Info("Processing video upload")
 
-	// Process the video
-	if err := c.processor.Process(context.Background(), &videoMsg); err != nil {
-		c.logger.WithError(err).WithField("video_id", videoMsg.VideoID).Error("Failed to process video")
+	spanErr = c.runJob(logger.NewContext(ctx, log), msg, meta, attempt, videoMsg.VideoID, videoMsg.Title, func(ctx context.Context) error {
+		return c.processor.Process(ctx, &videoMsg)
+	})
+}
+
+// jobLogger returns the logger of one job
+func (c *Consumer) jobLogger(ctx context.Context, videoID, messageID string, attempt int) *logrus.Entry {
+	log := c.logger.WithFields(logrus.Fields{
+		"video_id":   videoID,
+		"message_id": messageID,
+		"attempt":    attempt,
+		"worker_id":  c.config.Worker.ID,
+	})
+	if traceID := tracing.TraceID(ctx); traceID != "" {
+		log = log.WithField("trace_id", traceID)
+	}
+	return log
+}
+
+// runJob registers a job and runs process while telling JetStream the job is
+// alive, so long encodes are not redelivered to another worker. The message
+// is acked on success and redelivered, terminated or dead-lettered on failure.
+func (c *Consumer) runJob(ctx context.Context, msg *nats.Msg, meta *nats.MsgMetadata, attempt int, videoID, title string, process func(ctx context.Context) error) error {
+	log := logger.FromContext(ctx, c.logger)
+
+	var id string
+	if meta != nil {
+		id = strconv.FormatUint(meta.Sequence.Stream, 10)
+	}
+	ctx, job := c.jobs.Start(ctx, id, videoID, title, attempt)
+	defer c.jobs.Finish(job)
+
+	stopHeartbeat := c.heartbeat(msg, attempt)
+	err := process(ctx)
+	stopHeartbeat()
+	if err != nil {
+		// Cancelled through the admin API; the caller chose what happens to the message
+		if cancelled, requeue := job.Cancelled(); cancelled {
+			log.WithField("requeue", requeue).Warn("Job cancelled")
+			if requeue {
+				msg.Nak()
+			} else {
+				msg.Term()
+			}
+			return err
+		}
+
+		// Failures no retry can fix are rejected like invalid messages
+		if terminal, ok := jobs.AsTerminal(err); ok {
+			c.reject(msg, meta, &rejection{code: strings.ToLower(terminal.Code), err: err})
+			return err
+		}
+
+		log.WithError(err).Error("Failed to process video")
 
 		// Check if we should retry
-		meta, _ := msg.Metadata()
-		if meta != nil && meta.NumDelivered >= uint64(c.config.Retry.MaxRetries+1) {
-			c.logger.WithField("video_id", videoMsg.VideoID).Warn("Max retries reached, terminating message")
+		if meta != nil && meta.NumDelivered >= uint64(c.maxRetries.Load()+1) {
+			log.Warn("Max retries reached, terminating message")
 			msg.Term() // No more retries
+		} else if delay := backoffDelay(c.config.NATS.Backoff, attempt); delay > 0 || disk.IsFull(err) {
+			// Give the disk time to drain rather than failing on it again at once
+			if disk.IsFull(err) {
+				delay = max(delay, diskFullRetryDelay)
+			}
+			msg.NakWithDelay(delay)
 		} else {
 			// Nack for retry
 			msg.Nak()
 		}
-		return
+		return err
 	}
 
 	// Success - Ack the message
 	if err := msg.Ack(); err != nil {
-		c.logger.WithError(err).Error("Failed to ack message")
+		log.WithError(err).Error("Failed to ack message")
 	} else {
-		c.logger.WithField("video_id", videoMsg.VideoID).Info("Video processed successfully")
+		log.Info("Video processed successfully")
 	}
+	return nil
 }
 
-func (c *Consumer) ensureStream() error {
-	// Try to get stream info
-	_, err := c.js.StreamInfo(c.config.NATS.Stream)
-	if err == nil {
-		c.logger.WithField("stream", c.config.NATS.Stream).Info("Stream already exists")
-		return nil
+// heartbeat acks msg as in progress at half its ack wait until the returned
+// function is called
+func (c *Consumer) heartbeat(msg *nats.Msg, attempt int) (stop func()) {
+	ackWait := backoffDelay(c.config.NATS.Backoff, attempt)
+	if ackWait == 0 {
+		ackWait = time.Duration(c.config.NATS.AckWait) * time.Second
 	}
 
-	// Create stream if it doesn't exist
-	c.logger.WithField("stream", c.config.NATS.Stream).Info("Creating stream...")
-	_, err = c.js.AddStream(&nats.StreamConfig{
-		Name:      c.config.NATS.Stream,
-		Subjects:  []string{c.config.NATS.Subject},
-		Retention: nats.WorkQueuePolicy,
-		MaxAge:    24 * time.Hour, // Keep messages for 24 hours
-	})
-	if err != nil {
-		return fmt.Errorf("failed to create stream: %w", err)
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(max(ackWait/2, time.Second))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := msg.InProgress(); err != nil {
+					c.logger.WithError(err).Warn("Failed to extend ack deadline")
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// reportQueueLag periodically exports the consumer's pending counts as metrics
+func (c *Consumer) reportQueueLag(ctx context.Context) {
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		var pending, ackPending uint64
+		for _, l := range c.lanes {
+			info, err := c.js.ConsumerInfo(c.config.NATS.Stream, l.durable)
+			if err != nil {
+				c.logger.WithError(err).WithField("lane", l.priority).Debug("Failed to fetch consumer info")
+				continue
+			}
+			pending += info.NumPending
+			ackPending += uint64(info.NumAckPending)
+			metrics.LanePending.WithLabelValues(l.priority).Set(float64(info.NumPending))
+		}
+		metrics.QueuePending.Set(float64(pending))
+		metrics.QueueAckPending.Set(float64(ackPending))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 	}
+}
 
-	c.logger.Info("Stream created successfully")
-	return nil
+// Healthy reports whether the NATS connection is up, and why it went down if not
+func (c *Consumer) Healthy() (string, error) {
+	status := c.nc.Status().String()
+	if !c.nc.IsConnected() {
+		if err := c.conn.get(); err != nil {
+			return status, fmt.Errorf("nats connection is %s: %w", strings.ToLower(status), err)
+		}
+		return status, fmt.Errorf("nats connection is %s", strings.ToLower(status))
+	}
+	return c.nc.ConnectedUrlRedacted(), nil
 }
 
 func (c *Consumer) Stop() error {
 	c.logger.Info("Stopping NATS consumer...")
 
-	if c.sub != nil {
-		if err := c.sub.Drain(); err != nil {
-			c.logger.WithError(err).Error("Failed to drain subscription")
-		}
+	// Chunks served for other workers are aborted; their jobs fail over and retry
+	c.pauseMu.Lock()
+	c.unsubscribeChunks()
+	c.pauseMu.Unlock()
+	if c.stopChunks != nil {
+		c.stopChunks()
 	}
+	c.chunksRunning.Wait()
+
+	c.drainLanes()
+
+	// Running jobs still need the connection to ack their messages
+	c.inflight.Wait()
 
 	if c.nc != nil {
 		c.nc.Close()
@@ -161,3 +546,15 @@ func (c *Consumer) Stop() error {
 	c.logger.Info("NATS consumer stopped")
 	return nil
 }
+
+// drainLanes stops fetching from every lane
+func (c *Consumer) drainLanes() {
+	for _, l := range c.lanes {
+		if l.sub == nil {
+			continue
+		}
+		if err := l.sub.Drain(); err != nil {
+			c.logger.WithError(err).WithField("lane", l.priority).Error("Failed to drain subscription")
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/models"
+	"go.opentelemetry.io/otel"
+)
+
+// PublishEvent publishes a job lifecycle event with JetStream. The event ID is
+// used as the message ID, so the stream drops a retried publish of the same
+// event.
+func (c *Consumer) PublishEvent(ctx context.Context, event *models.EncodeEvent) error {
+	prefix := c.config.NATS.EventsSubject
+	if prefix == "" || c.eventsOff {
+		return nil
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s event: %w", event.Type, err)
+	}
+
+	msg := nats.NewMsg(prefix + "." + event.Type)
+	msg.Data = data
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(msg.Header))
+
+	if _, err := c.js.PublishMsg(msg, nats.MsgId(event.ID), nats.Context(ctx)); err != nil {
+		return fmt.Errorf("failed to publish %s event: %w", event.Type, err)
+	}
+	return nil
+}
+
+// provisionEvents makes sure a stream captures the lifecycle events. Events
+// are optional, so in bind-only mode a missing stream only turns them off.
+func (c *Consumer) provisionEvents() error {
+	if c.config.NATS.EventsSubject == "" {
+		return nil
+	}
+	err := c.provisionCapture("events", c.config.NATS.EventsStream, c.config.NATS.EventsSubject+".>")
+	if errors.Is(err, nats.ErrStreamNotFound) && c.config.NATS.BindOnly {
+		c.logger.WithField("stream", c.config.NATS.EventsStream).Warn("Events stream does not exist, bind-only mode publishes no job events")
+		c.eventsOff = true
+		return nil
+	}
+	return err
+}
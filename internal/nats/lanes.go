@@ -0,0 +1,195 @@
+package nats
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/Tungwong-Project/tungwong-video-worker/configs"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/metrics"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/models"
+)
+
+// Prioritizer picks the lane for a job that does not name a priority. An
+// empty result leaves the job in the lane it arrived in.
+type Prioritizer interface {
+	Priority(ctx context.Context, msg *models.VideoUploadMessage) string
+}
+
+// lane is one priority class of jobs, consumed through its own durable
+type lane struct {
+	priority    string
+	subject     string // Where jobs for this lane are published
+	bindSubject string // Filter subject of the durable, set by provision
+	durable     string
+	sub         *nats.Subscription
+	pending     uint64 // Jobs waiting at the last fetch or consumer info, touched only by the fetch loop
+	credit      int    // Smooth weighted round-robin state, guarded by scheduler.mu
+}
+
+// newLanes returns the normal lane plus the high and low lanes that have a
+// subject configured, highest priority first, and the re-encode lane
+func newLanes(cfg configs.NATSConfig) []*lane {
+	var lanes []*lane
+	if cfg.HighPrioritySubject != "" {
+		lanes = append(lanes, &lane{priority: models.PriorityHigh, subject: cfg.HighPrioritySubject, durable: cfg.Durable + "-high"})
+	}
+	lanes = append(lanes, &lane{priority: models.PriorityNormal, subject: cfg.Subject, durable: cfg.Durable})
+	if cfg.LowPrioritySubject != "" {
+		lanes = append(lanes, &lane{priority: models.PriorityLow, subject: cfg.LowPrioritySubject, durable: cfg.Durable + "-low"})
+	}
+	if cfg.ReencodeSubject != "" {
+		lanes = append(lanes, &lane{priority: laneReencode, subject: cfg.ReencodeSubject, durable: cfg.Durable + "-reencode"})
+	}
+	return lanes
+}
+
+// scheduler hands free job slots to lanes with smooth weighted round-robin:
+// while every lane has jobs waiting each gets slots in proportion to its
+// weight, so long low-priority batches cannot starve short uploads and low
+// lanes still make progress under load
+type scheduler struct {
+	mu      sync.Mutex
+	weights map[string]int
+}
+
+func newScheduler(weights []int) *scheduler {
+	s := &scheduler{}
+	s.SetWeights(weights)
+	return s
+}
+
+// SetWeights sets the weights of the high, normal and low lanes
+func (s *scheduler) SetWeights(weights []int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.weights = map[string]int{models.PriorityHigh: 1, models.PriorityNormal: 1, models.PriorityLow: 1}
+	for i, priority := range []string{models.PriorityHigh, models.PriorityNormal, models.PriorityLow} {
+		if i < len(weights) && weights[i] > 0 {
+			s.weights[priority] = weights[i]
+		}
+	}
+	s.weights[laneReencode] = s.weights[models.PriorityLow]
+}
+
+// pick returns the lane to take the next job from among ready, or nil when
+// no lane has jobs waiting
+func (s *scheduler) pick(ready []*lane) *lane {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var best *lane
+	total := 0
+	for _, l := range ready {
+		weight := s.weights[l.priority]
+		l.credit += weight
+		total += weight
+		if best == nil || l.credit > best.credit {
+			best = l
+		}
+	}
+	if best != nil {
+		best.credit -= total
+	}
+	return best
+}
+
+// readyLanes returns the lanes with new jobs waiting in their durable and
+// those with delivered jobs not yet acknowledged, and updates the pending
+// count of every lane. NumPending leaves out naked, backed-off and timed-out
+// jobs waiting for redelivery, so the latter may have jobs due as well.
+func (c *Consumer) readyLanes() (ready, unacked []*lane) {
+	for _, l := range c.lanes {
+		info, err := l.sub.ConsumerInfo()
+		if err != nil {
+			c.logger.WithError(err).WithField("lane", l.priority).Debug("Failed to fetch lane consumer info")
+			continue
+		}
+		l.pending = info.NumPending
+		if info.NumPending > 0 {
+			ready = append(ready, l)
+		}
+		if info.NumAckPending > 0 {
+			unacked = append(unacked, l)
+		}
+	}
+	return ready, unacked
+}
+
+// laneFor returns the lane a job belongs in: the one its priority names or,
+// for a job without one that arrived in the normal lane, the one the
+// prioritizer picks. Nil keeps the job where it is, also when the lane it
+// belongs in is not configured.
+func (c *Consumer) laneFor(ctx context.Context, current *lane, envelope *models.MessageEnvelope) *lane {
+	if len(c.lanes) == 1 {
+		return nil
+	}
+
+	priority := envelope.Priority
+	if priority == "" && current.priority == models.PriorityNormal && c.prioritizer != nil {
+		priority = c.prioritizer.Priority(ctx, &envelope.Payload)
+	}
+	if priority == "" || priority == current.priority {
+		return nil
+	}
+	for _, l := range c.lanes {
+		if l.priority == priority {
+			return l
+		}
+	}
+	return nil
+}
+
+// originalMsgIDHdr carries the Nats-Msg-Id of a rerouted message. The stream
+// would drop the moved message as a duplicate if it kept that ID, so the move
+// is published under an ID derived from it instead.
+const originalMsgIDHdr = "Original-Msg-Id"
+
+// reroute republishes msg unchanged to the subject of target. The move is
+// published under the original message ID and the target lane, or the stream
+// sequence without one, so a redelivered message is moved once.
+func (c *Consumer) reroute(msg *nats.Msg, meta *nats.MsgMetadata, target *lane) error {
+	moved := nats.NewMsg(target.subject)
+	moved.Data = msg.Data
+	for key, values := range msg.Header {
+		if key == nats.MsgIdHdr {
+			continue
+		}
+		moved.Header[key] = values
+	}
+
+	var opts []nats.PubOpt
+	if id := publishedMsgID(msg); id != "" {
+		moved.Header.Set(originalMsgIDHdr, id)
+		opts = append(opts, nats.MsgId(id+"-"+target.priority))
+	} else if meta != nil {
+		opts = append(opts, nats.MsgId(fmt.Sprintf("%s-%d", meta.Stream, meta.Sequence.Stream)))
+	}
+	if _, err := c.js.PublishMsg(moved, opts...); err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", target.subject, err)
+	}
+	metrics.JobsRerouted.WithLabelValues(target.priority).Inc()
+	return nil
+}
+
+// publishedMsgID returns the Nats-Msg-Id msg was first published with, also
+// after a reroute, or "" without one
+func publishedMsgID(msg *nats.Msg) string {
+	if id := msg.Header.Get(originalMsgIDHdr); id != "" {
+		return id
+	}
+	return msg.Header.Get(nats.MsgIdHdr)
+}
+
+// messageID identifies a message without an envelope message ID: the
+// Nats-Msg-Id it was published with, or the stream and sequence
+func messageID(msg *nats.Msg, meta *nats.MsgMetadata) string {
+	if id := publishedMsgID(msg); id != "" {
+		return id
+	}
+	if meta != nil {
+		return fmt.Sprintf("%s-%d", meta.Stream, meta.Sequence.Stream)
+	}
+	return ""
+}
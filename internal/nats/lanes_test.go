@@ -0,0 +1,137 @@
+package nats
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/models"
+)
+
+func TestSchedulerPick(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []int
+		ready   []string
+		picks   int
+		want    map[string]int
+	}{
+		{
+			name:    "weighted",
+			weights: []int{4, 2, 1},
+			ready:   []string{models.PriorityHigh, models.PriorityNormal, models.PriorityLow},
+			picks:   7,
+			want:    map[string]int{models.PriorityHigh: 4, models.PriorityNormal: 2, models.PriorityLow: 1},
+		},
+		{
+			name:    "weighted twice over",
+			weights: []int{4, 2, 1},
+			ready:   []string{models.PriorityHigh, models.PriorityNormal, models.PriorityLow},
+			picks:   14,
+			want:    map[string]int{models.PriorityHigh: 8, models.PriorityNormal: 4, models.PriorityLow: 2},
+		},
+		{
+			name:  "equal by default",
+			ready: []string{models.PriorityHigh, models.PriorityNormal, models.PriorityLow},
+			picks: 6,
+			want:  map[string]int{models.PriorityHigh: 2, models.PriorityNormal: 2, models.PriorityLow: 2},
+		},
+		{
+			name:    "invalid weights default to one",
+			weights: []int{0, -1, 3},
+			ready:   []string{models.PriorityHigh, models.PriorityNormal, models.PriorityLow},
+			picks:   5,
+			want:    map[string]int{models.PriorityHigh: 1, models.PriorityNormal: 1, models.PriorityLow: 3},
+		},
+		{
+			name:    "reencode shares the low weight",
+			weights: []int{3, 1, 2},
+			ready:   []string{models.PriorityNormal, laneReencode},
+			picks:   6,
+			want:    map[string]int{models.PriorityNormal: 2, laneReencode: 4},
+		},
+		{
+			name:    "single lane",
+			weights: []int{4, 2, 1},
+			ready:   []string{models.PriorityLow},
+			picks:   3,
+			want:    map[string]int{models.PriorityLow: 3},
+		},
+		{
+			name:    "nothing ready",
+			weights: []int{4, 2, 1},
+			picks:   3,
+			want:    map[string]int{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newScheduler(tt.weights)
+			var ready []*lane
+			for _, priority := range tt.ready {
+				ready = append(ready, &lane{priority: priority})
+			}
+
+			got := map[string]int{}
+			for range tt.picks {
+				if l := s.pick(ready); l != nil {
+					got[l.priority]++
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("picks = %v, want %v", got, tt.want)
+			}
+			for priority, n := range tt.want {
+				if got[priority] != n {
+					t.Fatalf("picks = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// TestSchedulerPickSmooth checks that a heavy lane does not take its whole
+// share in a row while lighter lanes wait
+func TestSchedulerPickSmooth(t *testing.T) {
+	s := newScheduler([]int{4, 2, 1})
+	ready := []*lane{{priority: models.PriorityHigh}, {priority: models.PriorityNormal}, {priority: models.PriorityLow}}
+
+	var order []string
+	for range 7 {
+		order = append(order, s.pick(ready).priority)
+	}
+	want := []string{
+		models.PriorityHigh, models.PriorityNormal, models.PriorityHigh, models.PriorityLow,
+		models.PriorityHigh, models.PriorityNormal, models.PriorityHigh,
+	}
+	if !slices.Equal(order, want) {
+		t.Fatalf("order = %v, want %v", order, want)
+	}
+}
+
+func TestMessageID(t *testing.T) {
+	meta := &nats.MsgMetadata{Stream: "VIDEO_UPLOADS", Sequence: nats.SequencePair{Stream: 42}}
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		meta    *nats.MsgMetadata
+		want    string
+	}{
+		{name: "published id", headers: map[string]string{nats.MsgIdHdr: "upload-1"}, meta: meta, want: "upload-1"},
+		{name: "rerouted keeps original id", headers: map[string]string{nats.MsgIdHdr: "upload-1-high", originalMsgIDHdr: "upload-1"}, meta: meta, want: "upload-1"},
+		{name: "stream sequence without id", meta: meta, want: "VIDEO_UPLOADS-42"},
+		{name: "nothing to go by", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := nats.NewMsg("videos.uploaded")
+			for key, value := range tt.headers {
+				msg.Header.Set(key, value)
+			}
+			if got := messageID(msg, tt.meta); got != tt.want {
+				t.Fatalf("messageID = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
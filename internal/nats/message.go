@@ -0,0 +1,138 @@
+package nats
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/metrics"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// Headers describing why a message was dead-lettered
+const (
+	deadLetterReasonHdr   = "Dead-Letter-Reason"
+	deadLetterCodeHdr     = "Dead-Letter-Code"
+	deadLetterSubjectHdr  = "Dead-Letter-Subject"
+	deadLetterSequenceHdr = "Dead-Letter-Stream-Sequence"
+	deadLetterWorkerHdr   = "Dead-Letter-Worker"
+)
+
+// deadLetterRetryDelay is how long a rejected message waits before another
+// attempt when the dead-letter publish itself failed
+const deadLetterRetryDelay = 10 * time.Second
+
+// rejection explains why a message can never be processed
+type rejection struct {
+	code string // malformed, unsupported_version, invalid or the lowercase code of a terminal job failure
+	err  error
+}
+
+func (r *rejection) Error() string { return r.err.Error() }
+
+// decodeMessage parses a job in the versioned envelope or, when the message has
+// no schema_version, in the legacy flat format, and validates it. Unknown
+// fields are rejected in the envelope but tolerated in the legacy format, so
+// existing producers keep working.
+func decodeMessage(data []byte) (*models.MessageEnvelope, *rejection) {
+	var probe struct {
+		SchemaVersion *int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, &rejection{code: "malformed", err: fmt.Errorf("malformed JSON: %w", err)}
+	}
+
+	var envelope models.MessageEnvelope
+	switch {
+	case probe.SchemaVersion == nil:
+		if err := json.Unmarshal(data, &envelope.Payload); err != nil {
+			return nil, &rejection{code: "malformed", err: fmt.Errorf("malformed message: %w", err)}
+		}
+		if err := envelope.Payload.Validate(); err != nil {
+			return nil, &rejection{code: "invalid", err: err}
+		}
+	case *probe.SchemaVersion < 1 || *probe.SchemaVersion > models.CurrentSchemaVersion:
+		return nil, &rejection{code: "unsupported_version", err: fmt.Errorf("unsupported schema_version %d, this worker understands 1 to %d",
+			*probe.SchemaVersion, models.CurrentSchemaVersion)}
+	default:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&envelope); err != nil {
+			return nil, &rejection{code: "malformed", err: fmt.Errorf("malformed envelope: %w", err)}
+		}
+		if err := envelope.Validate(); err != nil {
+			return nil, &rejection{code: "invalid", err: err}
+		}
+	}
+
+	return &envelope, nil
+}
+
+// reject moves a message that can never be processed to the dead-letter
+// subject, or terminates it when dead-lettering is disabled
+func (c *Consumer) reject(msg *nats.Msg, meta *nats.MsgMetadata, reason *rejection) {
+	metrics.MessagesRejected.WithLabelValues(reason.code).Inc()
+	log := c.logger.WithError(reason).WithFields(logrus.Fields{
+		"subject": msg.Subject,
+		"code":    reason.code,
+	})
+	if meta != nil {
+		log = log.WithField("stream_sequence", meta.Sequence.Stream)
+	}
+
+	if c.config.NATS.DeadLetterSubject == "" {
+		log.Error("Rejected invalid message, terminating it")
+		msg.Term()
+		return
+	}
+
+	if err := c.deadLetter(msg, meta, reason); err != nil {
+		log.WithField("dead_letter_error", err).Error("Failed to dead-letter invalid message, redelivering it")
+		msg.NakWithDelay(deadLetterRetryDelay)
+		return
+	}
+	log.WithField("dead_letter_subject", c.config.NATS.DeadLetterSubject).Warn("Rejected invalid message, moved it to the dead-letter subject")
+	msg.Term()
+}
+
+// deadLetter republishes the original message with headers describing why it
+// was rejected. The stream sequence is the message ID, so a redelivered
+// message is dead-lettered once.
+func (c *Consumer) deadLetter(msg *nats.Msg, meta *nats.MsgMetadata, reason *rejection) error {
+	dead := nats.NewMsg(c.config.NATS.DeadLetterSubject)
+	dead.Data = msg.Data
+	for key, values := range msg.Header {
+		if key == nats.MsgIdHdr {
+			continue
+		}
+		dead.Header[key] = values
+	}
+	dead.Header.Set(deadLetterReasonHdr, strings.ReplaceAll(reason.Error(), "\n", "; ")) // Headers cannot span lines
+	dead.Header.Set(deadLetterCodeHdr, reason.code)
+	dead.Header.Set(deadLetterSubjectHdr, msg.Subject)
+	dead.Header.Set(deadLetterWorkerHdr, c.config.Worker.ID)
+
+	var opts []nats.PubOpt
+	if meta != nil {
+		dead.Header.Set(deadLetterSequenceHdr, strconv.FormatUint(meta.Sequence.Stream, 10))
+		opts = append(opts, nats.MsgId(fmt.Sprintf("%s-%d", meta.Stream, meta.Sequence.Stream)))
+	}
+
+	if _, err := c.js.PublishMsg(dead, opts...); err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", c.config.NATS.DeadLetterSubject, err)
+	}
+	return nil
+}
+
+// provisionDeadLetter makes sure a stream captures the dead-letter subject
+func (c *Consumer) provisionDeadLetter() error {
+	if c.config.NATS.DeadLetterSubject == "" {
+		return nil
+	}
+	return c.provisionCapture("dead-letter", c.config.NATS.DeadLetterStream, c.config.NATS.DeadLetterSubject)
+}
@@ -0,0 +1,156 @@
+package nats
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/Tungwong-Project/tungwong-video-worker/configs"
+	"github.com/sirupsen/logrus"
+)
+
+// recordingJetStream records published messages; other calls panic
+type recordingJetStream struct {
+	nats.JetStreamContext
+	published []*nats.Msg
+}
+
+func (js *recordingJetStream) PublishMsg(msg *nats.Msg, opts ...nats.PubOpt) (*nats.PubAck, error) {
+	js.published = append(js.published, msg)
+	return &nats.PubAck{}, nil
+}
+
+func TestDecodeMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		wantCode string
+		wantErr  string
+	}{
+		{
+			name: "legacy",
+			data: `{"video_id":"video-1","upload_file_path":"movie.mp4","title":"Movie"}`,
+		},
+		{
+			name: "legacy unknown field",
+			data: `{"video_id":"video-1","upload_file_path":"movie.mp4","extra":true}`,
+		},
+		{
+			name: "envelope",
+			data: `{"schema_version":1,"message_id":"msg-1","produced_at":"2026-10-18T09:30:00Z","priority":"low",
+				"payload":{"video_id":"video-1","upload_file_path":"movie.mp4"}}`,
+		},
+		{
+			name:     "not json",
+			data:     `video-1`,
+			wantCode: "malformed",
+			wantErr:  "malformed JSON",
+		},
+		{
+			name:     "legacy wrong type",
+			data:     `{"video_id":1,"upload_file_path":"movie.mp4"}`,
+			wantCode: "malformed",
+			wantErr:  "malformed message",
+		},
+		{
+			name:     "legacy traversal",
+			data:     `{"video_id":"video-1","upload_file_path":"../../etc/passwd"}`,
+			wantCode: "invalid",
+			wantErr:  "upload_file_path",
+		},
+		{
+			name:     "legacy bad id",
+			data:     `{"video_id":"..","upload_file_path":"movie.mp4"}`,
+			wantCode: "invalid",
+			wantErr:  "video_id",
+		},
+		{
+			name:     "future version",
+			data:     `{"schema_version":2,"payload":{}}`,
+			wantCode: "unsupported_version",
+			wantErr:  "unsupported schema_version 2",
+		},
+		{
+			name:     "zero version",
+			data:     `{"schema_version":0,"payload":{}}`,
+			wantCode: "unsupported_version",
+		},
+		{
+			name: "envelope unknown field",
+			data: `{"schema_version":1,"message_id":"msg-1","produced_at":"2026-10-18T09:30:00Z","extra":true,
+				"payload":{"video_id":"video-1","upload_file_path":"movie.mp4"}}`,
+			wantCode: "malformed",
+			wantErr:  "malformed envelope",
+		},
+		{
+			name:     "envelope missing fields",
+			data:     `{"schema_version":1,"payload":{"video_id":"video-1","upload_file_path":"movie.mp4"}}`,
+			wantCode: "invalid",
+			wantErr:  "message_id",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envelope, reject := decodeMessage([]byte(tt.data))
+			if tt.wantCode == "" {
+				if reject != nil {
+					t.Fatalf("unexpected rejection: %v", reject)
+				}
+				if envelope.Payload.VideoID != "video-1" {
+					t.Fatalf("video_id = %q, want video-1", envelope.Payload.VideoID)
+				}
+				return
+			}
+			if reject == nil {
+				t.Fatalf("expected rejection %s, got none", tt.wantCode)
+			}
+			if reject.code != tt.wantCode {
+				t.Fatalf("code = %s, want %s (%v)", reject.code, tt.wantCode, reject)
+			}
+			if !strings.Contains(reject.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, reject)
+			}
+		})
+	}
+}
+
+func TestRejectDeadLettersByDefault(t *testing.T) {
+	config, err := configs.LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	js := &recordingJetStream{}
+	c := &Consumer{js: js, config: config, logger: logger}
+
+	msg := nats.NewMsg(config.NATS.Subject)
+	msg.Data = []byte(`{"video_id":"..","upload_file_path":"movie.mp4"}`)
+	meta := &nats.MsgMetadata{Stream: config.NATS.Stream, Sequence: nats.SequencePair{Stream: 7}}
+	_, reason := decodeMessage(msg.Data)
+	c.reject(msg, meta, reason)
+
+	if len(js.published) != 1 {
+		t.Fatalf("published %d messages, want the rejected one on the dead-letter subject", len(js.published))
+	}
+	dead := js.published[0]
+	if dead.Subject != "video.dead_letter" {
+		t.Fatalf("subject = %q, want video.dead_letter", dead.Subject)
+	}
+	if string(dead.Data) != string(msg.Data) {
+		t.Fatalf("data = %s, want the original message", dead.Data)
+	}
+	for header, want := range map[string]string{
+		deadLetterCodeHdr:     "invalid",
+		deadLetterSubjectHdr:  config.NATS.Subject,
+		deadLetterSequenceHdr: "7",
+	} {
+		if got := dead.Header.Get(header); got != want {
+			t.Fatalf("%s = %q, want %q", header, got, want)
+		}
+	}
+	if reason := dead.Header.Get(deadLetterReasonHdr); !strings.Contains(reason, "video_id") {
+		t.Fatalf("%s = %q, want the validation error", deadLetterReasonHdr, reason)
+	}
+}
@@ -0,0 +1,304 @@
+package nats
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+// provision makes sure the stream and the durable consumer of every lane
+// exist and match the config, and sets the subject each lane binds to. In
+// bind-only mode nothing is created or changed; differences are only logged.
+func (c *Consumer) provision() error {
+	cfg := c.config.NATS
+
+	stream, err := c.js.StreamInfo(cfg.Stream)
+	switch {
+	case errors.Is(err, nats.ErrStreamNotFound) && cfg.BindOnly:
+		return fmt.Errorf("stream %s does not exist and bind-only mode does not create it", cfg.Stream)
+	case errors.Is(err, nats.ErrStreamNotFound):
+		c.logger.WithField("stream", cfg.Stream).Info("Creating stream...")
+		if _, err := c.js.AddStream(c.streamConfig(nats.StreamConfig{Retention: nats.WorkQueuePolicy})); err != nil {
+			return fmt.Errorf("failed to create stream: %w", err)
+		}
+	case err != nil:
+		return fmt.Errorf("failed to get stream info: %w", err)
+	default:
+		if err := c.reconcileStream(stream.Config); err != nil {
+			return err
+		}
+	}
+
+	if err := c.provisionEvents(); err != nil {
+		return err
+	}
+	if err := c.provisionDeadLetter(); err != nil {
+		return err
+	}
+
+	lanes := c.lanes[:0]
+	for _, l := range c.lanes {
+		err := c.provisionConsumer(l)
+		if errors.Is(err, nats.ErrConsumerNotFound) && cfg.BindOnly && l.priority == laneReencode {
+			// Re-encodes are optional; a deployment that does not provision
+			// their durable still processes uploads
+			c.logger.WithField("durable", l.durable).Warn("Re-encode durable does not exist, bind-only mode leaves re-encode requests unconsumed")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		lanes = append(lanes, l)
+	}
+	c.lanes = lanes
+	return nil
+}
+
+// provisionConsumer creates or reconciles the durable of a lane
+func (c *Consumer) provisionConsumer(l *lane) error {
+	cfg := c.config.NATS
+	l.bindSubject = l.subject
+
+	consumer, err := c.js.ConsumerInfo(cfg.Stream, l.durable)
+	switch {
+	case errors.Is(err, nats.ErrConsumerNotFound) && cfg.BindOnly:
+		return fmt.Errorf("consumer %s on stream %s does not exist and bind-only mode does not create it: %w", l.durable, cfg.Stream, err)
+	case errors.Is(err, nats.ErrConsumerNotFound):
+		c.logger.WithFields(logrus.Fields{"durable": l.durable, "lane": l.priority}).Info("Creating consumer...")
+		if _, err := c.js.AddConsumer(cfg.Stream, c.consumerConfig(nats.ConsumerConfig{}, l)); err != nil {
+			return fmt.Errorf("failed to create consumer %s: %w", l.durable, err)
+		}
+		return nil
+	case err != nil:
+		return fmt.Errorf("failed to get consumer %s info: %w", l.durable, err)
+	case consumer.Config.DeliverSubject != "" && !cfg.BindOnly:
+		return c.replacePushConsumer(l)
+	}
+
+	if err := c.reconcileConsumer(consumer.Config, l); err != nil {
+		return err
+	}
+	if cfg.BindOnly {
+		l.bindSubject = consumer.Config.FilterSubject
+	}
+	return nil
+}
+
+// provisionCapture makes sure stream captures subject, creating the stream
+// with limits retention when missing, so every downstream consumer sees every
+// message. In bind-only mode nothing is created or changed.
+func (c *Consumer) provisionCapture(kind, name, subject string) error {
+	cfg := c.config.NATS
+	log := c.logger.WithField("stream", name)
+
+	stream, err := c.js.StreamInfo(name)
+	switch {
+	case errors.Is(err, nats.ErrStreamNotFound) && cfg.BindOnly:
+		return fmt.Errorf("%s stream %s does not exist and bind-only mode does not create it: %w", kind, name, err)
+	case errors.Is(err, nats.ErrStreamNotFound):
+		log.Infof("Creating %s stream...", kind)
+		_, err := c.js.AddStream(&nats.StreamConfig{
+			Name:      name,
+			Subjects:  []string{subject},
+			Retention: nats.LimitsPolicy,
+			Storage:   storageType(cfg.StreamStorage),
+			Replicas:  cfg.StreamReplicas,
+			MaxAge:    time.Duration(cfg.StreamMaxAge) * time.Second,
+			MaxBytes:  int64(cfg.StreamMaxBytes),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create %s stream: %w", kind, err)
+		}
+		return nil
+	case err != nil:
+		return fmt.Errorf("failed to get %s stream info: %w", kind, err)
+	}
+
+	if slices.Contains(stream.Config.Subjects, subject) {
+		return nil
+	}
+	if cfg.BindOnly {
+		log.WithField("subject", subject).Warnf("Stream does not capture the %s subject, bind-only mode leaves it unchanged", kind)
+		return nil
+	}
+
+	log.WithField("subject", subject).Infof("Adding %s subject to stream", kind)
+	update := stream.Config
+	update.Subjects = append(slices.Clone(update.Subjects), subject)
+	if _, err := c.js.UpdateStream(&update); err != nil {
+		return fmt.Errorf("failed to update %s stream: %w", kind, err)
+	}
+	return nil
+}
+
+// streamConfig overlays the settings the worker manages on base, so fields
+// owned by someone else survive an update
+func (c *Consumer) streamConfig(base nats.StreamConfig) *nats.StreamConfig {
+	cfg := c.config.NATS
+	base.Name = cfg.Stream
+	for _, l := range c.lanes {
+		if !slices.Contains(base.Subjects, l.subject) {
+			base.Subjects = append(slices.Clone(base.Subjects), l.subject)
+		}
+	}
+	base.Replicas = cfg.StreamReplicas
+	base.Storage = storageType(cfg.StreamStorage)
+	base.MaxAge = time.Duration(cfg.StreamMaxAge) * time.Second
+	base.MaxBytes = int64(cfg.StreamMaxBytes)
+	return &base
+}
+
+// consumerConfig overlays the settings the worker manages on base
+func (c *Consumer) consumerConfig(base nats.ConsumerConfig, l *lane) *nats.ConsumerConfig {
+	cfg := c.config.NATS
+	base.Durable = l.durable
+	base.FilterSubject = l.subject
+	base.AckPolicy = nats.AckExplicitPolicy
+	base.AckWait = time.Duration(cfg.AckWait) * time.Second
+	base.MaxDeliver = c.config.Retry.MaxRetries + 1
+	base.MaxAckPending = cfg.MaxAckPending
+	base.BackOff = seconds(cfg.Backoff)
+	if len(base.BackOff) > 0 {
+		base.AckWait = base.BackOff[0] // JetStream replaces the ack wait with the first backoff
+	}
+	return &base
+}
+
+// reconcileStream updates the stream when it differs from the config. Storage
+// type cannot be changed in place, so a mismatch fails startup instead.
+func (c *Consumer) reconcileStream(current nats.StreamConfig) error {
+	name := c.config.NATS.Stream
+	if want := storageType(c.config.NATS.StreamStorage); current.Storage != want && !c.config.NATS.BindOnly {
+		return fmt.Errorf("stream %s uses %s storage but %s is configured; storage cannot change in place, delete the stream or fix nats.stream_storage",
+			name, current.Storage, want)
+	}
+
+	desired := c.streamConfig(current)
+	diffs := diffStream(current, *desired)
+	return c.applyDiffs("stream", name, diffs, func() error {
+		_, err := c.js.UpdateStream(desired)
+		return err
+	})
+}
+
+// replacePushConsumer swaps the push durable an older release created for a
+// pull durable of the same name. A work-queue stream allows only one consumer
+// per subject, so the two cannot run side by side; unacknowledged jobs stay
+// in the stream and the new durable delivers them again. Workers starting at
+// the same time may race here: a durable already deleted by another worker is
+// fine, and creating an identical durable twice is a no-op.
+func (c *Consumer) replacePushConsumer(l *lane) error {
+	stream := c.config.NATS.Stream
+	log := c.logger.WithFields(logrus.Fields{"durable": l.durable, "lane": l.priority})
+
+	log.Warn("Consumer is a push consumer from an older release, replacing it with a pull consumer")
+	if err := c.js.DeleteConsumer(stream, l.durable); err != nil && !errors.Is(err, nats.ErrConsumerNotFound) {
+		return fmt.Errorf("failed to delete push consumer %s: %w", l.durable, err)
+	}
+	if _, err := c.js.AddConsumer(stream, c.consumerConfig(nats.ConsumerConfig{}, l)); err != nil {
+		return fmt.Errorf("failed to create consumer %s: %w", l.durable, err)
+	}
+	return nil
+}
+
+// reconcileConsumer updates the durable when it differs from the config. A
+// push consumer can only be replaced, which bind-only mode leaves to the
+// operator.
+func (c *Consumer) reconcileConsumer(current nats.ConsumerConfig, l *lane) error {
+	name := l.durable
+	if current.DeliverSubject != "" {
+		return fmt.Errorf("consumer %s is a push consumer and bind-only mode does not replace it; recreate it as a pull consumer", name)
+	}
+	if current.AckPolicy != nats.AckExplicitPolicy {
+		return fmt.Errorf("consumer %s uses ack policy %s, the worker needs explicit acks", name, current.AckPolicy)
+	}
+
+	desired := c.consumerConfig(current, l)
+	diffs := diffConsumer(current, *desired)
+	return c.applyDiffs("consumer", name, diffs, func() error {
+		_, err := c.js.UpdateConsumer(c.config.NATS.Stream, desired)
+		return err
+	})
+}
+
+// applyDiffs runs update when there are differences, or only logs them in
+// bind-only mode. A rejected update fails startup with the full diff.
+func (c *Consumer) applyDiffs(kind, name string, diffs []string, update func() error) error {
+	log := c.logger.WithField(kind, name)
+	if len(diffs) == 0 {
+		log.Infof("JetStream %s is up to date", kind)
+		return nil
+	}
+	if c.config.NATS.BindOnly {
+		log.WithField("differences", diffs).Warnf("JetStream %s differs from the worker config, bind-only mode leaves it unchanged", kind)
+		return nil
+	}
+
+	log.WithField("changes", diffs).Infof("Updating JetStream %s", kind)
+	if err := update(); err != nil {
+		return fmt.Errorf("failed to update %s %s (%s): %w", kind, name, strings.Join(diffs, "; "), err)
+	}
+	return nil
+}
+
+// configDiff collects human-readable differences between two definitions
+type configDiff []string
+
+func (d *configDiff) add(field string, from, to any) {
+	if fmt.Sprint(from) != fmt.Sprint(to) {
+		*d = append(*d, fmt.Sprintf("%s: %v -> %v", field, from, to))
+	}
+}
+
+func diffStream(current, desired nats.StreamConfig) []string {
+	var diffs configDiff
+	diffs.add("subjects", current.Subjects, desired.Subjects)
+	diffs.add("replicas", current.Replicas, desired.Replicas)
+	diffs.add("storage", current.Storage, desired.Storage)
+	diffs.add("max_age", current.MaxAge, desired.MaxAge)
+	diffs.add("max_bytes", current.MaxBytes, desired.MaxBytes)
+	return diffs
+}
+
+func diffConsumer(current, desired nats.ConsumerConfig) []string {
+	var diffs configDiff
+	diffs.add("filter_subject", current.FilterSubject, desired.FilterSubject)
+	diffs.add("ack_wait", current.AckWait, desired.AckWait)
+	diffs.add("max_deliver", current.MaxDeliver, desired.MaxDeliver)
+	diffs.add("max_ack_pending", current.MaxAckPending, desired.MaxAckPending)
+	diffs.add("backoff", current.BackOff, desired.BackOff)
+	return diffs
+}
+
+func storageType(name string) nats.StorageType {
+	if name == "memory" {
+		return nats.MemoryStorage
+	}
+	return nats.FileStorage
+}
+
+func seconds(values []int) []time.Duration {
+	if len(values) == 0 {
+		return nil
+	}
+	durations := make([]time.Duration, len(values))
+	for i, value := range values {
+		durations[i] = time.Duration(value) * time.Second
+	}
+	return durations
+}
+
+// backoffDelay returns the backoff for a delivery attempt, or 0 without a
+// backoff list; the last value repeats. JetStream uses the same value as the
+// ack wait of that delivery.
+func backoffDelay(backoff []int, attempt int) time.Duration {
+	if len(backoff) == 0 {
+		return 0
+	}
+	return time.Duration(backoff[min(attempt, len(backoff))-1]) * time.Second
+}
@@ -0,0 +1,356 @@
+package nats
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/Tungwong-Project/tungwong-video-worker/configs"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// existingJetStream serves info for existing streams and durables; other calls panic
+type existingJetStream struct {
+	nats.JetStreamContext
+	durables map[string]string // Filter subject by durable
+	missing  string            // Stream that does not exist
+}
+
+func (js *existingJetStream) StreamInfo(stream string, opts ...nats.JSOpt) (*nats.StreamInfo, error) {
+	if stream == js.missing {
+		return nil, nats.ErrStreamNotFound
+	}
+	return &nats.StreamInfo{Config: nats.StreamConfig{Name: stream, Subjects: []string{">"}}}, nil
+}
+
+func (js *existingJetStream) ConsumerInfo(stream, durable string, opts ...nats.JSOpt) (*nats.ConsumerInfo, error) {
+	subject, ok := js.durables[durable]
+	if !ok {
+		return nil, nats.ErrConsumerNotFound
+	}
+	return &nats.ConsumerInfo{Config: nats.ConsumerConfig{Durable: durable, FilterSubject: subject, AckPolicy: nats.AckExplicitPolicy}}, nil
+}
+
+func TestProvisionBindOnlyWithoutReencodeDurable(t *testing.T) {
+	config, err := configs.LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	config.NATS.BindOnly = true
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	tests := []struct {
+		name      string
+		durables  map[string]string
+		wantLanes []string
+		wantErr   bool
+	}{
+		{
+			name:      "all durables",
+			durables:  map[string]string{config.NATS.Durable: config.NATS.Subject, config.NATS.Durable + "-reencode": config.NATS.ReencodeSubject},
+			wantLanes: []string{models.PriorityNormal, laneReencode},
+		},
+		{
+			name:      "re-encode durable missing",
+			durables:  map[string]string{config.NATS.Durable: config.NATS.Subject},
+			wantLanes: []string{models.PriorityNormal},
+		},
+		{
+			name:     "job durable missing",
+			durables: map[string]string{config.NATS.Durable + "-reencode": config.NATS.ReencodeSubject},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Consumer{js: &existingJetStream{durables: tt.durables}, config: config, lanes: newLanes(config.NATS), logger: logger}
+			err := c.provision()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error for the missing job durable")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("provision: %v", err)
+			}
+			var lanes []string
+			for _, l := range c.lanes {
+				lanes = append(lanes, l.priority)
+			}
+			if !slices.Equal(lanes, tt.wantLanes) {
+				t.Fatalf("lanes = %v, want %v", lanes, tt.wantLanes)
+			}
+		})
+	}
+}
+
+func TestProvisionBindOnlyWithoutEventsStream(t *testing.T) {
+	config, err := configs.LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	config.NATS.BindOnly = true
+	config.NATS.ReencodeSubject = ""
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	js := &existingJetStream{durables: map[string]string{config.NATS.Durable: config.NATS.Subject}, missing: config.NATS.EventsStream}
+	c := &Consumer{js: js, config: config, lanes: newLanes(config.NATS), logger: logger}
+	if err := c.provision(); err != nil {
+		t.Fatalf("provision: %v", err)
+	}
+	if !c.eventsOff {
+		t.Fatal("events stay on without a stream to capture them")
+	}
+
+	js.missing = config.NATS.DeadLetterStream
+	c = &Consumer{js: js, config: config, lanes: newLanes(config.NATS), logger: logger}
+	if err := c.provision(); err == nil {
+		t.Fatal("expected an error for the missing dead-letter stream")
+	}
+}
+
+// updatingJetStream records stream and consumer updates, failing them with err
+type updatingJetStream struct {
+	nats.JetStreamContext
+	err       error
+	streams   []*nats.StreamConfig
+	consumers []*nats.ConsumerConfig
+}
+
+func (js *updatingJetStream) UpdateStream(cfg *nats.StreamConfig, opts ...nats.JSOpt) (*nats.StreamInfo, error) {
+	js.streams = append(js.streams, cfg)
+	return &nats.StreamInfo{Config: *cfg}, js.err
+}
+
+func (js *updatingJetStream) UpdateConsumer(stream string, cfg *nats.ConsumerConfig, opts ...nats.JSOpt) (*nats.ConsumerInfo, error) {
+	js.consumers = append(js.consumers, cfg)
+	return &nats.ConsumerInfo{Config: *cfg}, js.err
+}
+
+func TestReconcileStream(t *testing.T) {
+	config, err := configs.LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	lanes := newLanes(config.NATS)
+	var subjects []string
+	for _, l := range lanes {
+		subjects = append(subjects, l.subject)
+	}
+	current := nats.StreamConfig{
+		Name:        config.NATS.Stream,
+		Description: "owned by the API",
+		Subjects:    subjects,
+		Retention:   nats.WorkQueuePolicy,
+		Storage:     nats.FileStorage,
+		Replicas:    config.NATS.StreamReplicas,
+		MaxAge:      time.Duration(config.NATS.StreamMaxAge) * time.Second,
+		MaxBytes:    int64(config.NATS.StreamMaxBytes),
+	}
+
+	tests := []struct {
+		name       string
+		bindOnly   bool
+		current    func(nats.StreamConfig) nats.StreamConfig
+		updateErr  error
+		wantUpdate bool
+		wantErr    string
+	}{
+		{
+			name:    "up to date",
+			current: func(s nats.StreamConfig) nats.StreamConfig { return s },
+		},
+		{
+			name:       "differs",
+			current:    func(s nats.StreamConfig) nats.StreamConfig { s.MaxAge = time.Hour; return s },
+			wantUpdate: true,
+		},
+		{
+			name:       "missing lane subject",
+			current:    func(s nats.StreamConfig) nats.StreamConfig { s.Subjects = []string{"other.subject"}; return s },
+			wantUpdate: true,
+		},
+		{
+			name:     "differs in bind-only mode",
+			bindOnly: true,
+			current:  func(s nats.StreamConfig) nats.StreamConfig { s.MaxAge = time.Hour; return s },
+		},
+		{
+			name:    "storage mismatch",
+			current: func(s nats.StreamConfig) nats.StreamConfig { s.Storage = nats.MemoryStorage; return s },
+			wantErr: "storage cannot change in place",
+		},
+		{
+			name:     "storage mismatch in bind-only mode",
+			bindOnly: true,
+			current:  func(s nats.StreamConfig) nats.StreamConfig { s.Storage = nats.MemoryStorage; return s },
+		},
+		{
+			name:       "update rejected",
+			current:    func(s nats.StreamConfig) nats.StreamConfig { s.Replicas = 3; return s },
+			updateErr:  errors.New("replicas cannot be changed"),
+			wantUpdate: true,
+			wantErr:    "replicas: 3 -> 1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := *config
+			config.NATS.BindOnly = tt.bindOnly
+			js := &updatingJetStream{err: tt.updateErr}
+			c := &Consumer{js: js, config: &config, lanes: lanes, logger: logger}
+
+			err := c.reconcileStream(tt.current(current))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("reconcileStream: %v", err)
+			}
+			if got := len(js.streams) > 0; got != tt.wantUpdate {
+				t.Fatalf("updated = %v, want %v", got, tt.wantUpdate)
+			}
+			if !tt.wantUpdate {
+				return
+			}
+			update := js.streams[0]
+			if update.Description != current.Description || update.Retention != nats.WorkQueuePolicy {
+				t.Errorf("update dropped fields the worker does not manage: %+v", update)
+			}
+			if update.MaxAge != current.MaxAge || update.Replicas != current.Replicas {
+				t.Errorf("update does not restore the configured limits: %+v", update)
+			}
+			for _, subject := range subjects {
+				if !slices.Contains(update.Subjects, subject) {
+					t.Errorf("update subjects %v miss %s", update.Subjects, subject)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileConsumer(t *testing.T) {
+	config, err := configs.LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	l := newLanes(config.NATS)[0]
+
+	current := func(config *configs.Config) nats.ConsumerConfig {
+		c := &Consumer{config: config}
+		return *c.consumerConfig(nats.ConsumerConfig{Description: "owned by the API"}, l)
+	}
+
+	tests := []struct {
+		name          string
+		change        func(*configs.Config, *nats.ConsumerConfig)
+		wantAckWait   time.Duration
+		wantDiffs     []string
+		wantErr       string
+		wantNoUpdates bool
+	}{
+		{
+			name:          "up to date",
+			change:        func(*configs.Config, *nats.ConsumerConfig) {},
+			wantAckWait:   600 * time.Second,
+			wantNoUpdates: true,
+		},
+		{
+			name:        "retries raised",
+			change:      func(c *configs.Config, _ *nats.ConsumerConfig) { c.Retry.MaxRetries = 5 },
+			wantAckWait: 600 * time.Second,
+			wantDiffs:   []string{"max_deliver: 4 -> 6"},
+		},
+		{
+			name:        "backoff replaces the ack wait",
+			change:      func(c *configs.Config, _ *nats.ConsumerConfig) { c.NATS.Backoff = []int{30, 120} },
+			wantAckWait: 30 * time.Second,
+			wantDiffs:   []string{"ack_wait: 10m0s -> 30s", "backoff: [] -> [30s 2m0s]"},
+		},
+		{
+			name:    "push consumer",
+			change:  func(_ *configs.Config, cc *nats.ConsumerConfig) { cc.DeliverSubject = "deliver.jobs" },
+			wantErr: "push consumer",
+		},
+		{
+			name:    "no acks",
+			change:  func(_ *configs.Config, cc *nats.ConsumerConfig) { cc.AckPolicy = nats.AckNonePolicy },
+			wantErr: "explicit acks",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := *config
+			existing := current(&config)
+			tt.change(&config, &existing)
+			js := &updatingJetStream{}
+			c := &Consumer{js: js, config: &config, logger: logger}
+
+			err := c.reconcileConsumer(existing, l)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("reconcileConsumer: %v", err)
+			}
+			if tt.wantNoUpdates {
+				if len(js.consumers) != 0 {
+					t.Fatalf("updated an up-to-date consumer: %+v", js.consumers[0])
+				}
+				return
+			}
+			if len(js.consumers) != 1 {
+				t.Fatalf("updates = %d, want 1", len(js.consumers))
+			}
+			update := js.consumers[0]
+			if diffs := diffConsumer(existing, *update); !slices.Equal(diffs, tt.wantDiffs) {
+				t.Errorf("diffs = %q, want %q", diffs, tt.wantDiffs)
+			}
+			if update.AckWait != tt.wantAckWait {
+				t.Errorf("ack wait = %v, want %v", update.AckWait, tt.wantAckWait)
+			}
+			if update.MaxDeliver != config.Retry.MaxRetries+1 {
+				t.Errorf("max deliver = %d, want %d", update.MaxDeliver, config.Retry.MaxRetries+1)
+			}
+			if update.Description != existing.Description {
+				t.Errorf("update dropped the description: %+v", update)
+			}
+		})
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	tests := []struct {
+		backoff []int
+		attempt int
+		want    time.Duration
+	}{
+		{nil, 1, 0},
+		{[]int{30, 120, 600}, 1, 30 * time.Second},
+		{[]int{30, 120, 600}, 2, 2 * time.Minute},
+		{[]int{30, 120, 600}, 3, 10 * time.Minute},
+		{[]int{30, 120, 600}, 7, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := backoffDelay(tt.backoff, tt.attempt); got != tt.want {
+			t.Errorf("backoffDelay(%v, %d) = %v, want %v", tt.backoff, tt.attempt, got, tt.want)
+		}
+	}
+}
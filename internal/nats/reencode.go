@@ -0,0 +1,61 @@
+package nats
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/models"
+	"github.com/Tungwong-Project/tungwong-video-worker/pkg/logger"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// laneReencode is the lane of re-encode requests. It is scheduled with the low
+// lane's weight, so backfilling the catalogue does not hold up new uploads.
+const laneReencode = "reencode"
+
+// decodeReencode parses and validates a re-encode request. Unknown fields are
+// rejected, as in the versioned upload envelope.
+func decodeReencode(data []byte) (*models.ReencodeMessage, *rejection) {
+	var msg models.ReencodeMessage
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&msg); err != nil {
+		return nil, &rejection{code: "malformed", err: fmt.Errorf("malformed re-encode request: %w", err)}
+	}
+	if err := msg.Validate(); err != nil {
+		return nil, &rejection{code: "invalid", err: err}
+	}
+	return &msg, nil
+}
+
+// handleReencode runs a re-encode request from the re-encode lane
+func (c *Consumer) handleReencode(ctx context.Context, span trace.Span, msg *nats.Msg, meta *nats.MsgMetadata, attempt int) error {
+	request, reason := decodeReencode(msg.Data)
+	if reason != nil {
+		c.reject(msg, meta, reason)
+		return reason
+	}
+
+	id := messageID(msg, meta)
+	span.SetAttributes(
+		attribute.String("video.id", request.VideoID),
+		attribute.String("messaging.message.id", id),
+		attribute.Int("messaging.delivery_attempt", attempt),
+	)
+
+	log := c.jobLogger(ctx, request.VideoID, id, attempt)
+	log.WithFields(logrus.Fields{
+		"profile": request.Profile,
+		"reason":  request.Reason,
+		"lane":    laneReencode,
+	}).Info("Processing re-encode request")
+
+	return c.runJob(logger.NewContext(ctx, log), msg, meta, attempt, request.VideoID, "re-encode "+request.Profile, func(ctx context.Context) error {
+		return c.processor.Reencode(ctx, request)
+	})
+}
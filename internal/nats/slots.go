@@ -0,0 +1,64 @@
+package nats
+
+import (
+	"context"
+	"sync"
+)
+
+// slots limits how many jobs run at once. The limit can change while jobs run;
+// lowering it lets running jobs finish and only holds back new ones.
+type slots struct {
+	mu      sync.Mutex
+	limit   int
+	inUse   int
+	changed chan struct{} // Closed and replaced whenever a slot may have freed up
+}
+
+func newSlots(limit int) *slots {
+	return &slots{limit: max(limit, 1), changed: make(chan struct{})}
+}
+
+// Acquire blocks until a slot is free or ctx is done
+func (s *slots) Acquire(ctx context.Context) bool {
+	for {
+		s.mu.Lock()
+		if s.inUse < s.limit {
+			s.inUse++
+			s.mu.Unlock()
+			return true
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-changed:
+		}
+	}
+}
+
+func (s *slots) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inUse--
+	s.broadcast()
+}
+
+func (s *slots) SetLimit(limit int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limit = max(limit, 1)
+	s.broadcast()
+}
+
+func (s *slots) Usage() (inUse, limit int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inUse, s.limit
+}
+
+func (s *slots) broadcast() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
@@ -0,0 +1,36 @@
+package nats
+
+import (
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// headerCarrier adapts NATS message headers for trace context propagation.
+// Unlike HTTP, NATS does not canonicalize header names, so lookups ignore case
+// to accept both "traceparent" and "Traceparent" from publishers.
+type headerCarrier nats.Header
+
+func (c headerCarrier) Get(key string) string {
+	if values := c[key]; len(values) > 0 {
+		return values[0]
+	}
+	for name, values := range c {
+		if strings.EqualFold(name, key) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+func (c headerCarrier) Set(key, value string) {
+	c[key] = []string{value}
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
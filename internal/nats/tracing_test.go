@@ -0,0 +1,48 @@
+package nats
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestHeaderCarrier(t *testing.T) {
+	const (
+		traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+		traceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+	)
+	propagator := propagation.TraceContext{}
+
+	for _, name := range []string{"traceparent", "Traceparent", "TRACEPARENT"} {
+		t.Run(name, func(t *testing.T) {
+			header := nats.Header{}
+			header[name] = []string{traceparent} // Set as published, without canonicalizing
+			ctx := propagator.Extract(context.Background(), headerCarrier(header))
+			if got := trace.SpanContextFromContext(ctx).TraceID().String(); got != traceID {
+				t.Fatalf("trace ID = %s, want %s", got, traceID)
+			}
+		})
+	}
+
+	t.Run("inject", func(t *testing.T) {
+		parent := propagator.Extract(context.Background(), headerCarrier(nats.Header{"traceparent": {traceparent}}))
+		header := nats.Header{}
+		propagator.Inject(parent, headerCarrier(header))
+		if got := header["traceparent"]; len(got) != 1 || got[0] != traceparent {
+			t.Fatalf("injected traceparent = %v, want %s", got, traceparent)
+		}
+		if keys := headerCarrier(header).Keys(); len(keys) != 1 || keys[0] != "traceparent" {
+			t.Fatalf("Keys() = %v", keys)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		ctx := propagator.Extract(context.Background(), headerCarrier(nats.Header{}))
+		if trace.SpanContextFromContext(ctx).IsValid() {
+			t.Fatal("extracted a span context from empty headers")
+		}
+	})
+}
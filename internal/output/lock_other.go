@@ -0,0 +1,34 @@
+//go:build !unix
+
+package output
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// lockTimeout is how long a lock file may be held before it is taken as left
+// behind by a crashed worker
+const lockTimeout = time.Minute
+
+// lockFile takes an exclusive lock by creating path, which every worker
+// sharing the output volume respects
+func lockFile(path string) (unlock func(), err error) {
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > lockTimeout {
+			os.Remove(path)
+			continue
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,274 @@
+package output
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/Tungwong-Project/tungwong-video-worker/configs"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/disk"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/metrics"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// orphanMinAge is the least time version directories the manifest does not
+// list are kept, so encodes still running are left alone
+const orphanMinAge = 24 * time.Hour
+
+// Manifest is the history of a video's output versions, kept next to them
+type Manifest struct {
+	VideoID  string    `json:"video_id"`
+	Versions []Version `json:"versions"` // Oldest first
+}
+
+// Version describes one encode of a video. Versions removed by retention stay
+// in the manifest with removed_at set.
+type Version struct {
+	Version       int                `json:"version"`
+	Status        string             `json:"status"` // Reported to video-management: done, version_ready, or preview when only the preview was
+	Profile       string             `json:"profile"`
+	Codecs        []string           `json:"codecs"`
+	Renditions    []models.Rendition `json:"renditions"`
+	HLSPath       string             `json:"hls_path"`
+	ThumbnailPath string             `json:"thumbnail_path,omitempty"`
+	Duration      int                `json:"duration"` // Video length in seconds
+	CreatedAt     time.Time          `json:"created_at"`
+	WorkerID      string             `json:"worker_id"`
+	Reason        string             `json:"reason,omitempty"`    // Why a re-encode was requested
+	Checksums     map[string]string  `json:"checksums,omitempty"` // SHA-256 of every file, by path in the version directory; thumbnails under thumbnails/
+	Legacy        bool               `json:"legacy,omitempty"`    // Written without versioning, directly in the video's directories
+	RemovedAt     *time.Time         `json:"removed_at,omitempty"`
+}
+
+// Record adds a finished version to the video's manifest, with the codecs of
+// its renditions and checksums of its files
+func (s *Store) Record(videoID string, version Version) error {
+	for _, rendition := range version.Renditions {
+		if !slices.Contains(version.Codecs, rendition.Codec) {
+			version.Codecs = append(version.Codecs, rendition.Codec)
+		}
+	}
+	checksums, err := s.checksums(videoID, version.Version)
+	if err != nil {
+		return err
+	}
+	version.Checksums = checksums
+
+	unlock, err := s.lock(videoID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	manifest, err := s.load(videoID)
+	if err != nil {
+		return err
+	}
+	manifest.Versions = slices.DeleteFunc(manifest.Versions, func(v Version) bool { return v.Version == version.Version })
+	manifest.Versions = append(manifest.Versions, version)
+	sort.Slice(manifest.Versions, func(i, j int) bool { return manifest.Versions[i].Version < manifest.Versions[j].Version })
+	return s.save(manifest)
+}
+
+// Prune removes versions the retention policy no longer keeps: those beyond
+// the newest KeepVersions that are older than MinAge. The newest version
+// reported as done is always kept, since it may be the one being served.
+// version_ready and preview are statuses video-management is not known to act
+// on, so versions reported with them never count as the served one.
+// Version directories the manifest does not list, left by crashed or failed
+// encodes, are removed once nothing was written to them for MinAge, and at
+// least orphanMinAge so running encodes are never touched.
+func (s *Store) Prune(videoID string, retention configs.RetentionConfig) ([]int, error) {
+	unlock, err := s.lock(videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	manifest, err := s.load(videoID)
+	if err != nil {
+		return nil, err
+	}
+
+	minAge := time.Duration(retention.MinAge) * time.Second
+	removed, changed := s.pruneRecorded(manifest, retention.KeepVersions, minAge)
+	removed = append(removed, s.pruneOrphans(manifest, max(minAge, orphanMinAge))...)
+	sort.Ints(removed)
+	if !changed {
+		return removed, nil
+	}
+	return removed, s.save(manifest)
+}
+
+// pruneRecorded removes the recorded versions beyond the newest keep and
+// marks them removed in manifest
+func (s *Store) pruneRecorded(manifest *Manifest, keep int, minAge time.Duration) (removed []int, changed bool) {
+	if keep <= 0 {
+		return nil, false
+	}
+
+	published := 0
+	for _, version := range manifest.Versions {
+		if version.RemovedAt == nil && version.Status == "done" {
+			published = max(published, version.Version)
+		}
+	}
+
+	live := 0
+	for i := len(manifest.Versions) - 1; i >= 0; i-- {
+		version := &manifest.Versions[i]
+		if version.RemovedAt != nil {
+			continue
+		}
+		live++
+		if live <= keep || version.Version == published || time.Since(version.CreatedAt) < minAge {
+			continue
+		}
+
+		if err := s.remove(manifest.VideoID, *version); err != nil {
+			s.logger.WithError(err).WithFields(logrus.Fields{"video_id": manifest.VideoID, "version": version.Version}).Warn("Failed to remove old output version")
+			continue
+		}
+		now := time.Now().UTC()
+		version.RemovedAt = &now
+		removed = append(removed, version.Version)
+		metrics.OutputVersionsRemoved.Inc()
+	}
+	return removed, len(removed) > 0
+}
+
+// pruneOrphans removes version directories manifest does not list once
+// nothing was written to them for minAge
+func (s *Store) pruneOrphans(manifest *Manifest, minAge time.Duration) []int {
+	recorded := make(map[int]bool)
+	for _, version := range manifest.Versions {
+		recorded[version.Version] = true
+	}
+
+	var removed []int
+	for _, n := range s.versionDirs(manifest.VideoID) {
+		if recorded[n] {
+			continue
+		}
+		modified := disk.LastModified(filepath.Join(s.hlsRoot, manifest.VideoID, VersionDir(n)))
+		if thumbnails := disk.LastModified(filepath.Join(s.thumbnailRoot, manifest.VideoID, VersionDir(n))); thumbnails.After(modified) {
+			modified = thumbnails
+		}
+		if time.Since(modified) < minAge {
+			continue
+		}
+
+		log := s.logger.WithFields(logrus.Fields{"video_id": manifest.VideoID, "version": n, "last_modified": modified})
+		if err := s.remove(manifest.VideoID, Version{Version: n}); err != nil {
+			log.WithError(err).Warn("Failed to remove unrecorded output version")
+			continue
+		}
+		log.Info("Removed unrecorded output version")
+		removed = append(removed, n)
+		metrics.OutputVersionsRemoved.Inc()
+	}
+	return removed
+}
+
+// versionDirs lists the version directories of a video under both roots
+func (s *Store) versionDirs(videoID string) []int {
+	seen := make(map[int]bool)
+	var versions []int
+	for _, root := range []string{s.hlsRoot, s.thumbnailRoot} {
+		entries, _ := os.ReadDir(filepath.Join(root, videoID))
+		for _, entry := range entries {
+			if n, ok := parseVersionDir(entry.Name()); ok && entry.IsDir() && !seen[n] {
+				seen[n] = true
+				versions = append(versions, n)
+			}
+		}
+	}
+	return versions
+}
+
+func (s *Store) manifestPath(videoID string) string {
+	return filepath.Join(s.hlsRoot, videoID, ManifestFile)
+}
+
+// load reads the manifest of a video, empty when it has none yet. Callers hold the lock.
+func (s *Store) load(videoID string) (*Manifest, error) {
+	manifest := &Manifest{VideoID: videoID}
+	data, err := os.ReadFile(s.manifestPath(videoID))
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest of video %s: %w", videoID, err)
+	}
+	return manifest, nil
+}
+
+// save replaces the manifest in one atomic step. Callers hold the lock.
+func (s *Store) save(manifest *Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	path := s.manifestPath(manifest.VideoID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// checksums hashes every file a version wrote
+func (s *Store) checksums(videoID string, version int) (map[string]string, error) {
+	sums := make(map[string]string)
+	for prefix, root := range map[string]string{"": s.hlsRoot, "thumbnails/": s.thumbnailRoot} {
+		dir := filepath.Join(root, videoID, VersionDir(version))
+		err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if errors.Is(err, os.ErrNotExist) && path == dir {
+				return filepath.SkipDir // No thumbnails
+			}
+			if err != nil || entry.IsDir() {
+				return err
+			}
+			sum, err := fileChecksum(path)
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(dir, path)
+			sums[prefix+filepath.ToSlash(rel)] = sum
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to checksum version %d: %w", version, err)
+		}
+	}
+	return sums, nil
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
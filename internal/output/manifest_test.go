@@ -0,0 +1,249 @@
+package output
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Tungwong-Project/tungwong-video-worker/configs"
+	"github.com/sirupsen/logrus"
+)
+
+const testVideoID = "video-1"
+
+// testVersion is a version directory set up before Prune runs
+type testVersion struct {
+	version int
+	status  string        // Recorded with this status; empty leaves it out of the manifest
+	age     time.Duration // Age of the record and of the files
+	removed bool          // Already removed by an earlier Prune
+	legacy  bool          // Unversioned output, adopted by Claim
+}
+
+func TestPrune(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		name        string
+		retention   configs.RetentionConfig
+		versions    []testVersion
+		wantRemoved []int
+		wantDirs    []int // Version directories left
+	}{
+		{
+			name:      "keeps newest",
+			retention: configs.RetentionConfig{KeepVersions: 2},
+			versions: []testVersion{
+				{version: 1, status: "done", age: 3 * day},
+				{version: 2, status: "done", age: 2 * day},
+				{version: 3, status: "done", age: day},
+			},
+			wantRemoved: []int{1},
+			wantDirs:    []int{2, 3},
+		},
+		{
+			name:      "keeps all",
+			retention: configs.RetentionConfig{KeepVersions: 0},
+			versions: []testVersion{
+				{version: 1, status: "done", age: 3 * day},
+				{version: 2, status: "done", age: 2 * day},
+			},
+			wantDirs: []int{1, 2},
+		},
+		{
+			name:      "keeps young versions",
+			retention: configs.RetentionConfig{KeepVersions: 1, MinAge: int((36 * time.Hour).Seconds())},
+			versions: []testVersion{
+				{version: 1, status: "done", age: 3 * day},
+				{version: 2, status: "done", age: day},
+				{version: 3, status: "done", age: time.Hour},
+			},
+			wantRemoved: []int{1},
+			wantDirs:    []int{2, 3},
+		},
+		{
+			name:      "keeps newest done",
+			retention: configs.RetentionConfig{KeepVersions: 1},
+			versions: []testVersion{
+				{version: 1, status: "done", age: 3 * day},
+				{version: 2, status: "done", age: 2 * day},
+				{version: 3, status: "version_ready", age: day},
+			},
+			wantRemoved: []int{1},
+			wantDirs:    []int{2, 3},
+		},
+		{
+			name:      "never takes unverified statuses as published",
+			retention: configs.RetentionConfig{KeepVersions: 1},
+			versions: []testVersion{
+				{version: 1, status: "done", age: 4 * day},
+				{version: 2, status: "version_ready", age: 3 * day},
+				{version: 3, status: "preview", age: 2 * day},
+				{version: 4, status: "version_ready", age: day},
+			},
+			wantRemoved: []int{2, 3},
+			wantDirs:    []int{1, 4},
+		},
+		{
+			name:      "skips removed versions",
+			retention: configs.RetentionConfig{KeepVersions: 1},
+			versions: []testVersion{
+				{version: 1, status: "done", age: 3 * day, removed: true},
+				{version: 2, status: "done", age: 2 * day},
+				{version: 3, status: "done", age: day},
+			},
+			wantRemoved: []int{2},
+			wantDirs:    []int{3},
+		},
+		{
+			name:      "sweeps old unrecorded versions",
+			retention: configs.RetentionConfig{KeepVersions: 0},
+			versions: []testVersion{
+				{version: 1, status: "done", age: 3 * day},
+				{version: 2, age: 2 * day},
+				{version: 3, age: time.Hour},
+			},
+			wantRemoved: []int{2},
+			wantDirs:    []int{1, 3},
+		},
+		{
+			name:      "unrecorded versions wait for min age",
+			retention: configs.RetentionConfig{KeepVersions: 1, MinAge: int((3 * day).Seconds())},
+			versions: []testVersion{
+				{version: 1, age: 2 * day},
+				{version: 2, status: "done", age: day},
+			},
+			wantDirs: []int{1, 2},
+		},
+		{
+			name:      "prunes legacy output",
+			retention: configs.RetentionConfig{KeepVersions: 1},
+			versions: []testVersion{
+				{version: 1, age: 3 * day, legacy: true},
+				{version: 2, status: "done", age: day},
+			},
+			wantRemoved: []int{1},
+			wantDirs:    []int{2},
+		},
+		{
+			name:      "keeps legacy output within retention",
+			retention: configs.RetentionConfig{KeepVersions: 2},
+			versions: []testVersion{
+				{version: 1, age: 3 * day, legacy: true},
+				{version: 2, status: "done", age: day},
+			},
+			wantDirs: []int{2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, hlsRoot := setupVersions(t, tt.versions)
+
+			removed, err := store.Prune(testVideoID, tt.retention)
+			if err != nil {
+				t.Fatalf("Prune failed: %v", err)
+			}
+			if !slices.Equal(removed, tt.wantRemoved) {
+				t.Fatalf("removed = %v, want %v", removed, tt.wantRemoved)
+			}
+			if dirs := store.versionDirs(testVideoID); !equalSorted(dirs, tt.wantDirs) {
+				t.Fatalf("version directories = %v, want %v", dirs, tt.wantDirs)
+			}
+
+			manifest, err := store.load(testVideoID)
+			if err != nil {
+				t.Fatalf("failed to load manifest: %v", err)
+			}
+			for _, version := range manifest.Versions {
+				if wantRemoved := slices.Contains(tt.wantRemoved, version.Version); wantRemoved && version.RemovedAt == nil {
+					t.Fatalf("version %d is not marked removed", version.Version)
+				}
+				if version.Legacy {
+					_, err := os.Stat(filepath.Join(hlsRoot, testVideoID, legacyPlaylist))
+					if gone := os.IsNotExist(err); gone != (version.RemovedAt != nil) {
+						t.Fatalf("legacy playlist gone = %v, removed_at = %v", gone, version.RemovedAt)
+					}
+				}
+			}
+		})
+	}
+}
+
+// setupVersions writes the output of versions and records those with a
+// status, adopting legacy output through Claim
+func setupVersions(t *testing.T, versions []testVersion) (*Store, string) {
+	t.Helper()
+	root := t.TempDir()
+	hlsRoot := filepath.Join(root, "hls")
+	store := NewStore(hlsRoot, filepath.Join(root, "thumbnails"), logrus.New())
+	videoDir := filepath.Join(hlsRoot, testVideoID)
+
+	for _, v := range versions {
+		modified := time.Now().Add(-v.age)
+		if v.legacy {
+			writeFile(t, filepath.Join(videoDir, legacyPlaylist), modified)
+			writeFile(t, filepath.Join(videoDir, "720p", "playlist.m3u8"), modified)
+			if _, err := store.Claim(testVideoID); err != nil {
+				t.Fatalf("Claim failed: %v", err)
+			}
+			continue
+		}
+
+		writeFile(t, filepath.Join(videoDir, VersionDir(v.version), "master.m3u8"), modified)
+		if v.status == "" {
+			continue
+		}
+		if err := store.Record(testVideoID, Version{Version: v.version, Status: v.status, CreatedAt: modified}); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+	}
+
+	// Claims made for legacy adoption leave an empty directory behind; drop
+	// those no test version asked for
+	for _, n := range store.versionDirs(testVideoID) {
+		if !slices.ContainsFunc(versions, func(v testVersion) bool { return v.version == n && !v.legacy }) {
+			os.Remove(filepath.Join(videoDir, VersionDir(n)))
+			os.Remove(filepath.Join(store.thumbnailRoot, testVideoID, VersionDir(n)))
+		}
+	}
+
+	manifest, err := store.load(testVideoID)
+	if err != nil {
+		t.Fatalf("failed to load manifest: %v", err)
+	}
+	for i, version := range manifest.Versions {
+		if slices.ContainsFunc(versions, func(v testVersion) bool { return v.version == version.Version && v.removed }) {
+			removedAt := version.CreatedAt.Add(time.Hour)
+			manifest.Versions[i].RemovedAt = &removedAt
+			os.RemoveAll(filepath.Join(videoDir, VersionDir(version.Version)))
+		}
+	}
+	if err := store.save(manifest); err != nil {
+		t.Fatalf("failed to save manifest: %v", err)
+	}
+	return store, hlsRoot
+}
+
+// writeFile creates path and its directory, both last modified at modified
+func writeFile(t *testing.T, path string, modified time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("#EXTM3U\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{path, filepath.Dir(path)} {
+		if err := os.Chtimes(p, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func equalSorted(a, b []int) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
@@ -0,0 +1,180 @@
+package output
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ManifestFile is the name of the manifest in a video's HLS directory
+const ManifestFile = "manifest.json"
+
+// legacyPlaylist is the master playlist of output written without versioning
+const legacyPlaylist = "master.m3u8"
+
+// lockName is the file in a video's HLS directory that serializes claims and
+// manifest updates across workers
+const lockName = ".manifest.lock"
+
+// Store keeps the versioned output of videos, <root>/<video_id>/v<N>/ under
+// the HLS and thumbnail roots, and the manifest describing the versions
+type Store struct {
+	hlsRoot       string
+	thumbnailRoot string
+	logger        logrus.FieldLogger
+
+	mu sync.Mutex // Serializes this worker's claims and manifest updates before they take the lock file
+}
+
+func NewStore(hlsRoot, thumbnailRoot string, logger logrus.FieldLogger) *Store {
+	return &Store{hlsRoot: hlsRoot, thumbnailRoot: thumbnailRoot, logger: logger}
+}
+
+// VersionDir names the directory of output version n
+func VersionDir(n int) string {
+	return fmt.Sprintf("v%d", n)
+}
+
+// parseVersionDir reads the version number from a v<N> directory name
+func parseVersionDir(name string) (int, bool) {
+	digits, ok := strings.CutPrefix(name, "v")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(digits)
+	return n, err == nil && n > 0
+}
+
+// lock serializes claims and manifest updates of a video across all workers
+// sharing the output volume
+func (s *Store) lock(videoID string) (unlock func(), err error) {
+	base := filepath.Join(s.hlsRoot, videoID)
+	if err := os.MkdirAll(base, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	s.mu.Lock()
+	unlockFile, err := lockFile(filepath.Join(base, lockName))
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	return func() {
+		unlockFile()
+		s.mu.Unlock()
+	}, nil
+}
+
+// Claim reserves the next output version of a video by creating its
+// directory, so concurrent encodes never share one. Numbers are never reused,
+// also not those of versions retention removed. Output written by releases
+// without versioning, directly in the video's directory, is recorded as
+// version 1 so retention covers it too.
+func (s *Store) Claim(videoID string) (int, error) {
+	unlock, err := s.lock(videoID)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	base := filepath.Join(s.hlsRoot, videoID)
+	entries, err := os.ReadDir(base)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read output directory: %w", err)
+	}
+	manifest, err := s.load(videoID)
+	if err != nil {
+		return 0, err
+	}
+
+	next := 1
+	for _, entry := range entries {
+		if n, ok := parseVersionDir(entry.Name()); ok && entry.IsDir() {
+			next = max(next, n+1)
+		}
+		if entry.Name() == legacyPlaylist {
+			next = max(next, 2)
+			if err := s.adoptLegacy(manifest, entry); err != nil {
+				return 0, err
+			}
+		}
+	}
+	for _, version := range manifest.Versions {
+		next = max(next, version.Version+1)
+	}
+
+	for ; ; next++ {
+		err := os.Mkdir(filepath.Join(base, VersionDir(next)), 0755)
+		if err == nil {
+			return next, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return 0, fmt.Errorf("failed to create version directory: %w", err)
+		}
+	}
+}
+
+// adoptLegacy records output written without versioning as version 1, once
+func (s *Store) adoptLegacy(manifest *Manifest, playlist os.DirEntry) error {
+	for _, version := range manifest.Versions {
+		if version.Version == 1 {
+			return nil
+		}
+	}
+	info, err := playlist.Info()
+	if err != nil {
+		return fmt.Errorf("failed to stat legacy output: %w", err)
+	}
+
+	legacy := Version{
+		Version:   1,
+		Status:    "done",
+		HLSPath:   filepath.Join(s.hlsRoot, manifest.VideoID, legacyPlaylist),
+		CreatedAt: info.ModTime().UTC(),
+		Legacy:    true,
+	}
+	if thumbnail := filepath.Join(s.thumbnailRoot, manifest.VideoID, "thumbnail.jpg"); fileExists(thumbnail) {
+		legacy.ThumbnailPath = thumbnail
+	}
+	manifest.Versions = append([]Version{legacy}, manifest.Versions...)
+	return s.save(manifest)
+}
+
+// remove deletes what a version wrote under both roots
+func (s *Store) remove(videoID string, version Version) error {
+	var errs []error
+	for _, root := range []string{s.hlsRoot, s.thumbnailRoot} {
+		dir := filepath.Join(root, videoID)
+		if !version.Legacy {
+			errs = append(errs, os.RemoveAll(filepath.Join(dir, VersionDir(version.Version))))
+			continue
+		}
+
+		// Unversioned output sits next to the version directories and the manifest
+		entries, err := os.ReadDir(dir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+		for _, entry := range entries {
+			if _, ok := parseVersionDir(entry.Name()); ok && entry.IsDir() {
+				continue
+			}
+			if entry.Name() == ManifestFile || entry.Name() == lockName {
+				continue
+			}
+			errs = append(errs, os.RemoveAll(filepath.Join(dir, entry.Name())))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,200 @@
+package resources
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Cost is what a job holds while it encodes
+type Cost struct {
+	Threads      int   // CPU threads given to ffmpeg
+	ScratchBytes int64 // Space used under TEMP_PATH
+}
+
+// Budget admits jobs while their combined cost fits the worker's CPU threads
+// and scratch disk quota. Jobs are admitted in arrival order, so a large job
+// is not starved by a stream of small ones; a job larger than the whole
+// budget is admitted once it would run alone.
+type Budget struct {
+	mu           sync.Mutex
+	threads      int
+	scratchBytes int64 // 0 is unlimited
+	usedThreads  int
+	usedScratch  int64
+	queue        []*waiter
+
+	limitGauge   prometheus.Gauge // Reported limit and usage; nil is not reported
+	threadsGauge prometheus.Gauge
+	scratchGauge prometheus.Gauge
+}
+
+// Grant is an admitted cost, capped at the budget, held until Release
+type Grant struct {
+	Cost
+	release func()
+}
+
+// Release returns the cost to the budget; calling it again does nothing
+func (g *Grant) Release() {
+	if g.release != nil {
+		g.release()
+	}
+}
+
+type waiter struct {
+	cost  Cost
+	ready chan struct{} // Closed once admitted
+}
+
+func NewBudget(threads int, scratchBytes int64) *Budget {
+	b := &Budget{
+		limitGauge:   metrics.ThreadBudget,
+		threadsGauge: metrics.ThreadsInUse,
+		scratchGauge: metrics.ScratchBytesReserved,
+	}
+	b.SetLimits(threads, scratchBytes)
+	return b
+}
+
+// NewChunkBudget admits split-encode chunks served for other workers. Their
+// jobs wait on them while holding the job budget, so chunks are kept out of
+// its queue; they use no scratch space of their own.
+func NewChunkBudget(threads int) *Budget {
+	b := &Budget{threadsGauge: metrics.ChunkThreadsInUse}
+	b.SetLimits(threads, 0)
+	return b
+}
+
+// SetLimits changes the budget. Running jobs keep what they hold; lowering it
+// only holds back new jobs.
+func (b *Budget) SetLimits(threads int, scratchBytes int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.threads = max(threads, 1)
+	b.scratchBytes = max(scratchBytes, 0)
+	setGauge(b.limitGauge, float64(b.threads))
+	b.admit()
+}
+
+// TryAcquire takes cost if it fits right now and nobody is waiting ahead
+func (b *Budget) TryAcquire(cost Cost) (*Grant, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cost = b.clamp(cost)
+	if len(b.queue) > 0 || !b.fits(cost) {
+		return nil, false
+	}
+	b.take(cost)
+	return b.grant(cost), true
+}
+
+// Acquire blocks until cost is admitted or ctx is done
+func (b *Budget) Acquire(ctx context.Context, cost Cost) (*Grant, error) {
+	if grant, ok := b.TryAcquire(cost); ok {
+		return grant, nil
+	}
+
+	started := time.Now()
+	b.mu.Lock()
+	w := &waiter{cost: b.clamp(cost), ready: make(chan struct{})}
+	b.queue = append(b.queue, w)
+	b.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		metrics.AdmissionWaitSeconds.Observe(time.Since(started).Seconds())
+		return b.grant(w.cost), nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		select {
+		case <-w.ready:
+			// Admitted while giving up; hand it to the next in line
+			b.give(w.cost)
+		default:
+			b.queue = slices.DeleteFunc(b.queue, func(q *waiter) bool { return q == w })
+			b.admit()
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// Usage returns what running jobs hold and the budget
+func (b *Budget) Usage() (threads, threadLimit int, scratchBytes, scratchLimit int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.usedThreads, b.threads, b.usedScratch, b.scratchBytes
+}
+
+func (b *Budget) grant(cost Cost) *Grant {
+	var once sync.Once
+	return &Grant{Cost: cost, release: func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.give(cost)
+		})
+	}}
+}
+
+// clamp caps cost at the budget so an oversized job can run alone
+func (b *Budget) clamp(cost Cost) Cost {
+	cost.Threads = min(max(cost.Threads, 1), b.threads)
+	if b.scratchBytes > 0 {
+		cost.ScratchBytes = min(cost.ScratchBytes, b.scratchBytes)
+	}
+	return cost
+}
+
+func (b *Budget) fits(cost Cost) bool {
+	if b.usedThreads > 0 && b.usedThreads+cost.Threads > b.threads {
+		return false
+	}
+	if b.scratchBytes > 0 && b.usedScratch > 0 && b.usedScratch+cost.ScratchBytes > b.scratchBytes {
+		return false
+	}
+	return true
+}
+
+func (b *Budget) take(cost Cost) {
+	b.usedThreads += cost.Threads
+	b.usedScratch += cost.ScratchBytes
+	b.report()
+}
+
+func (b *Budget) give(cost Cost) {
+	b.usedThreads -= cost.Threads
+	b.usedScratch -= cost.ScratchBytes
+	b.report()
+	b.admit()
+}
+
+// admit lets waiters in, in order, for as long as the first one fits
+func (b *Budget) admit() {
+	for len(b.queue) > 0 {
+		w := b.queue[0]
+		w.cost = b.clamp(w.cost) // The limits may have changed while it waited
+		if !b.fits(w.cost) {
+			return
+		}
+		b.queue = b.queue[1:]
+		b.take(w.cost)
+		close(w.ready)
+	}
+}
+
+func (b *Budget) report() {
+	setGauge(b.threadsGauge, float64(b.usedThreads))
+	setGauge(b.scratchGauge, float64(b.usedScratch))
+}
+
+func setGauge(gauge prometheus.Gauge, value float64) {
+	if gauge != nil {
+		gauge.Set(value)
+	}
+}
@@ -0,0 +1,170 @@
+package resources
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBudgetTryAcquire(t *testing.T) {
+	tests := []struct {
+		name         string
+		threads      int
+		scratchBytes int64
+		held         []Cost
+		cost         Cost
+		wantOK       bool
+		wantGranted  Cost
+	}{
+		{name: "fits", threads: 8, cost: Cost{Threads: 4}, wantOK: true, wantGranted: Cost{Threads: 4}},
+		{name: "fills the budget", threads: 8, held: []Cost{{Threads: 4}}, cost: Cost{Threads: 4}, wantOK: true, wantGranted: Cost{Threads: 4}},
+		{name: "too many threads", threads: 8, held: []Cost{{Threads: 6}}, cost: Cost{Threads: 4}},
+		{name: "oversized alone is clamped", threads: 8, cost: Cost{Threads: 32}, wantOK: true, wantGranted: Cost{Threads: 8}},
+		{name: "oversized waits for others", threads: 8, held: []Cost{{Threads: 1}}, cost: Cost{Threads: 32}},
+		{name: "at least one thread", threads: 8, cost: Cost{}, wantOK: true, wantGranted: Cost{Threads: 1}},
+		{name: "scratch fits", threads: 8, scratchBytes: 100, held: []Cost{{Threads: 1, ScratchBytes: 60}}, cost: Cost{Threads: 1, ScratchBytes: 40}, wantOK: true, wantGranted: Cost{Threads: 1, ScratchBytes: 40}},
+		{name: "scratch full", threads: 8, scratchBytes: 100, held: []Cost{{Threads: 1, ScratchBytes: 60}}, cost: Cost{Threads: 1, ScratchBytes: 50}},
+		{name: "scratch clamped", threads: 8, scratchBytes: 100, cost: Cost{Threads: 1, ScratchBytes: 500}, wantOK: true, wantGranted: Cost{Threads: 1, ScratchBytes: 100}},
+		{name: "scratch unlimited", threads: 8, held: []Cost{{Threads: 1, ScratchBytes: 1 << 40}}, cost: Cost{Threads: 1, ScratchBytes: 1 << 40}, wantOK: true, wantGranted: Cost{Threads: 1, ScratchBytes: 1 << 40}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBudget(tt.threads, tt.scratchBytes)
+			for _, cost := range tt.held {
+				if _, ok := b.TryAcquire(cost); !ok {
+					t.Fatalf("failed to take held cost %+v", cost)
+				}
+			}
+			grant, ok := b.TryAcquire(tt.cost)
+			if ok != tt.wantOK {
+				t.Fatalf("TryAcquire ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && grant.Cost != tt.wantGranted {
+				t.Fatalf("granted %+v, want %+v", grant.Cost, tt.wantGranted)
+			}
+		})
+	}
+}
+
+func TestBudgetAdmitsInOrder(t *testing.T) {
+	b := NewBudget(4, 0)
+	running, _ := b.TryAcquire(Cost{Threads: 4})
+
+	large := acquireAsync(b, context.Background(), Cost{Threads: 3})
+	waitQueued(t, b, 1)
+	small := acquireAsync(b, context.Background(), Cost{Threads: 1})
+	waitQueued(t, b, 2)
+
+	// A small job must not overtake the large one queued before it
+	if _, ok := b.TryAcquire(Cost{Threads: 1}); ok {
+		t.Fatal("TryAcquire overtook queued jobs")
+	}
+
+	running.Release()
+	largeGrant := receive(t, large)
+	smallGrant := receive(t, small)
+	if threads, _, _, _ := b.Usage(); threads != 4 {
+		t.Fatalf("used threads = %d, want 4", threads)
+	}
+
+	largeGrant.Release()
+	smallGrant.Release()
+	smallGrant.Release() // Releasing twice returns the cost once
+	if threads, _, _, _ := b.Usage(); threads != 0 {
+		t.Fatalf("used threads = %d after release, want 0", threads)
+	}
+}
+
+func TestBudgetAcquireCancel(t *testing.T) {
+	b := NewBudget(4, 0)
+	running, _ := b.TryAcquire(Cost{Threads: 3})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	blocked := acquireAsync(b, ctx, Cost{Threads: 4})
+	waitQueued(t, b, 1)
+	behind := acquireAsync(b, context.Background(), Cost{Threads: 1})
+	waitQueued(t, b, 2)
+
+	// Cancelling the head of the queue lets the job behind it in
+	cancel()
+	if result := <-blocked; !errors.Is(result.err, context.Canceled) {
+		t.Fatalf("cancelled Acquire returned %v, want context.Canceled", result.err)
+	}
+	grant := receive(t, behind)
+	if threads, _, _, _ := b.Usage(); threads != 4 {
+		t.Fatalf("used threads = %d, want 4", threads)
+	}
+
+	grant.Release()
+	running.Release()
+	if threads, _, _, _ := b.Usage(); threads != 0 {
+		t.Fatalf("used threads = %d after release, want 0", threads)
+	}
+}
+
+func TestBudgetSetLimits(t *testing.T) {
+	b := NewBudget(2, 0)
+	running, _ := b.TryAcquire(Cost{Threads: 2})
+	waiting := acquireAsync(b, context.Background(), Cost{Threads: 2})
+	waitQueued(t, b, 1)
+
+	// Raising the budget admits the waiting job next to the running one
+	b.SetLimits(4, 0)
+	grant := receive(t, waiting)
+	if _, limit, _, _ := b.Usage(); limit != 4 {
+		t.Fatalf("thread limit = %d, want 4", limit)
+	}
+
+	// Lowering it keeps what running jobs hold
+	b.SetLimits(1, 0)
+	if threads, _, _, _ := b.Usage(); threads != 4 {
+		t.Fatalf("used threads = %d, want 4", threads)
+	}
+	running.Release()
+	grant.Release()
+}
+
+type acquireResult struct {
+	grant *Grant
+	err   error
+}
+
+func acquireAsync(b *Budget, ctx context.Context, cost Cost) <-chan acquireResult {
+	result := make(chan acquireResult, 1)
+	go func() {
+		grant, err := b.Acquire(ctx, cost)
+		result <- acquireResult{grant, err}
+	}()
+	return result
+}
+
+// waitQueued waits until n jobs wait for the budget
+func waitQueued(t *testing.T, b *Budget, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		b.mu.Lock()
+		queued := len(b.queue)
+		b.mu.Unlock()
+		if queued == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("expected %d queued jobs", n)
+}
+
+func receive(t *testing.T, result <-chan acquireResult) *Grant {
+	t.Helper()
+	select {
+	case r := <-result:
+		if r.err != nil {
+			t.Fatalf("Acquire failed: %v", r.err)
+		}
+		return r.grant
+	case <-time.After(time.Second):
+		t.Fatal("Acquire was not admitted")
+		return nil
+	}
+}
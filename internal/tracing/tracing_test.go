@@ -0,0 +1,45 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestEnd(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+
+	ctx, span := tracer.Start(context.Background(), "encode")
+	if TraceID(ctx) != span.SpanContext().TraceID().String() {
+		t.Fatalf("TraceID = %q, want the span's trace", TraceID(ctx))
+	}
+	End(span, errors.New("ffmpeg exited with status 1"))
+	_, span = tracer.Start(context.Background(), "report")
+	End(span, nil)
+
+	ended := recorder.Ended()
+	if len(ended) != 2 {
+		t.Fatalf("%d spans ended, want 2", len(ended))
+	}
+	failed, succeeded := ended[0], ended[1]
+	if failed.Status().Code != codes.Error || failed.Status().Description != "ffmpeg exited with status 1" {
+		t.Fatalf("failed span status = %+v", failed.Status())
+	}
+	if events := failed.Events(); len(events) != 1 || events[0].Name != "exception" {
+		t.Fatalf("failed span events = %+v, want the recorded error", events)
+	}
+	if succeeded.Status().Code != codes.Unset || len(succeeded.Events()) != 0 {
+		t.Fatalf("successful span status = %+v, events %+v", succeeded.Status(), succeeded.Events())
+	}
+}
+
+func TestTraceIDOutsideTrace(t *testing.T) {
+	if id := TraceID(context.Background()); id != "" {
+		t.Fatalf("TraceID = %q, want empty", id)
+	}
+}
@@ -0,0 +1,125 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Tungwong-Project/tungwong-video-worker/configs"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/ffmpeg"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/jobs"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/models"
+	"github.com/Tungwong-Project/tungwong-video-worker/pkg/logger"
+)
+
+// EventPublisher delivers job lifecycle events to other services
+type EventPublisher interface {
+	PublishEvent(ctx context.Context, event *models.EncodeEvent) error
+}
+
+type noopPublisher struct{}
+
+func (noopPublisher) PublishEvent(context.Context, *models.EncodeEvent) error { return nil }
+
+// SetEventPublisher sends lifecycle events of every job to publisher
+func (p *Processor) SetEventPublisher(publisher EventPublisher) {
+	p.events = publisher
+}
+
+// publishEvent fills in who is running the job and publishes event. Events are
+// best effort: a failed publish is logged and the job carries on.
+func (p *Processor) publishEvent(ctx context.Context, event *models.EncodeEvent) {
+	event.WorkerID = p.store.Current().Worker.ID
+	event.Attempt = 1
+	if job := jobs.FromContext(ctx); job != nil {
+		event.Attempt = job.Attempt
+	}
+	event.Timestamp = time.Now().UTC()
+	event.SetID()
+
+	// Failure events are sent after the job context was cancelled
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := p.events.PublishEvent(ctx, event); err != nil {
+		logger.FromContext(ctx, p.logger).WithError(err).WithField("event", event.Type).Warn("Failed to publish job event")
+	}
+}
+
+// progressEvents passes stage and progress on to the job's tracker and
+// publishes progress events on every stage change and at most once per
+// interval within a stage
+type progressEvents struct {
+	tracker  ffmpeg.Tracker // May be nil
+	publish  func(event *models.EncodeEvent)
+	interval time.Duration
+	videoID  string
+	profile  string
+	version  int
+
+	mu       sync.Mutex
+	stage    string
+	last     time.Time
+	sequence int
+}
+
+func (t *progressEvents) SetStage(stage string) {
+	if t.tracker != nil {
+		t.tracker.SetStage(stage)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stage = stage
+	t.emit(0)
+}
+
+func (t *progressEvents) SetProgress(fraction float64) {
+	if t.tracker != nil {
+		t.tracker.SetProgress(fraction)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if time.Since(t.last) >= t.interval {
+		t.emit(fraction)
+	}
+}
+
+func (t *progressEvents) SetPID(pid int) {
+	if t.tracker != nil {
+		t.tracker.SetPID(pid)
+	}
+}
+
+// newProgressEvents reports the progress of the job in ctx to its tracker and
+// as events
+func (p *Processor) newProgressEvents(ctx context.Context, config *configs.Config, videoID, profile string, version int) *progressEvents {
+	progress := &progressEvents{
+		publish:  func(event *models.EncodeEvent) { p.publishEvent(ctx, event) },
+		interval: time.Duration(config.NATS.EventsProgressInterval) * time.Second,
+		videoID:  videoID,
+		profile:  profile,
+		version:  version,
+	}
+	if job := jobs.FromContext(ctx); job != nil {
+		progress.tracker = job
+	}
+	return progress
+}
+
+// emit publishes in the background so a slow NATS never stalls ffmpeg's
+// progress pipe; the sequence number lets consumers restore the order.
+// Callers hold mu.
+func (t *progressEvents) emit(fraction float64) {
+	t.sequence++
+	t.last = time.Now()
+	go t.publish(&models.EncodeEvent{
+		Type:     models.EventProgress,
+		VideoID:  t.videoID,
+		Profile:  t.profile,
+		Version:  t.version,
+		Stage:    t.stage,
+		Progress: fraction,
+		Sequence: t.sequence,
+	})
+}
@@ -0,0 +1,84 @@
+package worker
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/models"
+)
+
+// publishedEvents collects the events a progressEvents publishes in the background
+type publishedEvents struct {
+	mu     sync.Mutex
+	events []*models.EncodeEvent
+}
+
+func (p *publishedEvents) publish(event *models.EncodeEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, event)
+}
+
+// wait returns the events once n were published, failing after a second
+func (p *publishedEvents) wait(t *testing.T, n int) []*models.EncodeEvent {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		p.mu.Lock()
+		events := append([]*models.EncodeEvent(nil), p.events...)
+		p.mu.Unlock()
+		if len(events) >= n || time.Now().After(deadline) {
+			return events
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestProgressEventsThrottling(t *testing.T) {
+	tests := []struct {
+		name       string
+		interval   time.Duration
+		progress   int // SetProgress calls per stage
+		wantEvents int
+	}{
+		{name: "stage changes only within the interval", interval: time.Hour, progress: 10, wantEvents: 2},
+		{name: "every update without an interval", interval: 0, progress: 3, wantEvents: 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			published := &publishedEvents{}
+			progress := &progressEvents{publish: published.publish, interval: tt.interval, videoID: "video-1", version: 2}
+			for _, stage := range []string{"probe", "encode"} {
+				progress.SetStage(stage)
+				for i := range tt.progress {
+					progress.SetProgress(float64(i+1) / float64(tt.progress))
+				}
+			}
+
+			events := published.wait(t, tt.wantEvents)
+			time.Sleep(10 * time.Millisecond) // Catch events beyond the expected ones
+			events = published.wait(t, tt.wantEvents)
+			if len(events) != tt.wantEvents {
+				t.Fatalf("published %d events, want %d", len(events), tt.wantEvents)
+			}
+			sequences := map[int]bool{}
+			stages := map[string]bool{}
+			for _, event := range events {
+				if event.Type != models.EventProgress || event.VideoID != "video-1" || event.Version != 2 {
+					t.Fatalf("unexpected event %+v", event)
+				}
+				sequences[event.Sequence] = true
+				stages[event.Stage] = true
+			}
+			for sequence := 1; sequence <= tt.wantEvents; sequence++ {
+				if !sequences[sequence] {
+					t.Fatalf("sequences %v, want 1 to %d", sequences, tt.wantEvents)
+				}
+			}
+			if !stages["probe"] || !stages["encode"] {
+				t.Fatalf("stages %v, want an event for each stage change", stages)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package worker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/disk"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+// collectTemp periodically removes staging directories under the temp path
+// that crashed jobs left behind, until ctx is done
+func (w *Worker) collectTemp(ctx context.Context) {
+	paths := w.config.Current().Paths
+	if paths.TempGCInterval <= 0 {
+		return
+	}
+	maxAge := time.Duration(paths.TempMaxAge) * time.Second
+
+	ticker := time.NewTicker(time.Duration(paths.TempGCInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		w.sweepTemp(paths.TempPath, maxAge)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweepTemp removes the job directories under root, <video_id>/v<N>, that no
+// job on this worker works in and where nothing was written for maxAge. A
+// video's directory goes once its last job directory is gone.
+func (w *Worker) sweepTemp(root string, maxAge time.Duration) {
+	videos, err := os.ReadDir(root)
+	if err != nil {
+		w.logger.WithError(err).WithField("path", root).Warn("Failed to list temp directory")
+		return
+	}
+
+	for _, video := range videos {
+		if !video.IsDir() {
+			continue
+		}
+		videoDir := filepath.Join(root, video.Name())
+		entries, err := os.ReadDir(videoDir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			// Directly below the video's directory is the layout of older releases
+			dir := filepath.Join(videoDir, entry.Name())
+			if w.encoder.WorkDirInUse(dir) {
+				continue
+			}
+			w.removeStale(dir, maxAge)
+		}
+		os.Remove(videoDir) // Fails while job directories are left
+	}
+}
+
+// removeStale removes path when nothing was written below it for maxAge
+func (w *Worker) removeStale(path string, maxAge time.Duration) {
+	modified := disk.LastModified(path)
+	if time.Since(modified) < maxAge {
+		return
+	}
+
+	log := w.logger.WithFields(logrus.Fields{
+		"path":          path,
+		"last_modified": modified,
+	})
+	if err := os.RemoveAll(path); err != nil {
+		log.WithError(err).Warn("Failed to remove orphaned temp directory")
+		return
+	}
+	metrics.TempDirsCollected.Inc()
+	log.Info("Removed orphaned temp directory")
+}
@@ -0,0 +1,59 @@
+package worker
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Tungwong-Project/tungwong-video-worker/configs"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/ffmpeg"
+	"github.com/sirupsen/logrus"
+)
+
+func TestSweepTemp(t *testing.T) {
+	root := t.TempDir()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	w := &Worker{encoder: ffmpeg.NewEncoder(&configs.FFmpegConfig{}, &configs.PathsConfig{TempPath: root}, logger), logger: logger}
+
+	old := time.Now().Add(-48 * time.Hour)
+	mkdir := func(path string, modified time.Time) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Join(root, path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		file := filepath.Join(root, path, "segment.ts")
+		if err := os.WriteFile(file, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range []string{file, filepath.Join(root, path)} {
+			if err := os.Chtimes(p, modified, modified); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	mkdir("orphaned/v1", old)      // Crashed job
+	mkdir("active/v1", old)        // Stale version of a video ...
+	mkdir("active/v2", time.Now()) // ... with a job still writing
+	mkdir("legacy/chunks", old)    // Layout of older releases
+	if err := os.WriteFile(filepath.Join(root, "stray.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w.sweepTemp(root, 24*time.Hour)
+
+	for path, wantExists := range map[string]bool{
+		"orphaned":  false,
+		"active/v1": false,
+		"active/v2": true,
+		"legacy":    false,
+		"stray.txt": true,
+	} {
+		_, err := os.Stat(filepath.Join(root, path))
+		if exists := err == nil; exists != wantExists {
+			t.Errorf("%s exists = %v, want %v", path, exists, wantExists)
+		}
+	}
+}
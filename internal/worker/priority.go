@@ -0,0 +1,48 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/models"
+	"github.com/Tungwong-Project/tungwong-video-worker/pkg/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// Priority picks the lane of a job that does not name a priority from the
+// probed duration of its source: short uploads go to the high lane and long
+// ones to the low lane. Without rules, or when probing fails, the job stays
+// where it is.
+func (p *Processor) Priority(ctx context.Context, msg *models.VideoUploadMessage) string {
+	config := p.store.Current()
+	rules := config.Priority
+	if rules.ShortMaxDuration == 0 && rules.LongMinDuration == 0 {
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	probe, err := p.encoder.Probe(ctx, sourcePath(config, msg))
+	if err != nil {
+		logger.FromContext(ctx, p.logger).WithError(err).WithField("video_id", msg.VideoID).Warn("Failed to probe source for its priority")
+		return ""
+	}
+
+	duration := probe.Duration()
+	priority := models.PriorityNormal
+	switch {
+	case duration <= 0:
+		return ""
+	case rules.ShortMaxDuration > 0 && duration <= float64(rules.ShortMaxDuration):
+		priority = models.PriorityHigh
+	case rules.LongMinDuration > 0 && duration >= float64(rules.LongMinDuration):
+		priority = models.PriorityLow
+	}
+
+	p.logger.WithFields(logrus.Fields{
+		"video_id": msg.VideoID,
+		"duration": duration,
+		"priority": priority,
+	}).Debug("Picked priority from source duration")
+	return priority
+}
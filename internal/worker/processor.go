@@ -4,32 +4,49 @@ import (
 	"context"
 	"fmt"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/Tungwong-Project/tungwong-video-worker/configs"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/disk"
 	"github.com/Tungwong-Project/tungwong-video-worker/internal/ffmpeg"
 	"github.com/Tungwong-Project/tungwong-video-worker/internal/grpc"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/jobs"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/metrics"
 	"github.com/Tungwong-Project/tungwong-video-worker/internal/models"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/output"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/tracing"
+	"github.com/Tungwong-Project/tungwong-video-worker/pkg/logger"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Processor struct {
 	encoder      *ffmpeg.Encoder
+	output       *output.Store
 	grpcClient   *grpc.VideoManagementClient
-	config       *configs.Config
+	store        *configs.Store
+	events       EventPublisher
 	logger       *logrus.Logger
+	retryMu      sync.Mutex
 	retryTracker map[string]int // Track retry counts per video
 }
 
 func NewProcessor(
 	encoder *ffmpeg.Encoder,
+	outputs *output.Store,
 	grpcClient *grpc.VideoManagementClient,
-	config *configs.Config,
+	store *configs.Store,
 	logger *logrus.Logger,
 ) *Processor {
 	return &Processor{
 		encoder:      encoder,
+		output:       outputs,
 		grpcClient:   grpcClient,
-		config:       config,
+		store:        store,
+		events:       noopPublisher{},
 		logger:       logger,
 		retryTracker: make(map[string]int),
 	}
@@ -37,80 +54,375 @@ func NewProcessor(
 
 // Process handles the complete video processing workflow
 func (p *Processor) Process(ctx context.Context, msg *models.VideoUploadMessage) error {
+	log := logger.FromContext(ctx, p.logger)
 	videoID := msg.VideoID
+	config := p.store.Current() // Settings reloaded mid-job apply from the next job
+	started := time.Now()
 
-	p.logger.WithFields(logrus.Fields{
+	log.WithFields(logrus.Fields{
 		"video_id": videoID,
 		"title":    msg.Title,
 		"file":     msg.FileName,
 	}).Info("Starting video processing")
 
+	metrics.JobsStarted.Inc()
+	metrics.JobsInFlight.Inc()
+	defer metrics.JobsInFlight.Dec()
+
 	// Step 1: Mark video as processing (heartbeat to prevent timeout)
 	if err := p.grpcClient.MarkVideoProcessing(ctx, videoID); err != nil {
-		p.logger.WithError(err).Error("Failed to mark video as processing")
+		log.WithError(err).Error("Failed to mark video as processing")
 		// Continue anyway - this is just a heartbeat
 	}
 
-	// Step 2: Build input file path
-	inputPath := filepath.Join(p.config.Paths.InputVideoPath, filepath.Base(msg.UploadFilePath))
+	// Step 2: Build input file path, which an earlier attempt may have archived
+	inputPath := locateSource(config, videoID, sourcePath(config, msg))
+
+	profile, ok := config.Profile(msg.Profile)
+	if !ok && msg.Profile != "" {
+		log.WithFields(logrus.Fields{
+			"video_id": videoID,
+			"profile":  msg.Profile,
+			"default":  profile.Name,
+		}).Warn("Unknown encoding profile, using default")
+	}
 
-	// Step 3: Encode video to HLS
-	result, err := p.encoder.EncodeToHLS(inputPath, videoID)
+	// Every encode writes a new output version, so a retry or re-upload never
+	// overwrites what is being served
+	version, err := p.output.Claim(videoID)
 	if err != nil {
-		return p.handleFailure(ctx, videoID, err, "ENCODING_FAILED")
+		return p.handleFailure(ctx, videoID, profile.Name, 0, nil, err, "OUTPUT_FAILED")
+	}
+
+	p.publishEvent(ctx, &models.EncodeEvent{Type: models.EventStarted, VideoID: videoID, Profile: profile.Name, Version: version})
+
+	opts := ffmpeg.EncodeOptions{Profile: profile, Version: version}
+	job := jobs.FromContext(ctx)
+	opts.Tracker = p.newProgressEvents(ctx, config, videoID, profile.Name, version)
+	for _, sub := range msg.Subtitles {
+		opts.Subtitles = append(opts.Subtitles, ffmpeg.SubtitleInput{
+			Path:     locateArchived(config, videoID, filepath.Join(config.Paths.InputVideoPath, filepath.Base(sub.FilePath))),
+			Language: sub.Language,
+			Label:    sub.Label,
+			Default:  sub.Default,
+		})
+	}
+
+	// Step 3: Publish a fast preview first so the video is watchable early
+	var preview *ffmpeg.EncodeResult
+	if previewProfile, ok := previewProfile(config, profile); ok {
+		preview = p.publishPreview(ctx, inputPath, videoID, previewProfile, version, opts.Tracker)
+	}
+
+	// Step 4: Encode video to HLS
+	encodeStart := time.Now()
+	encodeCtx, span := tracing.Tracer().Start(ctx, "encode", trace.WithAttributes(
+		attribute.String("video.id", videoID),
+		attribute.String("profile", profile.Name),
+		attribute.Int("version", version),
+	))
+	result, err := p.encoder.EncodeToHLS(encodeCtx, inputPath, videoID, opts)
+	tracing.End(span, err)
+	if err != nil {
+		if cancelled, requeue := job.Cancelled(); cancelled {
+			return p.handleCancel(ctx, videoID, profile.Name, version, preview, requeue)
+		}
+		errorCode := "ENCODING_FAILED"
+		if disk.IsFull(err) {
+			errorCode = "DISK_FULL"
+		}
+		return p.handleFailure(ctx, videoID, profile.Name, version, preview, err, errorCode)
+	}
+	recordEncodeMetrics(result, time.Since(encodeStart))
+
+	// Step 5: Update video status to done, naming where the original will be
+	if job != nil {
+		job.SetStage("report")
+	}
+	planned := plannedSource(config, videoID, inputPath)
+	if err := p.reportSuccess(ctx, videoID, version, profile.Name, result, planned); err != nil {
+		if preview != nil {
+			// The master playlist reported with the preview now lists the full ladder
+			p.recordVersion(ctx, config, videoID, output.Version{
+				Version:    version,
+				Status:     "preview",
+				Profile:    profile.Name,
+				Renditions: result.Renditions,
+			}, result)
+		} else {
+			p.encoder.CleanupVersion(videoID, version)
+		}
+		p.publishFailed(ctx, videoID, profile.Name, version, err, "STATUS_UPDATE_FAILED")
+		return err
+	}
+	p.recordVersion(ctx, config, videoID, output.Version{
+		Version:    version,
+		Status:     "done",
+		Profile:    profile.Name,
+		Renditions: result.Renditions,
+	}, result)
+
+	// Step 6: Keep, delete, archive or transcode the original as configured
+	source := p.handleSource(ctx, config, videoID, inputPath, opts)
+	if source != planned {
+		p.correctSource(ctx, videoID, version, profile.Name, result, source)
 	}
 
-	// Step 4: Update video status to done
+	p.publishEvent(ctx, &models.EncodeEvent{
+		Type:          models.EventCompleted,
+		VideoID:       videoID,
+		Profile:       profile.Name,
+		Version:       version,
+		HLSPath:       result.HLSPath,
+		ThumbnailPath: result.ThumbnailPath,
+		Duration:      result.Duration,
+		EncodeSeconds: time.Since(started).Seconds(),
+		Renditions:    result.Renditions,
+		SourceAction:  source.Action,
+		SourcePath:    source.Path,
+	})
+	metrics.JobsSucceeded.Inc()
+	log.WithField("video_id", videoID).Info("Video processing completed successfully")
+	return nil
+}
+
+// reportSuccess updates the video status to done, retrying failed calls
+func (p *Processor) reportSuccess(ctx context.Context, videoID string, version int, profile string, result *ffmpeg.EncodeResult, source sourceOutcome) (err error) {
+	log := logger.FromContext(ctx, p.logger)
+	ctx, span := tracing.Tracer().Start(ctx, "report")
+	defer func() { tracing.End(span, err) }()
+
+	completion := grpc.Completion{SourceAction: source.Action, SourcePath: source.Path, Version: version, Profile: profile}
 	err = p.grpcClient.UpdateVideoStatus(
 		ctx,
 		videoID,
 		result.HLSPath,
 		result.ThumbnailPath,
 		result.Duration,
+		completion,
 	)
 	if err != nil {
-		p.logger.WithError(err).Error("Failed to update video status, but encoding succeeded")
+		log.WithError(err).Error("Failed to update video status, but encoding succeeded")
 		// Retry the gRPC call
 		err = p.grpcClient.WithRetry(ctx, func() error {
-			return p.grpcClient.UpdateVideoStatus(ctx, videoID, result.HLSPath, result.ThumbnailPath, result.Duration)
+			return p.grpcClient.UpdateVideoStatus(ctx, videoID, result.HLSPath, result.ThumbnailPath, result.Duration, completion)
 		}, 3)
 		if err != nil {
+			metrics.JobsFailed.WithLabelValues("STATUS_UPDATE_FAILED").Inc()
 			return fmt.Errorf("failed to update video status after retries: %w", err)
 		}
 	}
-
-	p.logger.WithField("video_id", videoID).Info("Video processing completed successfully")
 	return nil
 }
 
+// correctSource reports where the original ended up when the source action
+// did not go as reportSuccess announced. The video is already done, so a
+// failed report is only logged.
+func (p *Processor) correctSource(ctx context.Context, videoID string, version int, profile string, result *ffmpeg.EncodeResult, source sourceOutcome) {
+	log := logger.FromContext(ctx, p.logger).WithFields(logrus.Fields{
+		"video_id": videoID,
+		"action":   source.Action,
+		"path":     source.Path,
+	})
+
+	completion := grpc.Completion{SourceAction: source.Action, SourcePath: source.Path, Version: version, Profile: profile}
+	err := p.grpcClient.WithRetry(ctx, func() error {
+		return p.grpcClient.UpdateVideoStatus(ctx, videoID, result.HLSPath, result.ThumbnailPath, result.Duration, completion)
+	}, 3)
+	if err != nil {
+		log.WithError(err).Error("Failed to report where the source file was kept")
+		return
+	}
+	log.Info("Reported where the source file was kept")
+}
+
+// recordVersion adds a reported version to the video's manifest and prunes
+// the versions retention no longer keeps. The version is already live, so
+// failures are only logged.
+func (p *Processor) recordVersion(ctx context.Context, config *configs.Config, videoID string, version output.Version, result *ffmpeg.EncodeResult) {
+	log := logger.FromContext(ctx, p.logger).WithFields(logrus.Fields{
+		"video_id": videoID,
+		"version":  version.Version,
+	})
+
+	version.HLSPath = result.HLSPath
+	version.ThumbnailPath = result.ThumbnailPath
+	version.Duration = result.Duration
+	version.CreatedAt = time.Now().UTC()
+	version.WorkerID = config.Worker.ID
+	if err := p.output.Record(videoID, version); err != nil {
+		log.WithError(err).Warn("Failed to record output version in manifest")
+		return
+	}
+
+	removed, err := p.output.Prune(videoID, config.Retention)
+	if err != nil {
+		log.WithError(err).Warn("Failed to prune old output versions")
+	}
+	if len(removed) > 0 {
+		log.WithField("removed", removed).Info("Removed old output versions")
+	}
+}
+
+func recordEncodeMetrics(result *ffmpeg.EncodeResult, elapsed time.Duration) {
+	metrics.EncodeDuration.Observe(elapsed.Seconds())
+	if elapsed > 0 && result.Duration > 0 {
+		metrics.EncodeSpeed.Observe(float64(result.Duration) / elapsed.Seconds())
+	}
+	for output, bytes := range result.OutputBytes {
+		metrics.OutputBytes.WithLabelValues(output).Add(float64(bytes))
+	}
+}
+
+// previewProfile returns the preview profile if a preview pass is configured
+// and worthwhile
+func previewProfile(config *configs.Config, profile configs.EncodingProfile) (configs.EncodingProfile, bool) {
+	preview := config.Profiles.Preview
+	if preview == "" || preview == profile.Name {
+		return configs.EncodingProfile{}, false
+	}
+	previewProfile, ok := config.Profiles.Definitions[preview]
+	return previewProfile, ok
+}
+
+// publishPreview encodes the preview rendition and reports it as playable,
+// returning the preview once video-management accepted the report. A failed
+// preview is not fatal; the full encode still follows.
+func (p *Processor) publishPreview(ctx context.Context, inputPath, videoID string, previewProfile configs.EncodingProfile, version int, tracker ffmpeg.Tracker) *ffmpeg.EncodeResult {
+	log := logger.FromContext(ctx, p.logger)
+
+	ctx, span := tracing.Tracer().Start(ctx, "preview", trace.WithAttributes(attribute.String("profile", previewProfile.Name)))
+	defer span.End()
+
+	result, err := p.encoder.EncodePreview(ctx, inputPath, videoID, ffmpeg.EncodeOptions{Profile: previewProfile, Version: version, Tracker: tracker})
+	if err != nil {
+		span.RecordError(err)
+		log.WithError(err).WithField("video_id", videoID).Warn("Failed to encode preview, continuing with full encode")
+		return nil
+	}
+
+	if err := p.grpcClient.MarkVideoPreviewReady(ctx, videoID, result.HLSPath, result.ThumbnailPath, result.Duration); err != nil {
+		log.WithError(err).WithField("video_id", videoID).Warn("Failed to report preview as playable")
+		return nil
+	}
+	return result
+}
+
+// discardVersion removes what a failed encode wrote. A preview reported as
+// playable stays, since video-management may be serving it and there is no
+// status to withdraw it; the version is recorded so retention prunes it later.
+func (p *Processor) discardVersion(ctx context.Context, videoID, profile string, version int, preview *ffmpeg.EncodeResult) {
+	if preview == nil {
+		p.encoder.CleanupVersion(videoID, version)
+		return
+	}
+	p.encoder.CleanupExceptPreview(videoID, version)
+	p.recordVersion(ctx, p.store.Current(), videoID, output.Version{Version: version, Status: "preview", Profile: profile}, preview)
+	logger.FromContext(ctx, p.logger).WithFields(logrus.Fields{
+		"video_id": videoID,
+		"version":  version,
+	}).Info("Kept the reported preview of the failed encode")
+}
+
 // handleFailure reports failure to video-management API
-func (p *Processor) handleFailure(ctx context.Context, videoID string, err error, errorCode string) error {
-	p.logger.WithError(err).WithField("video_id", videoID).Error("Video processing failed")
+func (p *Processor) handleFailure(ctx context.Context, videoID, profile string, version int, preview *ffmpeg.EncodeResult, err error, errorCode string) error {
+	log := logger.FromContext(ctx, p.logger)
+	log.WithError(err).WithField("video_id", videoID).Error("Video processing failed")
+	metrics.JobsFailed.WithLabelValues(errorCode).Inc()
 
 	// Track retry count
+	p.retryMu.Lock()
 	retryCount := p.retryTracker[videoID]
 	p.retryTracker[videoID] = retryCount + 1
+	p.retryMu.Unlock()
 
-	// Cleanup partial files
-	p.encoder.Cleanup(videoID)
+	// Cleanup partial files, leaving earlier versions and a reported preview alone
+	if version > 0 {
+		p.discardVersion(ctx, videoID, profile, version, preview)
+	}
 
-	// Report failure to video-management
+	p.publishFailed(ctx, videoID, profile, version, err, errorCode)
+
+	// Report failure to video-management, with ffmpeg's own explanation if it failed
+	reason := err.Error()
+	if tail := ffmpeg.StderrTail(err); len(tail) > 0 {
+		reason += "\nffmpeg output:\n" + strings.Join(tail, "\n")
+	}
 	shouldRetry, grpcErr := p.grpcClient.HandleVideoFailure(
 		ctx,
 		videoID,
-		err.Error(),
+		reason,
 		errorCode,
 		retryCount,
 	)
 	if grpcErr != nil {
-		p.logger.WithError(grpcErr).Error("Failed to report video failure to management API")
+		log.WithError(grpcErr).Error("Failed to report video failure to management API")
 		return fmt.Errorf("processing failed and couldn't report: %w", err)
 	}
 
 	if !shouldRetry {
-		p.logger.WithField("video_id", videoID).Info("Max retries reached, video marked as permanently failed")
+		log.WithField("video_id", videoID).Info("Max retries reached, video marked as permanently failed")
+		p.retryMu.Lock()
 		delete(p.retryTracker, videoID) // Clean up tracker
+		p.retryMu.Unlock()
 	}
 
 	return err
 }
+
+// handleCancel cleans up after a job cancelled through the admin API. A job that
+// is not requeued will not run again, so it is reported as permanently failed.
+func (p *Processor) handleCancel(ctx context.Context, videoID, profile string, version int, preview *ffmpeg.EncodeResult, requeue bool) error {
+	log := logger.FromContext(ctx, p.logger)
+	metrics.JobsFailed.WithLabelValues("CANCELLED").Inc()
+	p.discardVersion(ctx, videoID, profile, version, preview)
+
+	p.publishEvent(ctx, &models.EncodeEvent{
+		Type:      models.EventFailed,
+		VideoID:   videoID,
+		Profile:   profile,
+		Version:   version,
+		ErrorCode: "CANCELLED",
+		Error:     "cancelled by operator",
+		WillRetry: requeue,
+	})
+
+	if requeue {
+		return fmt.Errorf("job for video %s cancelled for requeue", videoID)
+	}
+
+	p.retryMu.Lock()
+	delete(p.retryTracker, videoID)
+	p.retryMu.Unlock()
+
+	// The job context is cancelled, but the report must still go out
+	ctx = context.WithoutCancel(ctx)
+	if _, err := p.grpcClient.HandleVideoFailure(ctx, videoID, "cancelled by operator", "CANCELLED", p.store.Current().Retry.MaxRetries); err != nil {
+		log.WithError(err).Error("Failed to report cancelled video to management API")
+	}
+	return fmt.Errorf("job for video %s cancelled", videoID)
+}
+
+// publishFailed publishes a failed event. The message is redelivered unless
+// this was the last delivery JetStream allows.
+func (p *Processor) publishFailed(ctx context.Context, videoID, profile string, version int, err error, errorCode string) {
+	attempt := 1
+	if job := jobs.FromContext(ctx); job != nil {
+		attempt = job.Attempt
+	}
+	p.publishEvent(ctx, &models.EncodeEvent{
+		Type:      models.EventFailed,
+		VideoID:   videoID,
+		Profile:   profile,
+		Version:   version,
+		ErrorCode: errorCode,
+		Error:     err.Error(),
+		WillRetry: attempt <= p.store.Current().Retry.MaxRetries,
+	})
+}
+
+// sourcePath returns where the uploaded source of msg is inside the input
+// directory
+func sourcePath(config *configs.Config, msg *models.VideoUploadMessage) string {
+	return filepath.Join(config.Paths.InputVideoPath, filepath.Base(msg.UploadFilePath))
+}
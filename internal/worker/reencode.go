@@ -0,0 +1,224 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Tungwong-Project/tungwong-video-worker/configs"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/disk"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/ffmpeg"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/grpc"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/jobs"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/metrics"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/models"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/output"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/tracing"
+	"github.com/Tungwong-Project/tungwong-video-worker/pkg/logger"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Reencode encodes an existing video again from its archived original into a
+// new output version. The published version is left alone: the new one is
+// reported as version_ready, and a failed re-encode is not reported to
+// video-management as a failed video.
+func (p *Processor) Reencode(ctx context.Context, msg *models.ReencodeMessage) error {
+	log := logger.FromContext(ctx, p.logger)
+	videoID := msg.VideoID
+	config := p.store.Current()
+	started := time.Now()
+
+	metrics.JobsStarted.Inc()
+	metrics.JobsInFlight.Inc()
+	defer metrics.JobsInFlight.Dec()
+
+	// Neither a missing profile nor a missing original appears on redelivery
+	profile, ok := config.Profile(msg.Profile)
+	if !ok {
+		err := jobs.Terminal("UNKNOWN_PROFILE", fmt.Errorf("unknown encoding profile %q", msg.Profile))
+		return p.reencodeFailed(ctx, msg, 0, err, "UNKNOWN_PROFILE")
+	}
+
+	inputPath, err := reencodeSource(config, msg)
+	if errors.Is(err, os.ErrNotExist) {
+		err = jobs.Terminal("SOURCE_NOT_FOUND", err)
+	}
+	if err != nil {
+		return p.reencodeFailed(ctx, msg, 0, err, "SOURCE_NOT_FOUND")
+	}
+
+	version, err := p.output.Claim(videoID)
+	if err != nil {
+		return p.reencodeFailed(ctx, msg, 0, err, "OUTPUT_FAILED")
+	}
+
+	log.WithFields(logrus.Fields{
+		"video_id": videoID,
+		"source":   inputPath,
+		"profile":  profile.Name,
+		"version":  version,
+	}).Info("Starting re-encode")
+	p.publishEvent(ctx, &models.EncodeEvent{Type: models.EventStarted, VideoID: videoID, Profile: profile.Name, Version: version})
+
+	opts := ffmpeg.EncodeOptions{
+		Profile: profile,
+		Version: version,
+		Tracker: p.newProgressEvents(ctx, config, videoID, profile.Name, version),
+	}
+	for _, sub := range msg.Subtitles {
+		opts.Subtitles = append(opts.Subtitles, ffmpeg.SubtitleInput{
+			Path:     locateArchived(config, videoID, filepath.Join(config.Paths.InputVideoPath, filepath.Base(sub.FilePath))),
+			Language: sub.Language,
+			Label:    sub.Label,
+			Default:  sub.Default,
+		})
+	}
+
+	encodeStart := time.Now()
+	encodeCtx, span := tracing.Tracer().Start(ctx, "encode", trace.WithAttributes(
+		attribute.String("video.id", videoID),
+		attribute.String("profile", profile.Name),
+		attribute.Int("version", version),
+	))
+	result, err := p.encoder.EncodeToHLS(encodeCtx, inputPath, videoID, opts)
+	tracing.End(span, err)
+	if err != nil {
+		errorCode := "ENCODING_FAILED"
+		if job := jobs.FromContext(ctx); job != nil {
+			if cancelled, _ := job.Cancelled(); cancelled {
+				errorCode = "CANCELLED"
+			}
+		}
+		if disk.IsFull(err) {
+			errorCode = "DISK_FULL"
+		}
+		return p.reencodeFailed(ctx, msg, version, err, errorCode)
+	}
+	recordEncodeMetrics(result, time.Since(encodeStart))
+
+	if job := jobs.FromContext(ctx); job != nil {
+		job.SetStage("report")
+	}
+	if err := p.reportVersion(ctx, videoID, version, profile.Name, result); err != nil {
+		return p.reencodeFailed(ctx, msg, version, err, "STATUS_UPDATE_FAILED")
+	}
+	p.recordVersion(ctx, config, videoID, output.Version{
+		Version:    version,
+		Status:     "version_ready",
+		Profile:    profile.Name,
+		Renditions: result.Renditions,
+		Reason:     msg.Reason,
+	}, result)
+
+	p.publishEvent(ctx, &models.EncodeEvent{
+		Type:          models.EventCompleted,
+		VideoID:       videoID,
+		Profile:       profile.Name,
+		Version:       version,
+		HLSPath:       result.HLSPath,
+		ThumbnailPath: result.ThumbnailPath,
+		Duration:      result.Duration,
+		EncodeSeconds: time.Since(started).Seconds(),
+		Renditions:    result.Renditions,
+	})
+	metrics.JobsSucceeded.Inc()
+	log.WithFields(logrus.Fields{
+		"video_id": videoID,
+		"version":  version,
+		"hls_path": result.HLSPath,
+	}).Info("Re-encode completed")
+	return nil
+}
+
+// reportVersion reports the new version to video-management, retrying failed calls
+func (p *Processor) reportVersion(ctx context.Context, videoID string, version int, profile string, result *ffmpeg.EncodeResult) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "report")
+	defer func() { tracing.End(span, err) }()
+
+	completion := grpc.Completion{Version: version, Profile: profile}
+	report := func() error {
+		return p.grpcClient.ReportVideoVersion(ctx, videoID, result.HLSPath, result.ThumbnailPath, result.Duration, completion)
+	}
+	if err = report(); err != nil {
+		logger.FromContext(ctx, p.logger).WithError(err).Error("Failed to report new version, retrying")
+		if err = p.grpcClient.WithRetry(ctx, report, 3); err != nil {
+			return fmt.Errorf("failed to report version after retries: %w", err)
+		}
+	}
+	return nil
+}
+
+// reencodeFailed removes the partial version and publishes a failed event. The
+// published version is untouched, so video-management is not told.
+func (p *Processor) reencodeFailed(ctx context.Context, msg *models.ReencodeMessage, version int, err error, errorCode string) error {
+	logger.FromContext(ctx, p.logger).WithError(err).WithFields(logrus.Fields{
+		"video_id": msg.VideoID,
+		"version":  version,
+	}).Error("Re-encode failed")
+	metrics.JobsFailed.WithLabelValues(errorCode).Inc()
+
+	if version > 0 {
+		p.encoder.CleanupVersion(msg.VideoID, version)
+	}
+
+	attempt := 1
+	_, terminal := jobs.AsTerminal(err)
+	willRetry := !terminal
+	if job := jobs.FromContext(ctx); job != nil {
+		attempt = job.Attempt
+		if cancelled, requeue := job.Cancelled(); cancelled {
+			willRetry = requeue
+		}
+	}
+	p.publishEvent(ctx, &models.EncodeEvent{
+		Type:      models.EventFailed,
+		VideoID:   msg.VideoID,
+		Profile:   msg.Profile,
+		Version:   version,
+		ErrorCode: errorCode,
+		Error:     err.Error(),
+		WillRetry: willRetry && attempt <= p.store.Current().Retry.MaxRetries,
+	})
+	return err
+}
+
+// reencodeSource finds the original of a video: the named file or its
+// mezzanine in the archive or, when originals are kept, in the input
+// directory. Without a name the largest file in the archive is used. A
+// missing original is reported as os.ErrNotExist.
+func reencodeSource(config *configs.Config, msg *models.ReencodeMessage) (string, error) {
+	dir := archiveDir(config, msg.VideoID)
+	if msg.FileName != "" {
+		path := locateSource(config, msg.VideoID, filepath.Join(config.Paths.InputVideoPath, msg.FileName))
+		if !fileExists(path) {
+			return "", fmt.Errorf("original %s of video %s is neither in %s nor in %s: %w", msg.FileName, msg.VideoID, dir, config.Paths.InputVideoPath, os.ErrNotExist)
+		}
+		return path, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("failed to read archive directory: %w", err)
+	}
+	var largest string
+	var largestSize int64 = -1
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || strings.HasSuffix(entry.Name(), ".partial") {
+			continue
+		}
+		if info.Size() > largestSize {
+			largest, largestSize = filepath.Join(dir, entry.Name()), info.Size()
+		}
+	}
+	if largest == "" {
+		return "", fmt.Errorf("no archived original of video %s in %s: %w", msg.VideoID, dir, os.ErrNotExist)
+	}
+	return largest, nil
+}
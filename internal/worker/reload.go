@@ -0,0 +1,150 @@
+package worker
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/Tungwong-Project/tungwong-video-worker/configs"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/metrics"
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+// reloadDebounce groups the burst of events an editor or a ConfigMap update
+// produces into a single reload
+const reloadDebounce = 500 * time.Millisecond
+
+// watchConfig reloads the configuration on SIGHUP and whenever the config file
+// or the profiles file changes, until ctx is done
+func (w *Worker) watchConfig(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		w.logger.WithError(err).Warn("Failed to watch config files, reload with SIGHUP instead")
+	} else {
+		defer watcher.Close()
+		w.watchFiles(watcher)
+	}
+
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	if watcher != nil {
+		events, errs = watcher.Events, watcher.Errors
+	}
+
+	debounce := time.NewTimer(0)
+	<-debounce.C
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			w.logger.Info("Received SIGHUP, reloading configuration")
+			w.reload()
+		case event := <-events:
+			if w.isWatchedFile(event.Name) {
+				debounce.Reset(reloadDebounce)
+			}
+		case <-debounce.C:
+			w.logger.Info("Config file changed, reloading configuration")
+			w.reload()
+			w.watchFiles(watcher) // The profiles file may have moved
+		case err := <-errs:
+			w.logger.WithError(err).Warn("Config file watcher error")
+		}
+	}
+}
+
+// watchedFiles returns the files whose changes trigger a reload
+func (w *Worker) watchedFiles() []string {
+	var files []string
+	for _, path := range []string{w.config.Path(), w.config.Current().Profiles.File} {
+		if path != "" {
+			files = append(files, filepath.Clean(path))
+		}
+	}
+	return files
+}
+
+func (w *Worker) isWatchedFile(name string) bool {
+	for _, file := range w.watchedFiles() {
+		if filepath.Clean(name) == file {
+			return true
+		}
+	}
+	return false
+}
+
+// watchFiles watches the directories holding the config files rather than the
+// files themselves, so replacing a file by rename is still noticed
+func (w *Worker) watchFiles(watcher *fsnotify.Watcher) {
+	if watcher == nil {
+		return
+	}
+	for _, file := range w.watchedFiles() {
+		dir := filepath.Dir(file)
+		if err := watcher.Add(dir); err != nil {
+			w.logger.WithError(err).WithField("dir", dir).Warn("Failed to watch config directory")
+		}
+	}
+}
+
+// reload applies the current config file and environment, keeping the old
+// configuration if the new one is invalid
+func (w *Worker) reload() {
+	result, err := w.config.Reload()
+	if err != nil {
+		metrics.ConfigReloads.WithLabelValues("failed").Inc()
+		w.logger.WithError(err).Error("Configuration reload rejected, keeping current settings")
+		return
+	}
+
+	if len(result.Ignored) > 0 {
+		w.logger.WithField("settings", result.Ignored).Warn("Changed settings need a restart to take effect, keeping current values")
+	}
+	if len(result.Changed) == 0 {
+		metrics.ConfigReloads.WithLabelValues("unchanged").Inc()
+		w.logger.Info("Configuration reloaded, nothing to apply")
+		return
+	}
+
+	metrics.ConfigReloads.WithLabelValues("applied").Inc()
+	w.logger.WithField("settings", result.Changed).Info("Configuration reloaded, new jobs use the updated settings")
+}
+
+// applyConfig pushes reloaded settings to the components that do not read
+// the config store themselves
+func (w *Worker) applyConfig(old, next *configs.Config) {
+	w.encoder.SetConfig(&next.FFmpeg)
+
+	if next.Worker.MaxConcurrentJobs != old.Worker.MaxConcurrentJobs {
+		w.consumer.SetMaxJobs(next.Worker.MaxConcurrentJobs)
+		metrics.MaxConcurrentJobs.Set(float64(next.Worker.MaxConcurrentJobs))
+	}
+
+	if next.Worker.Threads() != old.Worker.Threads() || next.Worker.ScratchBudgetMB != old.Worker.ScratchBudgetMB {
+		w.budget.SetLimits(next.Worker.Threads(), int64(next.Worker.ScratchBudgetMB)<<20)
+		w.chunkBudget.SetLimits(next.Worker.Threads(), 0)
+	}
+
+	if !slices.Equal(next.Priority.Weights, old.Priority.Weights) {
+		w.consumer.SetWeights(next.Priority.Weights)
+	}
+
+	if next.Retry.MaxRetries != old.Retry.MaxRetries {
+		w.consumer.SetMaxRetries(next.Retry.MaxRetries)
+		w.grpcClient.SetMaxRetries(next.Retry.MaxRetries)
+	}
+
+	if level, err := logrus.ParseLevel(next.LogLevel); err == nil {
+		w.logger.SetLevel(level)
+	}
+}
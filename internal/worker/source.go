@@ -0,0 +1,219 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/Tungwong-Project/tungwong-video-worker/configs"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/ffmpeg"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/metrics"
+	"github.com/Tungwong-Project/tungwong-video-worker/pkg/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// sourceOutcome is what happened to an uploaded original after its encode
+type sourceOutcome struct {
+	Action string // keep, delete, archive or mezzanine
+	Path   string // Where the original or its mezzanine is now; empty once deleted
+}
+
+// archiveDir is where the archive and mezzanine actions put a video's original
+func archiveDir(config *configs.Config, videoID string) string {
+	return filepath.Join(config.Source.ArchivePath, videoID)
+}
+
+// mezzaninePath is the mezzanine of inputPath in the video's archive directory
+func mezzaninePath(config *configs.Config, videoID, inputPath string) string {
+	base := filepath.Base(inputPath)
+	return filepath.Join(archiveDir(config, videoID), strings.TrimSuffix(base, filepath.Ext(base))+ffmpeg.MezzanineExt)
+}
+
+// locateSource returns inputPath or, once an earlier attempt archived it, the
+// archived original or its mezzanine
+func locateSource(config *configs.Config, videoID, inputPath string) string {
+	if path := locateArchived(config, videoID, inputPath); path != inputPath {
+		return path
+	}
+	if mezzanine := mezzaninePath(config, videoID, inputPath); fileExists(mezzanine) {
+		return mezzanine
+	}
+	return inputPath
+}
+
+// locateArchived returns path or, once an earlier attempt archived it, its
+// copy in the video's archive directory
+func locateArchived(config *configs.Config, videoID, path string) string {
+	if fileExists(path) {
+		return path
+	}
+	if archived := filepath.Join(archiveDir(config, videoID), filepath.Base(path)); fileExists(archived) {
+		return archived
+	}
+	return path
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// plannedSource is what handleSource will do with the original, reported to
+// video-management before it runs
+func plannedSource(config *configs.Config, videoID, inputPath string) sourceOutcome {
+	dir := archiveDir(config, videoID)
+	switch {
+	case config.Source.Action == configs.SourceDelete:
+		return sourceOutcome{Action: configs.SourceDelete}
+	case filepath.Dir(inputPath) == dir:
+		// Archived by an earlier attempt
+		return sourceOutcome{Action: config.Source.Action, Path: inputPath}
+	case config.Source.Action == configs.SourceArchive:
+		return sourceOutcome{Action: configs.SourceArchive, Path: filepath.Join(dir, filepath.Base(inputPath))}
+	case config.Source.Action == configs.SourceMezzanine:
+		return sourceOutcome{Action: configs.SourceMezzanine, Path: mezzaninePath(config, videoID, inputPath)}
+	default:
+		return sourceOutcome{Action: configs.SourceKeep, Path: inputPath}
+	}
+}
+
+// handleSource applies the configured source action to the original and its
+// subtitle sidecars once the video's status is reported, so a retried report
+// never waits for an archive copy or mezzanine transcode. A job redelivered
+// before the action finished finds the original with locateSource. When an
+// action fails the original is kept where it is.
+func (p *Processor) handleSource(ctx context.Context, config *configs.Config, videoID, inputPath string, opts ffmpeg.EncodeOptions) sourceOutcome {
+	log := logger.FromContext(ctx, p.logger).WithFields(logrus.Fields{
+		"video_id": videoID,
+		"action":   config.Source.Action,
+	})
+
+	if config.Source.Action == configs.SourceDelete {
+		p.deleteSource(ctx, inputPath, opts.Subtitles)
+		return sourceOutcome{Action: configs.SourceDelete}
+	}
+	dir := archiveDir(config, videoID)
+	if filepath.Dir(inputPath) == dir {
+		// Archived by an earlier attempt whose status report failed
+		return sourceOutcome{Action: config.Source.Action, Path: inputPath}
+	}
+
+	var outcome sourceOutcome
+	var err error
+	switch config.Source.Action {
+	case configs.SourceArchive:
+		outcome, err = p.archiveSource(dir, inputPath, opts.Subtitles)
+	case configs.SourceMezzanine:
+		outcome, err = p.mezzanineSource(ctx, config, videoID, inputPath, opts)
+	default:
+		outcome = sourceOutcome{Action: configs.SourceKeep, Path: inputPath}
+	}
+	if err != nil {
+		metrics.SourceActions.WithLabelValues(config.Source.Action, "failed").Inc()
+		log.WithError(err).Warn("Failed to handle source file, keeping it")
+		return sourceOutcome{Action: configs.SourceKeep, Path: inputPath}
+	}
+
+	metrics.SourceActions.WithLabelValues(outcome.Action, "ok").Inc()
+	log.WithField("path", outcome.Path).Info("Handled source file")
+	return outcome
+}
+
+// archiveSource moves the original and its sidecars into dir
+func (p *Processor) archiveSource(dir, inputPath string, subtitles []ffmpeg.SubtitleInput) (sourceOutcome, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return sourceOutcome{}, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	archived := filepath.Join(dir, filepath.Base(inputPath))
+	if err := moveFile(inputPath, archived); err != nil {
+		return sourceOutcome{}, err
+	}
+	p.archiveSidecars(dir, subtitles)
+	return sourceOutcome{Action: configs.SourceArchive, Path: archived}, nil
+}
+
+// mezzanineSource transcodes the original into the archive directory, removes
+// it and moves its sidecars next to the mezzanine
+func (p *Processor) mezzanineSource(ctx context.Context, config *configs.Config, videoID, inputPath string, opts ffmpeg.EncodeOptions) (sourceOutcome, error) {
+	dir := archiveDir(config, videoID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return sourceOutcome{}, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	mezzanine := mezzaninePath(config, videoID, inputPath)
+	if err := p.encoder.EncodeMezzanine(ctx, inputPath, mezzanine, config.Source, opts.Tracker); err != nil {
+		return sourceOutcome{}, err
+	}
+	if err := os.Remove(inputPath); err != nil {
+		logger.FromContext(ctx, p.logger).WithError(err).WithField("path", inputPath).Warn("Failed to remove original after mezzanine transcode")
+	}
+	p.archiveSidecars(dir, opts.Subtitles)
+	return sourceOutcome{Action: configs.SourceMezzanine, Path: mezzanine}, nil
+}
+
+func (p *Processor) archiveSidecars(dir string, subtitles []ffmpeg.SubtitleInput) {
+	for _, sub := range subtitles {
+		if err := moveFile(sub.Path, filepath.Join(dir, filepath.Base(sub.Path))); err != nil && !errors.Is(err, os.ErrNotExist) {
+			p.logger.WithError(err).WithField("path", sub.Path).Warn("Failed to archive subtitle sidecar")
+		}
+	}
+}
+
+// deleteSource removes the original and its sidecars
+func (p *Processor) deleteSource(ctx context.Context, inputPath string, subtitles []ffmpeg.SubtitleInput) {
+	log := logger.FromContext(ctx, p.logger)
+	paths := []string{inputPath}
+	for _, sub := range subtitles {
+		paths = append(paths, sub.Path)
+	}
+
+	result := "ok"
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.WithError(err).WithField("path", path).Warn("Failed to delete source file")
+			result = "failed"
+		}
+	}
+	metrics.SourceActions.WithLabelValues(configs.SourceDelete, result).Inc()
+	if result == "ok" {
+		log.WithField("path", inputPath).Info("Deleted source file")
+	}
+}
+
+// moveFile renames src to dst, copying it when they are on different volumes
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	partial := dst + ".partial"
+	out, err := os.Create(partial)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(partial)
+		return fmt.Errorf("failed to copy %s: %w", src, err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(partial)
+		return fmt.Errorf("failed to copy %s: %w", src, err)
+	}
+	if err := os.Rename(partial, dst); err != nil {
+		os.Remove(partial)
+		return err
+	}
+	return os.Remove(src)
+}
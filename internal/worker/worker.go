@@ -5,57 +5,141 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/Tungwong-Project/tungwong-video-worker/configs"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/admin"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/disk"
 	"github.com/Tungwong-Project/tungwong-video-worker/internal/ffmpeg"
 	"github.com/Tungwong-Project/tungwong-video-worker/internal/grpc"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/health"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/httpserver"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/jobs"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/metrics"
 	"github.com/Tungwong-Project/tungwong-video-worker/internal/nats"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/output"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/resources"
 	"github.com/sirupsen/logrus"
 )
 
 type Worker struct {
-	config     *configs.Config
-	consumer   *nats.Consumer
-	processor  *Processor
-	grpcClient *grpc.VideoManagementClient
-	logger     *logrus.Logger
+	config      *configs.Store
+	consumer    *nats.Consumer
+	encoder     *ffmpeg.Encoder
+	budget      *resources.Budget
+	chunkBudget *resources.Budget
+	processor   *Processor
+	grpcClient  *grpc.VideoManagementClient
+	httpServer  *httpserver.Server
+	health      *health.Checker
+	logger      *logrus.Logger
 }
 
-func NewWorker(config *configs.Config, logger *logrus.Logger) (*Worker, error) {
+func NewWorker(store *configs.Store, logger *logrus.Logger) (*Worker, error) {
+	config := store.Current()
+
 	// Initialize gRPC client
 	grpcClient, err := grpc.NewVideoManagementClient(
-		config.GRPC.VideoManagementURL,
+		config.GRPC,
 		config.Worker.ID,
 		logger,
 	)
 	if err != nil {
 		return nil, err
 	}
+	grpcClient.SetMaxRetries(config.Retry.MaxRetries)
 
 	// Initialize FFmpeg encoder
 	encoder := ffmpeg.NewEncoder(&config.FFmpeg, &config.Paths, logger)
 
+	// Admit encodes against the CPU threads and scratch space of this machine
+	budget := resources.NewBudget(config.Worker.Threads(), int64(config.Worker.ScratchBudgetMB)<<20)
+	encoder.SetBudget(budget)
+
+	// Chunks served for other workers get their own pool so they never wait
+	// behind jobs that are waiting for them
+	chunkBudget := resources.NewChunkBudget(config.Worker.Threads())
+	encoder.SetChunkBudget(chunkBudget)
+
+	// Reserve each job's estimated output against free disk space, keeping the
+	// readiness headroom free
+	encoder.SetSpace(disk.NewSpace(uint64(config.HTTP.MinFreeDiskMB) << 20))
+
+	// Every encode writes a new version of the video's output, described by its manifest
+	outputs := output.NewStore(config.Paths.OutputHLSPath, config.Paths.OutputThumbnailPath, logger)
+
 	// Initialize processor
-	processor := NewProcessor(encoder, grpcClient, config, logger)
+	processor := NewProcessor(encoder, outputs, grpcClient, store, logger)
 
 	// Initialize NATS consumer
-	consumer, err := nats.NewConsumer(config, processor, logger)
+	registry := jobs.NewRegistry()
+	consumer, err := nats.NewConsumer(config, processor, registry, logger)
 	if err != nil {
 		grpcClient.Close()
 		return nil, err
 	}
 
-	return &Worker{
-		config:     config,
-		consumer:   consumer,
-		processor:  processor,
-		grpcClient: grpcClient,
-		logger:     logger,
-	}, nil
+	processor.SetEventPublisher(consumer)
+	consumer.SetPrioritizer(processor)
+
+	// Share split-encode chunks with other workers over NATS
+	if config.FFmpeg.Split.Enabled && config.FFmpeg.Split.Remote {
+		if err := consumer.ServeChunks(encoder); err != nil {
+			consumer.Stop()
+			grpcClient.Close()
+			return nil, err
+		}
+		encoder.SetChunkDispatcher(consumer)
+	}
+
+	metrics.MaxConcurrentJobs.Set(float64(config.Worker.MaxConcurrentJobs))
+
+	checker := newHealthChecker(config, consumer, grpcClient)
+	httpServer := httpserver.NewServer(config.HTTP.Addr, logger)
+	httpServer.Handle("/healthz", checker.LivenessHandler())
+	httpServer.Handle("/readyz", checker.ReadinessHandler())
+
+	// The admin API cancels jobs and stops consumption, so it is never served without a token
+	if config.HTTP.AdminToken != "" {
+		httpServer.Handle("/admin/", admin.NewAPI(registry, consumer, config.HTTP.AdminToken, logger).Handler())
+	} else {
+		logger.Info("ADMIN_TOKEN is not set, admin API is disabled")
+	}
+
+	w := &Worker{
+		config:      store,
+		consumer:    consumer,
+		encoder:     encoder,
+		budget:      budget,
+		chunkBudget: chunkBudget,
+		processor:   processor,
+		grpcClient:  grpcClient,
+		httpServer:  httpServer,
+		health:      checker,
+		logger:      logger,
+	}
+	store.OnReload(w.applyConfig)
+	return w, nil
+}
+
+// newHealthChecker registers the readiness checks for every dependency a job needs
+func newHealthChecker(config *configs.Config, consumer *nats.Consumer, grpcClient *grpc.VideoManagementClient) *health.Checker {
+	checker := health.NewChecker()
+	checker.Register("nats", consumer.Healthy)
+	checker.Register("grpc", grpcClient.Healthy)
+	checker.Register("ffmpeg", health.BinaryCheck("ffmpeg"))
+	checker.Register("ffprobe", health.BinaryCheck("ffprobe"))
+
+	minFree := uint64(config.HTTP.MinFreeDiskMB) << 20
+	checker.Register("hls_output", health.DirectoryCheck(config.Paths.OutputHLSPath, minFree))
+	checker.Register("thumbnail_output", health.DirectoryCheck(config.Paths.OutputThumbnailPath, minFree))
+	checker.Register("temp", health.DirectoryCheck(config.Paths.TempPath, minFree))
+
+	return checker
 }
 
 func (w *Worker) Start() error {
-	w.logger.WithField("worker_id", w.config.Worker.ID).Info("Starting video worker")
+	w.logger.WithField("worker_id", w.config.Current().Worker.ID).Info("Starting video worker")
 
 	// Create context that cancels on SIGINT/SIGTERM
 	ctx, cancel := context.WithCancel(context.Background())
@@ -68,9 +152,20 @@ func (w *Worker) Start() error {
 	go func() {
 		<-sigChan
 		w.logger.Info("Received shutdown signal, gracefully stopping...")
+		w.health.SetDraining()
 		cancel()
 	}()
 
+	// Apply config changes on SIGHUP or when the config file is written
+	go w.watchConfig(ctx)
+
+	// Remove staging directories left behind by crashed jobs
+	go w.collectTemp(ctx)
+
+	// Serve metrics and health endpoints while the worker runs
+	w.httpServer.Start()
+	defer w.shutdownHTTP()
+
 	// Start consuming messages
 	if err := w.consumer.Start(ctx); err != nil {
 		return err
@@ -79,6 +174,15 @@ func (w *Worker) Start() error {
 	return nil
 }
 
+func (w *Worker) shutdownHTTP() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := w.httpServer.Shutdown(ctx); err != nil {
+		w.logger.WithError(err).Error("Error stopping HTTP server")
+	}
+}
+
 func (w *Worker) Stop() error {
 	w.logger.Info("Stopping worker")
 
@@ -1,26 +1,63 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"os"
+	"time"
 
 	"github.com/Tungwong-Project/tungwong-video-worker/configs"
+	"github.com/Tungwong-Project/tungwong-video-worker/internal/tracing"
 	"github.com/Tungwong-Project/tungwong-video-worker/internal/worker"
 	"github.com/Tungwong-Project/tungwong-video-worker/pkg/logger"
 )
 
 func main() {
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML config file; environment variables override it")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration with secrets redacted and exit")
+	flag.Parse()
+
 	// Load configuration
-	config := configs.LoadConfig()
+	config, err := configs.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(1)
+	}
+
+	if *printConfig {
+		if err := config.LoadProfiles(); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid encoding profiles:\n%v\n", err)
+			os.Exit(1)
+		}
+		if err := config.Print(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to print configuration: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	// Initialize logger
-	log := logger.NewLogger(config.LogLevel)
+	log := logger.NewLogger(config.LogLevel, config.LogFormat)
+
+	if err := config.LoadProfiles(); err != nil {
+		log.WithError(err).Fatal("Failed to load encoding profiles")
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), config.Tracing, config.Worker.ID)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to set up tracing")
+	}
 
 	log.Info("🎬 Tungwong Video Worker Starting...")
 	log.WithFields(map[string]interface{}{
 		"worker_id":            config.Worker.ID,
 		"max_concurrent_jobs":  config.Worker.MaxConcurrentJobs,
-		"nats_url":             config.NATS.URL,
+		"nats_url":             configs.RedactURLs(config.NATS.URL),
 		"video_management_url": config.GRPC.VideoManagementURL,
+		"config_file":          *configPath,
+		"default_profile":      config.Profiles.Default,
+		"otlp_endpoint":        config.Tracing.Endpoint,
 	}).Info("Configuration loaded")
 
 	// Create output directories
@@ -28,6 +65,7 @@ func main() {
 		config.Paths.InputVideoPath,
 		config.Paths.OutputHLSPath,
 		config.Paths.OutputThumbnailPath,
+		config.Paths.TempPath,
 	}
 	for _, dir := range dirs {
 		if err := os.MkdirAll(dir, 0755); err != nil {
@@ -36,13 +74,22 @@ func main() {
 	}
 
 	// Initialize and start worker
-	w, err := worker.NewWorker(config, log)
+	w, err := worker.NewWorker(configs.NewStore(config, *configPath), log)
 	if err != nil {
 		log.WithError(err).Fatal("Failed to create worker")
 	}
 
 	// Start worker (blocks until shutdown signal)
-	if err := w.Start(); err != nil {
+	err = w.Start()
+
+	// Flush spans of the last jobs before exiting
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := shutdownTracing(ctx); err != nil {
+		log.WithError(err).Warn("Failed to flush traces")
+	}
+	cancel()
+
+	if err != nil {
 		log.WithError(err).Error("Worker stopped with error")
 		os.Exit(1)
 	}
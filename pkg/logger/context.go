@@ -0,0 +1,23 @@
+package logger
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+type contextKey struct{}
+
+// NewContext returns a context carrying a log entry, so everything logged for
+// a job shares its fields
+func NewContext(ctx context.Context, entry *logrus.Entry) context.Context {
+	return context.WithValue(ctx, contextKey{}, entry)
+}
+
+// FromContext returns the entry carried by ctx, or fallback if there is none
+func FromContext(ctx context.Context, fallback logrus.FieldLogger) logrus.FieldLogger {
+	if entry, ok := ctx.Value(contextKey{}).(*logrus.Entry); ok {
+		return entry
+	}
+	return fallback
+}
@@ -6,14 +6,22 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func NewLogger(level string) *logrus.Logger {
+// NewLogger creates the application logger. format is "json" for one JSON
+// object per line, anything else gives human-readable text.
+func NewLogger(level, format string) *logrus.Logger {
 	logger := logrus.New()
 
 	// Set log format
-	logger.SetFormatter(&logrus.TextFormatter{
-		FullTimestamp:   true,
-		TimestampFormat: "2006-01-02 15:04:05",
-	})
+	if format == "json" {
+		logger.SetFormatter(&logrus.JSONFormatter{
+			TimestampFormat: "2006-01-02T15:04:05.000Z07:00",
+		})
+	} else {
+		logger.SetFormatter(&logrus.TextFormatter{
+			FullTimestamp:   true,
+			TimestampFormat: "2006-01-02 15:04:05",
+		})
+	}
 
 	// Set output
 	logger.SetOutput(os.Stdout)
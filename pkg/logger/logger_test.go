@@ -0,0 +1,67 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestNewLogger(t *testing.T) {
+	tests := []struct {
+		level     string
+		format    string
+		wantLevel logrus.Level
+		wantJSON  bool
+	}{
+		{level: "debug", format: "json", wantLevel: logrus.DebugLevel, wantJSON: true},
+		{level: "warn", format: "text", wantLevel: logrus.WarnLevel},
+		{level: "loud", format: "", wantLevel: logrus.InfoLevel},
+	}
+	for _, tt := range tests {
+		t.Run(tt.level+"/"+tt.format, func(t *testing.T) {
+			logger := NewLogger(tt.level, tt.format)
+			if logger.GetLevel() != tt.wantLevel {
+				t.Fatalf("level = %s, want %s", logger.GetLevel(), tt.wantLevel)
+			}
+			var out bytes.Buffer
+			logger.SetOutput(&out)
+			logger.WithField("video_id", "video-1").Warn("Encoding slowly")
+
+			var line map[string]any
+			err := json.Unmarshal(out.Bytes(), &line)
+			if tt.wantJSON != (err == nil) {
+				t.Fatalf("output %q, want JSON %v", out.String(), tt.wantJSON)
+			}
+			if tt.wantJSON && (line["video_id"] != "video-1" || line["msg"] != "Encoding slowly" || line["level"] != "warning") {
+				t.Fatalf("JSON line = %v", line)
+			}
+		})
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	fallback := logrus.New()
+	if FromContext(context.Background(), fallback) != fallback {
+		t.Fatal("context without an entry does not return the fallback")
+	}
+
+	var out bytes.Buffer
+	fallback.SetOutput(&out)
+	fallback.SetFormatter(&logrus.JSONFormatter{})
+	entry := fallback.WithFields(logrus.Fields{"video_id": "video-1", "job_id": "42"})
+	ctx := NewContext(context.Background(), entry)
+	FromContext(ctx, fallback).WithField("stage", "encode").Info("Stage started")
+
+	var line map[string]any
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"video_id": "video-1", "job_id": "42", "stage": "encode"} {
+		if line[key] != want {
+			t.Fatalf("%s = %v, want %s in %v", key, line[key], want, line)
+		}
+	}
+}